@@ -0,0 +1,30 @@
+/*
+Command migrate-ids moves the dashboard configurations and webhooks stored in Firestore by earlier versions
+of the service, to documents named after their id. Run it once from the root of the project, with the
+same firestore_key.json, $FIRESTORE_PROJECT_ID and $FIRESTORE_EMULATOR_HOST as the service.
+*/
+package main
+
+import (
+	"assignment2/store"
+	"context"
+	"log"
+	"os"
+	"strconv"
+)
+
+func main() {
+	ctx := context.Background()
+
+	client, err := store.NewFirestoreClient(ctx, os.Getenv("FIRESTORE_PROJECT_ID"), "firestore_key.json")
+	if err != nil {
+		log.Fatal("Connecting to Firestore failed. Error:", err)
+	}
+	defer client.Close()
+
+	moved, err := store.MigrateDocumentIDs(ctx, client)
+	if err != nil {
+		log.Fatal("Migration failed after "+strconv.Itoa(moved)+" documents. Error:", err)
+	}
+	log.Println("Migrated " + strconv.Itoa(moved) + " documents")
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request to issue an API key
+type apiKeyRequest struct {
+	Owner string   `json:"owner"`
+	Roles []string `json:"roles"`
+}
+
+// An API key as shown to the client. The key itself is only shown when it is issued
+type apiKeyResponse struct {
+	ID        string   `json:"id"`
+	Key       string   `json:"key,omitempty"`
+	Owner     string   `json:"owner"`
+	Roles     []string `json:"roles"`
+	CreatedAt string   `json:"createdAt"`
+	Revoked   bool     `json:"revoked"`
+}
+
+// Function to create the desired structure of a stored API key
+func toAPIKeyResponse(key utils.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:        key.ID,
+		Owner:     key.Owner,
+		Roles:     key.Roles,
+		CreatedAt: key.CreatedAt.Format("20060102 15:04"),
+		Revoked:   key.Revoked,
+	}
+}
+
+/*
+Handler for the API keys, only available to admins:
+POST to issue a key, GET to view one or all keys and DELETE {id} to revoke a key
+*/
+func APIKeyHandler(keys store.APIKeyStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if principal, ok := principalOf(r); ok && !principal.HasRole(RoleAdmin) {
+			http.Error(w, "Only admins can manage API keys", http.StatusForbidden)
+			return
+		}
+
+		keyID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, utils.API_KEY_PATH), "/")
+
+		switch {
+		case r.Method == http.MethodPost && keyID == "":
+			issueAPIKey(w, r, keys)
+		case r.Method == http.MethodGet:
+			getAPIKeys(w, r, keys, keyID)
+		case r.Method == http.MethodDelete && keyID != "":
+			revokeAPIKey(w, r, keys, keyID)
+		default:
+			http.Error(w, "Method "+r.Method+" not supported for "+r.URL.Path, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// Issues a new API key for an owner. The key is only part of this response, as just its hash is stored
+func issueAPIKey(w http.ResponseWriter, r *http.Request, keys store.APIKeyStore) {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	var request apiKeyRequest
+	if err := decoder.Decode(&request); err != nil {
+		http.Error(w, "Error: decoding JSON, Invalid input \n"+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if utils.IsEmptyField(request.Owner) {
+		http.Error(w, "Invalid input: Field 'owner' is empty", http.StatusBadRequest)
+		return
+	}
+	if len(request.Roles) == 0 {
+		http.Error(w, "Invalid input: Field 'roles' is empty. Supported: "+strings.Join(roleOrder, ", "), http.StatusBadRequest)
+		return
+	}
+	for _, role := range request.Roles {
+		if roleRank(role) < 0 {
+			http.Error(w, "Invalid input: Unknown role '"+role+"'. Supported: "+strings.Join(roleOrder, ", "), http.StatusBadRequest)
+			return
+		}
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		log.Println("Error generating API key:", err)
+		http.Error(w, "Failed to issue API key", http.StatusInternalServerError)
+		return
+	}
+
+	key := utils.APIKey{
+		Owner:     strings.TrimSpace(request.Owner),
+		Roles:     request.Roles,
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now(),
+	}
+	key.ID, err = keys.Create(r.Context(), key)
+	if err != nil {
+		log.Println("Error adding API key:", err)
+		http.Error(w, "Failed to issue API key", http.StatusInternalServerError)
+		return
+	}
+
+	response := toAPIKeyResponse(key)
+	response.Key = key.ID + "." + secret
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, response)
+}
+
+// Gets one API key based on its ID. If no ID is provided it gets a page of all API keys
+func getAPIKeys(w http.ResponseWriter, r *http.Request, keys store.APIKeyStore, keyID string) {
+	if keyID != "" {
+		key, err := keys.Get(r.Context(), keyID)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "API key with ID "+keyID+" not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("Error retrieving API key:", err)
+			http.Error(w, "Error retrieving API key", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, toAPIKeyResponse(key))
+		return
+	}
+
+	opts, err := listOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	all, err := keys.List(r.Context(), opts)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor '"+opts.Cursor+"'. Use the nextCursor of the previous page", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to iterate: %v", err)
+		http.Error(w, "Error retrieving API keys", http.StatusInternalServerError)
+		return
+	}
+
+	page := store.Page[apiKeyResponse]{
+		Items:      make([]apiKeyResponse, 0, len(all.Items)),
+		NextCursor: all.NextCursor,
+		Total:      all.Total,
+	}
+	for _, key := range all.Items {
+		page.Items = append(page.Items, toAPIKeyResponse(key))
+	}
+	writePage(w, page)
+}
+
+// Revokes an API key, which is kept so it can still be listed
+func revokeAPIKey(w http.ResponseWriter, r *http.Request, keys store.APIKeyStore, keyID string) {
+	err := keys.Revoke(r.Context(), keyID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "API key with ID "+keyID+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println("Error revoking API key:", err)
+		http.Error(w, "Error revoking API key", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test of issuing, listing and revoking API keys
+func TestAPIKeyHandler(t *testing.T) {
+	keys := store.NewMemoryAPIKeys()
+	handler := Authenticate(keys, "admin-key", nil, APIKeyHandler(keys))
+
+	// Sends a request with the API key to the handler, and returns the response
+	send := func(key string, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, utils.API_KEY_PATH+path, strings.NewReader(body))
+		req.Header.Set(APIKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// The key is only shown when it is issued, and works right away
+	rr := send("admin-key", http.MethodPost, "", `{"owner": "alice", "roles": ["editor"]}`)
+	var issued apiKeyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &issued); err != nil || rr.Code != http.StatusCreated ||
+		issued.Owner != "alice" || len(issued.Roles) != 1 || !strings.HasPrefix(issued.Key, issued.ID+".") {
+		t.Fatalf("POST returned %d with %v", rr.Code, rr.Body.String())
+	}
+	if principal, err := lookupKey(context.Background(), keys, issued.Key); err != nil || principal.Owner != "alice" {
+		t.Errorf("Issued key gave %+v, %v", principal, err)
+	}
+
+	for _, body := range []string{`{"roles": ["admin"]}`, `{"owner": "bob"}`, `{"owner": "bob", "roles": ["owner"]}`} {
+		if rr := send("admin-key", http.MethodPost, "", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("POST of %s returned %d, want %d", body, rr.Code, http.StatusBadRequest)
+		}
+	}
+
+	// Only admins manage keys
+	if rr := send(issued.Key, http.MethodGet, "", ""); rr.Code != http.StatusForbidden {
+		t.Errorf("GET by non-admin returned %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	// Listed keys have no secret
+	rr = send("admin-key", http.MethodGet, "", "")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), issued.ID) || strings.Contains(rr.Body.String(), issued.Key) {
+		t.Errorf("GET returned %d with %v", rr.Code, rr.Body.String())
+	}
+
+	// Revoked keys are refused
+	if rr := send("admin-key", http.MethodDelete, issued.ID, ""); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE returned %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if rr := send(issued.Key, http.MethodGet, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Revoked key returned %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	if rr := send("admin-key", http.MethodDelete, "unknown", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("DELETE of unknown key returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+Handler for the audit log: GET lists the changes made to registrations and webhooks, newest first.
+Filtered by the query parameters resourceType, resourceId, actor, since and until
+*/
+func AuditHandler(audit store.AuditStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method "+r.Method+" not supported for "+utils.AUDIT_PATH, http.StatusMethodNotAllowed)
+			return
+		}
+
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query, err := auditQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		records, err := audit.List(r.Context(), query, opts)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor '"+opts.Cursor+"'. Use the nextCursor of the previous page", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			http.Error(w, "Error retrieving audit records", http.StatusInternalServerError)
+			return
+		}
+		writePage(w, records)
+	}
+}
+
+// Reads the filters of the audit log from the query parameters resourceType, resourceId, actor, since and until
+func auditQuery(r *http.Request) (store.AuditQuery, error) {
+	params := r.URL.Query()
+
+	query := store.AuditQuery{
+		ResourceType: params.Get("resourceType"),
+		ResourceID:   params.Get("resourceId"),
+		Actor:        params.Get("actor"),
+	}
+	switch query.ResourceType {
+	case "", store.AuditRegistration, store.AuditWebhook:
+	default:
+		return query, errors.New("Unknown resource type '" + query.ResourceType + "'. Supported: " +
+			store.AuditRegistration + ", " + store.AuditWebhook)
+	}
+
+	var err error
+	if since := params.Get("since"); since != "" {
+		if query.Since, err = parseTime(since); err != nil {
+			return query, err
+		}
+	}
+	if until := params.Get("until"); until != "" {
+		if query.Until, err = parseTime(until); err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+// Records a change of the resource made by the caller in the audit log, with snapshots of the resource before and
+// after it. A nil snapshot is left out. The change is already made, so failing to record it is only logged
+func recordChange(r *http.Request, audit store.AuditStore, action string, resourceType string, id string,
+	before interface{}, after interface{}) {
+	record := utils.AuditRecord{
+		Time:         time.Now(),
+		Actor:        ownerOf(r),
+		Action:       action,
+		ResourceType: resourceType,
+		ResourceID:   id,
+	}
+
+	var err error
+	if before != nil {
+		record.Before, err = json.Marshal(before)
+	}
+	if after != nil && err == nil {
+		record.After, err = json.Marshal(after)
+	}
+	if err == nil {
+		_, err = audit.Record(r.Context(), record)
+	}
+	if err != nil {
+		log.Println("Error recording "+action+" of "+resourceType+" "+id+" in the audit log:", err)
+	}
+}
@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Test of the audit records written by changes to a configuration, and of filtering them
+func TestAuditHandler(t *testing.T) {
+	audit := store.NewMemoryAuditLog()
+	registrations := RegistrationHandler(store.NewMemoryDashboards(), store.NewMemoryWebhooks(), audit, store.NewMemorySnapshots())
+	handler := AuditHandler(audit)
+
+	// Sends a request as alice to the registration handler, and returns the response
+	change := func(method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, utils.REGISTRATION_LINE_PATH+path, strings.NewReader(body))
+		req = withPrincipal(req, Principal{Owner: "alice", Roles: []string{RoleEditor}})
+		rr := httptest.NewRecorder()
+		registrations(rr, req)
+		return rr
+	}
+
+	rr := change(http.MethodPost, "", `{"country": "Norway", "isoCode": "NO", "features": {"temperature": true, "precipitation": true,
+		"capital": true, "coordinates": true, "population": true, "area": true, "targetCurrencies": ["EUR"]}}`)
+	var registered struct {
+		ID string `json:"id"`
+	}
+	if rr.Code != http.StatusOK || json.Unmarshal(rr.Body.Bytes(), &registered) != nil {
+		t.Fatalf("POST returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := change(http.MethodPatch, registered.ID, `{"features": {"area": false}}`); rr.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := change(http.MethodDelete, registered.ID, ""); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Lists the audit log with the query, and returns the status code and the records
+	list := func(query string) (int, []utils.AuditRecord) {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, utils.AUDIT_PATH+query, nil))
+		var page store.Page[utils.AuditRecord]
+		if rr.Code == http.StatusOK {
+			if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+				t.Fatalf("GET %s returned invalid JSON %s: %v", query, rr.Body.String(), err)
+			}
+		}
+		return rr.Code, page.Items
+	}
+
+	code, records := list("?resourceType=registration&resourceId=" + registered.ID + "&actor=alice")
+	if code != http.StatusOK || len(records) != 3 {
+		t.Fatalf("Expected 3 records, got %d with %v", code, records)
+	}
+	deleted, updated, created := records[0], records[1], records[2]
+	if created.Action != store.AuditCreate || created.Before != nil || !strings.Contains(string(created.After), `"area":true`) {
+		t.Errorf("Unexpected record of the registration %+v", created)
+	}
+	if updated.Action != store.AuditUpdate || !strings.Contains(string(updated.Before), `"area":true`) ||
+		!strings.Contains(string(updated.After), `"area":false`) {
+		t.Errorf("Unexpected record of the update %+v", updated)
+	}
+	if deleted.Action != store.AuditDelete || deleted.After != nil || !strings.Contains(string(deleted.Before), `"revision":2`) {
+		t.Errorf("Unexpected record of the delete %+v", deleted)
+	}
+
+	// Filters select no records of other actors, resources or times
+	for _, query := range []string{"?actor=bob", "?resourceType=webhook", "?until=2020-01-01"} {
+		if code, records := list(query); code != http.StatusOK || len(records) != 0 {
+			t.Errorf("GET %s returned %d with %v", query, code, records)
+		}
+	}
+	for _, query := range []string{"?resourceType=dashboard", "?since=yesterday", "?limit=0", "?cursor=abc"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("GET %s returned %d, want %d", query, code, http.StatusBadRequest)
+		}
+	}
+}
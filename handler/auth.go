@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"assignment2/store"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Header the API key of a request is sent in
+const APIKeyHeader = "X-API-Key"
+
+// Owner of what is created with the bootstrap admin key
+const AdminOwner = "admin"
+
+// Principal is the caller of a request, identified by its API key
+type Principal struct {
+	KeyID string
+	Owner string
+	Roles []string
+}
+
+// Key of the principal in the context of a request
+type principalKey struct{}
+
+// Returns the request with the principal of its API key or token
+func withPrincipal(r *http.Request, principal Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+}
+
+// Returns the principal of an authenticated request
+func principalOf(r *http.Request) (Principal, bool) {
+	principal, ok := r.Context().Value(principalKey{}).(Principal)
+	return principal, ok
+}
+
+// Returns the owner the request is scoped to. Admins, and requests that have not been authenticated, such as
+// handlers called directly, see everything
+func scope(r *http.Request) string {
+	principal, ok := principalOf(r)
+	if !ok || principal.HasRole(RoleAdmin) {
+		return ""
+	}
+	return principal.Owner
+}
+
+// Reports whether the caller may see and change what belongs to the owner
+func canAccess(r *http.Request, owner string) bool {
+	scoped := scope(r)
+	return scoped == "" || owner == scoped
+}
+
+// Returns the owner stamped on what the caller creates
+func ownerOf(r *http.Request) string {
+	principal, _ := principalOf(r)
+	return principal.Owner
+}
+
+// Hashes the secret of an API key the way it is stored
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Generates the secret of a new API key
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// Looks up the principal of an API key, given as "{id}.{secret}"
+func lookupKey(ctx context.Context, keys store.APIKeyStore, token string) (Principal, error) {
+	id, secret, ok := strings.Cut(token, ".")
+	if !ok || id == "" || secret == "" {
+		return Principal{}, store.ErrNotFound
+	}
+
+	key, err := keys.Get(ctx, id)
+	if err != nil {
+		return Principal{}, err
+	}
+	if key.Revoked || subtle.ConstantTimeCompare([]byte(key.Hash), []byte(hashSecret(secret))) != 1 {
+		return Principal{}, store.ErrNotFound
+	}
+	return Principal{KeyID: key.ID, Owner: key.Owner, Roles: key.Roles}, nil
+}
+
+/*
+Authenticate only lets requests with a valid API key in the 'X-API-Key' header, or a valid bearer token in the
+'Authorization' header, through to next, with the principal of the key or token in their context.
+The admin key, if not empty, is accepted as a key with the admin role. Bearer tokens are refused if tokens is nil
+*/
+func Authenticate(keys store.APIKeyStore, adminKey string, tokens *TokenVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Bearer tokens are checked instead of API keys when they are sent
+		if bearer, ok := bearerToken(r); ok {
+			if tokens == nil {
+				http.Error(w, "Bearer tokens are not accepted. Send an API key in the '"+APIKeyHeader+"' header",
+					http.StatusUnauthorized)
+				return
+			}
+			principal, err := tokens.Verify(bearer)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				http.Error(w, "Invalid bearer token: "+err.Error(), http.StatusUnauthorized)
+				return
+			}
+			next(w, withPrincipal(r, principal))
+			return
+		}
+
+		token := r.Header.Get(APIKeyHeader)
+		if token == "" {
+			http.Error(w, "Missing API key. Send it in the '"+APIKeyHeader+"' header, or a bearer token in the "+
+				"'Authorization' header", http.StatusUnauthorized)
+			return
+		}
+
+		var principal Principal
+		if adminKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminKey)) == 1 {
+			principal = Principal{Owner: AdminOwner, Roles: []string{RoleAdmin}}
+		} else {
+			var err error
+			principal, err = lookupKey(r.Context(), keys, token)
+			if errors.Is(err, store.ErrNotFound) {
+				http.Error(w, "Invalid or revoked API key", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				log.Println("Error retrieving API key:", err)
+				http.Error(w, "Error retrieving API key", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		next(w, withPrincipal(r, principal))
+	}
+}
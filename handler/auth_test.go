@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// Test for Authenticate
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	keys := store.NewMemoryAPIKeys()
+	id, err := keys.Create(ctx, utils.APIKey{Owner: "alice", Roles: []string{RoleEditor}, Hash: hashSecret("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, err := keys.Create(ctx, utils.APIKey{Owner: "bob", Hash: hashSecret("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keys.Revoke(ctx, revoked); err != nil {
+		t.Fatal(err)
+	}
+
+	// Records the principal the request reaches the handler with
+	var reached Principal
+	handler := Authenticate(keys, "admin-key", nil, func(w http.ResponseWriter, r *http.Request) {
+		reached, _ = principalOf(r)
+	})
+
+	tests := []struct {
+		key  string
+		code int
+		want Principal
+	}{
+		{"", http.StatusUnauthorized, Principal{}},
+		{id + ".secret", http.StatusOK, Principal{KeyID: id, Owner: "alice", Roles: []string{RoleEditor}}},
+		{id + ".wrong", http.StatusUnauthorized, Principal{}},
+		{id, http.StatusUnauthorized, Principal{}},
+		{revoked + ".secret", http.StatusUnauthorized, Principal{}},
+		{"unknown.secret", http.StatusUnauthorized, Principal{}},
+		{"admin-key", http.StatusOK, Principal{Owner: AdminOwner, Roles: []string{RoleAdmin}}},
+	}
+	for _, test := range tests {
+		reached = Principal{}
+		req := httptest.NewRequest(http.MethodGet, utils.REGISTRATION_LINE_PATH, nil)
+		if test.key != "" {
+			req.Header.Set(APIKeyHeader, test.key)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != test.code || !reflect.DeepEqual(reached, test.want) {
+			t.Errorf("API key '%s' returned %d with %+v, want %d with %+v", test.key, rr.Code, reached, test.code, test.want)
+		}
+	}
+}
+
+// Test that configurations and webhooks are scoped to their owner, while admins see everything
+func TestOwnerScope(t *testing.T) {
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	webhooks := store.NewMemoryWebhooks()
+	keys := store.NewMemoryAPIKeys()
+
+	// Keys of two clients, and of an admin
+	issued := make(map[string]string)
+	for _, key := range []utils.APIKey{{Owner: "alice", Roles: []string{RoleEditor}}, {Owner: "bob", Roles: []string{RoleEditor}},
+		{Owner: "root", Roles: []string{RoleAdmin}}} {
+		key.Hash = hashSecret("secret")
+		id, err := keys.Create(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued[key.Owner] = id + ".secret"
+	}
+
+	alices, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hook, err := webhooks.Create(ctx, utils.WebhookGetResponse{Url: "http://localhost:8080/", Event: "INVOKE", Owner: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registrations := Authenticate(keys, "", nil, RegistrationHandler(dashboards, webhooks, store.NewMemoryAuditLog(), store.NewMemorySnapshots()))
+	notifications := Authenticate(keys, "", nil, NotificationHandler(webhooks, store.NewMemoryAuditLog()))
+
+	// Sends a request as the owner, and returns the response
+	send := func(handler http.HandlerFunc, owner string, method string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(APIKeyHeader, issued[owner])
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// Returns the number of configurations or webhooks listed to the owner
+	total := func(handler http.HandlerFunc, owner string, path string) int {
+		var page store.Page[json.RawMessage]
+		rr := send(handler, owner, http.MethodGet, path)
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("GET %s returned %d with %v", path, rr.Code, rr.Body.String())
+		}
+		return page.Total
+	}
+
+	for owner, want := range map[string]int{"alice": 1, "bob": 0, "root": 1} {
+		if got := total(registrations, owner, utils.REGISTRATION_LINE_PATH); got != want {
+			t.Errorf("%s was listed %d configurations, want %d", owner, got, want)
+		}
+		if got := total(notifications, owner, utils.NOTIFICATION_PATH); got != want {
+			t.Errorf("%s was listed %d webhooks, want %d", owner, got, want)
+		}
+	}
+
+	// Configurations and webhooks of other owners are not found
+	for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+		if rr := send(registrations, "bob", method, utils.REGISTRATION_LINE_PATH+alices); rr.Code != http.StatusNotFound {
+			t.Errorf("%s of configuration of another owner returned %d, want %d", method, rr.Code, http.StatusNotFound)
+		}
+	}
+	if rr := send(registrations, "bob", http.MethodGet, utils.REGISTRATION_LINE_PATH+alices+"/revisions"); rr.Code != http.StatusNotFound {
+		t.Errorf("Revisions of configuration of another owner returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodDelete} {
+		if rr := send(notifications, "bob", method, utils.NOTIFICATION_PATH+hook); rr.Code != http.StatusNotFound {
+			t.Errorf("%s of webhook of another owner returned %d, want %d", method, rr.Code, http.StatusNotFound)
+		}
+	}
+
+	// Trashed configurations can only be restored by their owner
+	if rr := send(registrations, "alice", http.MethodDelete, utils.REGISTRATION_LINE_PATH+alices); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE of own configuration returned %d", rr.Code)
+	}
+	if got := total(registrations, "bob", utils.REGISTRATION_LINE_PATH+"trash"); got != 0 {
+		t.Errorf("bob was listed %d configurations in the trash, want 0", got)
+	}
+	if rr := send(registrations, "bob", http.MethodPost, utils.REGISTRATION_LINE_PATH+"trash/"+alices+"/restore"); rr.Code != http.StatusNotFound {
+		t.Errorf("Restore of configuration of another owner returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if rr := send(registrations, "root", http.MethodPost, utils.REGISTRATION_LINE_PATH+"trash/"+alices+"/restore"); rr.Code != http.StatusOK {
+		t.Errorf("Restore by admin returned %d, want %d", rr.Code, http.StatusOK)
+	}
+}
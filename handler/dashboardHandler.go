@@ -1,15 +1,22 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
-
-	"google.golang.org/api/iterator"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Own float type, either float32 or float64, whatever we see fit
@@ -19,18 +26,83 @@ type myFloat float32
 Struct that will display the information in each dasahboard
 */
 type OutputDashboardWithData struct {
-	Country  string `json:"country"`
-	IsoCode  string `json:"isoCode"`
-	Features struct {
-		Temperature      myFloat            `json:"temperature,omitempty"`
-		Precipitation    myFloat            `json:"precipitation,omitempty"`
-		Capital          string             `json:"capital,omitempty"`
-		Coordinates      Coordinates        `json:"coordinates,omitempty"`
-		Population       int                `json:"population,omitempty"`
-		Area             myFloat            `json:"area,omitempty"`
-		TargetCurrencies map[string]myFloat `json:"targetCurrencies,omitempty"`
-	} `json:"features"`
-	LastRetrieval string `json:"lastRetrieval"`
+	Country  string            `json:"country"`
+	IsoCode  string            `json:"isoCode"`
+	Features DashboardFeatures `json:"features"`
+	// How the hourly values of the weather features shown are aggregated, keyed by feature
+	Aggregation map[string]string `json:"aggregation,omitempty"`
+	// When each feature was fetched, and whether it is served from the last dashboard since it could not be fetched now
+	Freshness map[string]FeatureFreshness `json:"freshness,omitempty"`
+	// Reasons the enabled features that are left out of Features could not be retrieved, keyed by feature
+	Errors        map[string]string `json:"errors,omitempty"`
+	LastRetrieval string            `json:"lastRetrieval"`
+}
+
+// Features shown on a dashboard
+type DashboardFeatures struct {
+	Temperature         *WeatherValue      `json:"temperature,omitempty"`
+	Precipitation       *WeatherValue      `json:"precipitation,omitempty"`
+	Capital             string             `json:"capital,omitempty"`
+	Coordinates         Coordinates        `json:"coordinates,omitempty"`
+	Population          int                `json:"population,omitempty"`
+	Area                myFloat            `json:"area,omitempty"`
+	TargetCurrencies    map[string]myFloat `json:"targetCurrencies,omitempty"`
+	Forecast            []ForecastDay      `json:"forecast,omitempty"`
+	WindSpeed           *WeatherValue      `json:"windSpeed,omitempty"`
+	WindDirection       *WeatherValue      `json:"windDirection,omitempty"`
+	Humidity            *WeatherValue      `json:"humidity,omitempty"`
+	CloudCover          *WeatherValue      `json:"cloudCover,omitempty"`
+	UVIndex             *WeatherValue      `json:"uvIndex,omitempty"`
+	ApparentTemperature *WeatherValue      `json:"apparentTemperature,omitempty"`
+	AirQuality          *AirQuality        `json:"airQuality,omitempty"`
+}
+
+// Current air quality at the coordinates of the capital, as European and US air quality index and particulate matter
+// in μg/m³
+type AirQuality struct {
+	EuropeanAQI int     `json:"europeanAqi"`
+	USAQI       int     `json:"usAqi"`
+	PM25        myFloat `json:"pm2_5"`
+	PM10        myFloat `json:"pm10"`
+}
+
+// Returns the field of the weather feature, nil if the feature is not a weather feature
+func (f *DashboardFeatures) weather(feature string) **WeatherValue {
+	switch feature {
+	case "temperature":
+		return &f.Temperature
+	case "precipitation":
+		return &f.Precipitation
+	case "windSpeed":
+		return &f.WindSpeed
+	case "windDirection":
+		return &f.WindDirection
+	case "humidity":
+		return &f.Humidity
+	case "cloudCover":
+		return &f.CloudCover
+	case "uvIndex":
+		return &f.UVIndex
+	case "apparentTemperature":
+		return &f.ApparentTemperature
+	default:
+		return nil
+	}
+}
+
+// Forecast of a day at the coordinates of the capital
+type ForecastDay struct {
+	Date             string  `json:"date"`
+	TemperatureMin   myFloat `json:"temperatureMin"`
+	TemperatureMax   myFloat `json:"temperatureMax"`
+	PrecipitationSum myFloat `json:"precipitationSum"`
+	Weather          string  `json:"weather"`
+}
+
+// When a feature was fetched, and whether it is stale
+type FeatureFreshness struct {
+	FetchedAt time.Time `json:"fetchedAt"`
+	Stale     bool      `json:"stale"`
 }
 
 // Coordinates struct that contains latitude and longitude
@@ -39,12 +111,96 @@ type Coordinates struct {
 	Longitude myFloat `json:"longitude,omitempty"`
 }
 
+// Value of a weather feature: its hourly values aggregated into one number, or the hourly series itself
+type WeatherValue struct {
+	Value  myFloat
+	Hourly []myFloat
+}
+
+// MarshalJSON writes the hourly series if there is one, and the number otherwise
+func (v WeatherValue) MarshalJSON() ([]byte, error) {
+	if v.Hourly != nil {
+		return json.Marshal(v.Hourly)
+	}
+	return json.Marshal(v.Value)
+}
+
+// UnmarshalJSON reads either an hourly series or a number
+func (v *WeatherValue) UnmarshalJSON(data []byte) error {
+	*v = WeatherValue{}
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		return json.Unmarshal(data, &v.Hourly)
+	}
+	return json.Unmarshal(data, &v.Value)
+}
+
+// Time each upstream API is given to answer, when fetching the data of a dashboard
+var sourceTimeouts = map[string]time.Duration{
+	utils.CountriesSource: 5 * time.Second,
+	utils.GeocodingSource: 3 * time.Second,
+	utils.ForecastSource:  5 * time.Second,
+	utils.CurrencySource:  3 * time.Second,
+	// Air quality is fetched at the same time as the forecast
+	utils.AirQualitySource: 5 * time.Second,
+}
+
+// Upstream APIs the data of each feature comes from, in the order they are asked
+var featureSources = map[string][]string{
+	"temperature":      {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"precipitation":    {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"capital":          {utils.CountriesSource},
+	"coordinates":      {utils.CountriesSource, utils.GeocodingSource},
+	"population":       {utils.CountriesSource},
+	"area":             {utils.CountriesSource},
+	"targetCurrencies": {utils.CountriesSource, utils.CurrencySource},
+	"forecast":         {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	// Weather variables besides temperature and precipitation
+	"windSpeed":           {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"windDirection":       {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"humidity":            {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"cloudCover":          {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"uvIndex":             {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"apparentTemperature": {utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource},
+	"airQuality":          {utils.CountriesSource, utils.GeocodingSource, utils.AirQualitySource},
+}
+
+// Data of the upstream APIs that is shown on a dashboard
+type dashboardData struct {
+	population          int
+	capital, currency   string
+	area                myFloat
+	longitude, latitude myFloat
+	// Hourly values of today, keyed by weather feature
+	hourly     map[string][]myFloat
+	forecast   []ForecastDay
+	airQuality AirQuality
+	rates      map[string]myFloat
+}
+
+// Error of fetching data from an upstream API
+type sourceError struct {
+	source string
+	err    error
+}
+
+func (e *sourceError) Error() string {
+	if errors.Is(e.err, context.DeadlineExceeded) {
+		return "the " + e.source + " API did not answer in time"
+	}
+	return "failed to retrieve " + e.source + " data: " + e.err.Error()
+}
+
+func (e *sourceError) Unwrap() error {
+	return e.err
+}
+
 // Handler function that checks if method is set to GET
-func DashboardHandler() func(w http.ResponseWriter, r *http.Request) {
+func DashboardHandler(dashboards store.DashboardStore, webhooks store.WebhookStore,
+	snapshots store.SnapshotStore, refresher *SnapshotRefresher) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			DashboardFunc(w, r)
+			DashboardFunc(w, r, dashboards, webhooks, snapshots, refresher)
 		default:
 			http.Error(w, "Method "+r.Method+" not supported.", http.StatusMethodNotAllowed)
 			return
@@ -53,12 +209,23 @@ func DashboardHandler() func(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Logs why the dashboard could not be built, answers with 500 Internal Server Error and returns the error
+func dashboardFailed(w http.ResponseWriter, id string, err error) error {
+	log.Println("Error building dashboard "+id+":", err)
+	http.Error(w, "Failed to build the dashboard", http.StatusInternalServerError)
+	return err
+}
+
 /*
 This function will receive data from json, with the dashboards, check what variables will show values
 
-	and then return the specific values
+	and then return the specific values. Features whose data could not be retrieved are served from the snapshot
+	of the last dashboard, marked stale. Those it does not have are left out and their reasons listed in errors.
+	With ?strict=true the dashboard fails instead, with 502 Bad Gateway or 504 Gateway Timeout.
+	Stale snapshots are refreshed by refresher, or not at all if it is nil
 */
-func DashboardFunc(w http.ResponseWriter, r *http.Request) error {
+func DashboardFunc(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	snapshots store.SnapshotStore, refresher *SnapshotRefresher) error {
 
 	//Finding out what ID is written in the URL path
 	myId := r.URL.Path[len(utils.DASHBOARD_PATH):]
@@ -66,9 +233,23 @@ func DashboardFunc(w http.ResponseWriter, r *http.Request) error {
 	//If the id
 	if len(myId) != 0 {
 
-		doc, err := GetDocumentByID(ctx, collection, myId)
+		//Whether the dashboard fails when any feature can not be retrieved
+		strict := false
+		if value := r.URL.Query().Get("strict"); value != "" {
+			var err error
+			if strict, err = strconv.ParseBool(value); err != nil {
+				http.Error(w, "Invalid value '"+value+"' of strict. Use true or false", http.StatusBadRequest)
+				return nil
+			}
+		}
+
+		myObject, err := dashboards.Get(r.Context(), myId)
+		// Configurations of other owners are not found
+		if err == nil && !canAccess(r, myObject.Owner) {
+			err = store.ErrNotFound
+		}
 		if err != nil {
-			if err == iterator.Done {
+			if errors.Is(err, store.ErrNotFound) {
 				// Document not found
 				errorMessage := "Document with ID " + myId + " not found"
 				http.Error(w, errorMessage, http.StatusNotFound)
@@ -80,31 +261,69 @@ func DashboardFunc(w http.ResponseWriter, r *http.Request) error {
 			return nil
 		}
 
-		var myObject utils.Dashboard_Get
-		if err := doc.DataTo(&myObject); err != nil {
-			log.Println("Error retrieving document data:", err)
-			http.Error(w, "Error retrieving document data", http.StatusInternalServerError)
-			return nil
+		//Fetching the data of the enabled features, and finding the features that failed
+		fetchedAt := time.Now().UTC().Truncate(time.Second)
+		data, failed := retrieveDashboardData(r.Context(), myObject.Country, myObject.Features)
+		enabled := 0
+		featureErrors := make(map[string]string)
+		for feature := range featureSources {
+			if !featureEnabled(myObject.Features, feature) {
+				continue
+			}
+			enabled++
+			if err := featureError(feature, failed); err != nil {
+				featureErrors[feature] = err.Error()
+			}
 		}
 
-		//Fetching variables from functions
-
-		//Fetching population, capital, their own currency and are
-		population, capital, countryCurrency, area, err := retrieveCountryData(utils.COUNTRIES_API, myObject.Country, w, r)
+		//Features that failed are served from the snapshot of the last dashboard of this revision, except in strict mode
+		snapshot, err := snapshotOf(r.Context(), snapshots, myId, myObject.Revision)
 		if err != nil {
-			return err
+			log.Println("Error retrieving snapshot of dashboard "+myId+":", err)
+		}
+		stale := make(map[string]utils.SnapshotFeature)
+		for feature := range featureErrors {
+			if value, ok := snapshot.Features[feature]; ok && !strict {
+				stale[feature] = value
+				delete(featureErrors, feature)
+			}
 		}
 
-		//Fetching coordinates from chosen capital
-		longitude, latitude, err := retrieveCoordinates(utils.GEOCODING_API, capital, w, r)
-		if err != nil {
+		//Fails if no feature could be retrieved, or any could not in strict mode
+		if len(featureErrors) > 0 && (strict || len(featureErrors) == enabled) {
+			features := make([]string, 0, len(featureErrors))
+			for feature := range featureErrors {
+				features = append(features, feature)
+			}
+			sort.Strings(features)
+
+			err := upstreamError(failed)
+			log.Println("Error retrieving dashboard "+myId+":", err)
+			http.Error(w, "Failed to retrieve "+strings.Join(features, ", ")+" for the dashboard", upstreamStatus(failed))
 			return err
 		}
 
-		//Fetching temperature and precipitation using coordinates
-		temperature, precipitation, err := retrieveWeather(utils.FORECAST_API, longitude, latitude, w, r)
+		//Features retrieved now
+		retrieved := func(feature string) bool {
+			return featureEnabled(myObject.Features, feature) && featureError(feature, failed) == nil
+		}
+		fresh, err := dashboardFeatures(myObject.Features, data, retrieved)
 		if err != nil {
-			return err
+			return dashboardFailed(w, myId, err)
+		}
+		values, err := featureValues(fresh, retrieved)
+		if err != nil {
+			return dashboardFailed(w, myId, err)
+		}
+
+		//They replace those of the snapshot, which is refreshed in the background if it had to be served
+		if len(values) > 0 {
+			if err := snapshots.Put(r.Context(), myId, withFeatures(snapshot, values, fetchedAt)); err != nil {
+				log.Println("Error storing snapshot of dashboard "+myId+":", err)
+			}
+		}
+		if len(stale) > 0 && refresher != nil {
+			refresher.Refresh(myId, myObject.Revision, dashboards, snapshots)
 		}
 
 		//Creating the result struct
@@ -114,73 +333,47 @@ func DashboardFunc(w http.ResponseWriter, r *http.Request) error {
 		Result.Country = myObject.Country
 		Result.IsoCode = myObject.IsoCode
 
-		//Checks if a value is to be displayed, and then assigns the values if true
-		//-------------------------------------------------------------------------------------------
-		if myObject.Features.Temperature {
-			Result.Features.Temperature, err = floatFormat(temperature)
-			if err != nil {
-				return err
-			}
-		}
-		if myObject.Features.Precipitation {
-			Result.Features.Precipitation, err = floatFormat(precipitation)
-			if err != nil {
-				return err
-			}
+		//Assigning the features retrieved now, and the stale features of the snapshot
+		Result.Freshness = make(map[string]FeatureFreshness)
+		for feature := range values {
+			Result.Freshness[feature] = FeatureFreshness{FetchedAt: fetchedAt}
 		}
-		if myObject.Features.Capital {
-			Result.Features.Capital = capital
+		for feature, value := range stale {
+			values[feature] = value.Value
+			Result.Freshness[feature] = FeatureFreshness{FetchedAt: value.FetchedAt, Stale: true}
 		}
-		if myObject.Features.Coordinates {
-			Result.Features.Coordinates.Longitude, err = floatFormat(longitude)
-			if err != nil {
-				return err
-			}
-			Result.Features.Coordinates.Latitude, err = floatFormat(latitude)
-			if err != nil {
-				return err
-			}
+		if Result.Features, err = featuresFrom(values); err != nil {
+			return dashboardFailed(w, myId, err)
 		}
-		if myObject.Features.Area {
-			Result.Features.Area, err = floatFormat(area)
-			if err != nil {
-				return err
-			}
-		}
-		if myObject.Features.Population {
-			Result.Features.Population = population
-		}
-		//---------------------------------------------------------------------
 
-		//Making own map to set currencies with their exchangerates
-		c := make(map[string]myFloat)
-
-		//Makes the map with exchange rates for fetched currency earlier
-		currencyRates, err := retrieveCurrencyExchangeRates(utils.CURRENCY_API, countryCurrency, w, r)
-		if err != nil {
-			return err
+		//Tells how the weather features shown are aggregated
+		for _, feature := range utils.WeatherFeatures {
+			if _, shown := values[feature]; shown {
+				if Result.Aggregation == nil {
+					Result.Aggregation = make(map[string]string)
+				}
+				Result.Aggregation[feature] = myObject.Features.AggregationOf(feature)
+			}
 		}
 
-		//Runs through all currencies that are fetched from specified object
-		for _, currency := range myObject.Features.TargetCurrencies {
-			//Assigns currency rate to specified currencies from the fetched object
-			c[currency] = currencyRates[currency]
+		//Some features are missing from a partial result
+		if len(featureErrors) > 0 {
+			Result.Errors = featureErrors
 		}
-		//Assigns map of exchange rates to result
-		Result.Features.TargetCurrencies = c
 
 		//Time for last retrieval being assigned using formatted time
 		Result.LastRetrieval = utils.WhatTimeNow()
 
 		//Sets header, and encodes the result
+		//The status is already sent when encoding fails, so it can only be logged
 		w.Header().Set("Content-type", "application/json")
 		if err := json.NewEncoder(w).Encode(Result); err != nil {
-			http.Error(w, "Failed to encode result", http.StatusInternalServerError)
+			log.Println("Error encoding dashboard "+myId+":", err)
 			return err
 		}
 
 		// Trigger event if registered configuration has a webhook to invoke
-		if !invocationHandler(w, "INVOKE", Result.IsoCode) {
+		if !invocationHandler(r.Context(), w, webhooks, "INVOKE", Result.IsoCode, myObject.Owner) {
 			return err
 		}
 	} else {
@@ -192,10 +385,405 @@ func DashboardFunc(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+/*
+Fetches the data of the features the dashboard enables, and none of the others. The country is fetched first, since the
+coordinates depend on its capital and the rates on its currency. Then the coordinates, followed by the weather and the
+air quality at them, are fetched while the rates are. Every upstream API is given the time in sourceTimeouts, and the request is given up when ctx is done.
+The errors of the upstream APIs that failed are returned keyed by source. APIs that depend on them are not asked
+*/
+func retrieveDashboardData(ctx context.Context, country string, features utils.Features_Get) (dashboardData, map[string]error) {
+	var data dashboardData
+	failed := make(map[string]error)
+
+	// Weather features whose hourly values are fetched
+	var weather []string
+	for _, feature := range utils.WeatherFeatures {
+		if featureEnabled(features, feature) {
+			weather = append(weather, feature)
+		}
+	}
+
+	needWeather := len(weather) > 0 || features.Forecast > 0
+	needCoordinates := features.Coordinates || needWeather || features.AirQuality
+	needRates := len(features.TargetCurrencies) > 0
+	if !features.Capital && !features.Population && !features.Area && !needCoordinates && !needRates {
+		return data, failed
+	}
+
+	err := fetchFrom(ctx, utils.CountriesSource, func(ctx context.Context) (err error) {
+		data.population, data.capital, data.currency, data.area, err = retrieveCountryData(ctx, utils.COUNTRIES_API, country)
+		return err
+	})
+	if err != nil {
+		failed[utils.CountriesSource] = err
+		return data, failed
+	}
+
+	// Each goroutine sets its own fields of data, which are read once both are done
+	var wg sync.WaitGroup
+	var geocodingErr, forecastErr, airQualityErr, ratesErr error
+	if needCoordinates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			geocodingErr = fetchFrom(ctx, utils.GeocodingSource, func(ctx context.Context) (err error) {
+				data.longitude, data.latitude, err = retrieveCoordinates(ctx, utils.GEOCODING_API, data.capital)
+				return err
+			})
+			if geocodingErr != nil {
+				return
+			}
+
+			// The weather and the air quality are fetched at the same time, from the coordinates
+			var atCoordinates sync.WaitGroup
+			if needWeather {
+				atCoordinates.Add(1)
+				go func() {
+					defer atCoordinates.Done()
+					forecastErr = fetchFrom(ctx, utils.ForecastSource, func(ctx context.Context) (err error) {
+						data.hourly, data.forecast, err = retrieveWeather(ctx, utils.FORECAST_API, data.longitude, data.latitude,
+							weather, features.Forecast)
+						return err
+					})
+				}()
+			}
+			if features.AirQuality {
+				airQualityErr = fetchFrom(ctx, utils.AirQualitySource, func(ctx context.Context) (err error) {
+					data.airQuality, err = retrieveAirQuality(ctx, utils.AIR_QUALITY_API, data.longitude, data.latitude)
+					return err
+				})
+			}
+			atCoordinates.Wait()
+		}()
+	}
+	if needRates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ratesErr = fetchFrom(ctx, utils.CurrencySource, func(ctx context.Context) (err error) {
+				data.rates, err = retrieveCurrencyExchangeRates(ctx, utils.CURRENCY_API, data.currency)
+				return err
+			})
+		}()
+	}
+	wg.Wait()
+
+	for source, err := range map[string]error{utils.GeocodingSource: geocodingErr, utils.ForecastSource: forecastErr,
+		utils.AirQualitySource: airQualityErr, utils.CurrencySource: ratesErr} {
+		if err != nil {
+			failed[source] = err
+		}
+	}
+	return data, failed
+}
+
+// Reports whether the feature with the given JSON name is enabled
+func featureEnabled(features utils.Features_Get, feature string) bool {
+	switch feature {
+	case "targetCurrencies":
+		return len(features.TargetCurrencies) > 0
+	case "forecast":
+		return features.Forecast > 0
+	}
+	enabled, _ := features.Enabled(feature)
+	return enabled
+}
+
+// Returns the error of the first upstream API the data of the feature comes from that failed, or nil
+func featureError(feature string, failed map[string]error) error {
+	for _, source := range featureSources[feature] {
+		if err, ok := failed[source]; ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Joins the errors of the upstream APIs that failed
+func upstreamError(failed map[string]error) error {
+	sources := make([]string, 0, len(failed))
+	for source := range failed {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	errs := make([]error, 0, len(sources))
+	for _, source := range sources {
+		errs = append(errs, failed[source])
+	}
+	return errors.Join(errs...)
+}
+
+// Status code of a dashboard that failed because of the upstream APIs: a timeout if any did not answer in time
+func upstreamStatus(failed map[string]error) int {
+	for _, err := range failed {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+	}
+	return http.StatusBadGateway
+}
+
+// Runs the fetch from the upstream API within the time the source is given, and names the source in its error
+func fetchFrom(ctx context.Context, source string, fetch func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, sourceTimeouts[source])
+	defer cancel()
+
+	if err := fetch(ctx); err != nil {
+		return &sourceError{source: source, err: err}
+	}
+	return nil
+}
+
+// Fills in the enabled features that could be retrieved, as they are shown on the dashboard
+func dashboardFeatures(features utils.Features_Get, data dashboardData, retrieved func(feature string) bool) (DashboardFeatures, error) {
+	var result DashboardFeatures
+	var err error
+
+	//Checks if a value is to be displayed, and then assigns the values if true
+	//-------------------------------------------------------------------------------------------
+	for _, feature := range utils.WeatherFeatures {
+		if !featureEnabled(features, feature) || !retrieved(feature) {
+			continue
+		}
+		//Directions are averaged on the circle, so north is the mean of 350 and 10 degrees
+		aggregation := features.AggregationOf(feature)
+		if feature == "windDirection" && aggregation == utils.AggregationMean {
+			*result.weather(feature), err = meanDirection(data.hourly[feature])
+		} else {
+			*result.weather(feature), err = aggregate(data.hourly[feature], aggregation)
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+	if features.Capital && retrieved("capital") {
+		result.Capital = data.capital
+	}
+	if features.Coordinates && retrieved("coordinates") {
+		result.Coordinates.Longitude, err = floatFormat(data.longitude)
+		if err != nil {
+			return result, err
+		}
+		result.Coordinates.Latitude, err = floatFormat(data.latitude)
+		if err != nil {
+			return result, err
+		}
+	}
+	if features.Area && retrieved("area") {
+		result.Area, err = floatFormat(data.area)
+		if err != nil {
+			return result, err
+		}
+	}
+	if features.Population && retrieved("population") {
+		result.Population = data.population
+	}
+	//---------------------------------------------------------------------
+
+	if retrieved("targetCurrencies") {
+		//Making own map to set currencies with their exchangerates
+		c := make(map[string]myFloat)
+
+		//Runs through all currencies that are fetched from specified object
+		for _, currency := range features.TargetCurrencies {
+			//Assigns currency rate to specified currencies from the rates fetched earlier
+			c[currency] = data.rates[currency]
+		}
+		//Assigns map of exchange rates to result
+		result.TargetCurrencies = c
+	}
+	if features.Forecast > 0 && retrieved("forecast") {
+		result.Forecast = data.forecast
+	}
+	if features.AirQuality && retrieved("airQuality") {
+		airQuality := data.airQuality
+		result.AirQuality = &airQuality
+	}
+
+	return result, nil
+}
+
+// Returns the values of the features that were retrieved, keyed by feature
+func featureValues(features DashboardFeatures, retrieved func(feature string) bool) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(features)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	for feature := range values {
+		if !retrieved(feature) {
+			delete(values, feature)
+		}
+	}
+	return values, nil
+}
+
+// Returns the features with the values keyed by feature
+func featuresFrom(values map[string]json.RawMessage) (DashboardFeatures, error) {
+	var features DashboardFeatures
+	data, err := json.Marshal(values)
+	if err != nil {
+		return features, err
+	}
+	err = json.Unmarshal(data, &features)
+	return features, err
+}
+
+// Returns the snapshot of the dashboard if it was rendered from the revision of its configuration. Otherwise, or if
+// it can not be retrieved, an empty snapshot of the revision is returned, as the features, country or aggregation
+// of the snapshot may have changed since
+func snapshotOf(ctx context.Context, snapshots store.SnapshotStore, id string, revision int) (utils.DashboardSnapshot, error) {
+	snapshot, err := snapshots.Get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		err = nil
+	}
+	if err != nil || snapshot.Revision != revision {
+		return utils.DashboardSnapshot{Revision: revision}, err
+	}
+	return snapshot, nil
+}
+
+// Removes the snapshot of the dashboard, when its configuration is changed or deleted
+func dropSnapshot(ctx context.Context, snapshots store.SnapshotStore, id string) {
+	if err := snapshots.Delete(ctx, id); err != nil {
+		log.Println("Error deleting snapshot of dashboard "+id+":", err)
+	}
+}
+
+// Returns the snapshot with the values of the features fetched at the time, keeping the other features it has
+func withFeatures(snapshot utils.DashboardSnapshot, values map[string]json.RawMessage, fetchedAt time.Time) utils.DashboardSnapshot {
+	features := make(map[string]utils.SnapshotFeature, len(snapshot.Features)+len(values))
+	for feature, value := range snapshot.Features {
+		features[feature] = value
+	}
+	for feature, value := range values {
+		features[feature] = utils.SnapshotFeature{Value: value, FetchedAt: fetchedAt}
+	}
+	return utils.DashboardSnapshot{Revision: snapshot.Revision, Features: features}
+}
+
+// Time between the attempts to refresh a snapshot that was served stale, and the attempts made before giving up
+var (
+	snapshotRefreshInterval = 30 * time.Second
+	snapshotRefreshAttempts = 20
+)
+
+// SnapshotRefresher refreshes the snapshots of dashboards that were served stale in the background, until its
+// context is cancelled
+type SnapshotRefresher struct {
+	ctx context.Context
+
+	mu sync.Mutex
+	// IDs of the dashboards whose snapshots are being refreshed
+	ids     map[string]bool
+	running sync.WaitGroup
+}
+
+// NewSnapshotRefresher gives a refresher whose refreshes stop when ctx is cancelled
+func NewSnapshotRefresher(ctx context.Context) *SnapshotRefresher {
+	return &SnapshotRefresher{ctx: ctx, ids: make(map[string]bool)}
+}
+
+// Wait waits until no snapshot is being refreshed
+func (s *SnapshotRefresher) Wait() {
+	s.running.Wait()
+}
+
+// Reports whether the snapshot of the dashboard is being refreshed
+func (s *SnapshotRefresher) refreshing(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ids[id]
+}
+
+/*
+Refresh refreshes the snapshot of the revision of the dashboard in the background, every snapshotRefreshInterval until
+the upstream APIs of all its features answer again, or snapshotRefreshAttempts have failed. Does nothing if it is
+already being refreshed. The refresh stops when the configuration is deleted or changed to another revision, or the
+context of the refresher is cancelled
+*/
+func (s *SnapshotRefresher) Refresh(id string, revision int, dashboards store.DashboardStore, snapshots store.SnapshotStore) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids[id] || s.ctx.Err() != nil {
+		return
+	}
+	s.ids[id] = true
+	s.running.Add(1)
+
+	go func() {
+		defer s.running.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.ids, id)
+			s.mu.Unlock()
+		}()
+
+		for attempt := 0; attempt < snapshotRefreshAttempts; attempt++ {
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(snapshotRefreshInterval):
+			}
+			if refreshSnapshot(s.ctx, id, revision, dashboards, snapshots) {
+				return
+			}
+		}
+		log.Println("Gave up refreshing the snapshot of dashboard " + id)
+	}()
+}
+
+// Fetches the features of the revision of the dashboard, and stores those that could be fetched in its snapshot.
+// Reports whether all could, or the configuration is gone or at another revision, whose snapshot is not this one
+func refreshSnapshot(ctx context.Context, id string, revision int, dashboards store.DashboardStore, snapshots store.SnapshotStore) bool {
+	dashboard, err := dashboards.Get(ctx, id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && dashboard.Revision != revision) {
+		return true
+	}
+	if err != nil {
+		log.Println("Error refreshing the snapshot of dashboard "+id+":", err)
+		return false
+	}
+
+	fetchedAt := time.Now().UTC().Truncate(time.Second)
+	data, failed := retrieveDashboardData(ctx, dashboard.Country, dashboard.Features)
+	retrieved := func(feature string) bool {
+		return featureEnabled(dashboard.Features, feature) && featureError(feature, failed) == nil
+	}
+	features, err := dashboardFeatures(dashboard.Features, data, retrieved)
+	if err != nil {
+		log.Println("Error refreshing the snapshot of dashboard "+id+":", err)
+		return false
+	}
+	values, err := featureValues(features, retrieved)
+	if err != nil {
+		log.Println("Error refreshing the snapshot of dashboard "+id+":", err)
+		return false
+	}
+	if len(values) == 0 {
+		return len(failed) == 0
+	}
+
+	snapshot, err := snapshotOf(ctx, snapshots, id, dashboard.Revision)
+	if err != nil {
+		log.Println("Error retrieving snapshot of dashboard "+id+":", err)
+		return false
+	}
+	if err := snapshots.Put(ctx, id, withFeatures(snapshot, values, fetchedAt)); err != nil {
+		log.Println("Error storing snapshot of dashboard "+id+":", err)
+		return false
+	}
+	return len(failed) == 0
+}
+
 /*
 Function will return population, capital, currency and area on a certain country
 */
-func retrieveCountryData(apiURL string, country string, w http.ResponseWriter, r *http.Request) (int, string, string, myFloat, error) {
+func retrieveCountryData(ctx context.Context, apiURL string, country string) (int, string, string, myFloat, error) {
 
 	myCountry := country
 
@@ -213,7 +801,7 @@ func retrieveCountryData(apiURL string, country string, w http.ResponseWriter, r
 	url := fmt.Sprintf(apiURL+"name/%s", countryUrl)
 
 	//Fetches data from specified country
-	err := utils.FetchURLdata(url, w, &chosenCountry)
+	err := upstream.GetJSON(ctx, url, &chosenCountry)
 	if err != nil {
 		return 0, "", "", 0, err
 	}
@@ -247,7 +835,7 @@ func retrieveCountryData(apiURL string, country string, w http.ResponseWriter, r
 This function will retrieve the capital, and then return coordinates to capital,
 Will use Geocoding API to fetch coordinates
 */
-func retrieveCoordinates(apiURL, capital string, w http.ResponseWriter, r *http.Request) (myFloat, myFloat, error) {
+func retrieveCoordinates(ctx context.Context, apiURL, capital string) (myFloat, myFloat, error) {
 
 	//Creates struct that contains coordinates
 	var myCoordinates struct {
@@ -259,9 +847,8 @@ func retrieveCoordinates(apiURL, capital string, w http.ResponseWriter, r *http.
 	url := fmt.Sprintf(apiURL+"%s"+"&count=1", capitalUrl)
 
 	//Fetching data from Geocoding API, with count 1, to retrieve first city with this name
-	err := utils.FetchURLdata(url, w, &myCoordinates)
+	err := upstream.GetJSON(ctx, url, &myCoordinates)
 	if err != nil {
-		http.Error(w, "Failed to retrieve coordinates", http.StatusInternalServerError)
 		return 0, 0, err
 	}
 	//Initializes longitude and latitude values
@@ -279,50 +866,220 @@ func retrieveCoordinates(apiURL, capital string, w http.ResponseWriter, r *http.
 }
 
 /*
-Function retrieves coordinates (to a certain capital),
-then returns temperature and precipitation
+Function retrieves the hourly values of one day at the coordinates of the weather features, keyed by feature.
+With days above 0 the daily forecast of that many days is retrieved as well. Everything is retrieved in one request
 */
-func retrieveWeather(urlAPI string, longitude myFloat, latitude myFloat, w http.ResponseWriter, r *http.Request) (myFloat, myFloat, error) {
+func retrieveWeather(ctx context.Context, urlAPI string, longitude myFloat, latitude myFloat, features []string,
+	days int) (map[string][]myFloat, []ForecastDay, error) {
 
 	long := strconv.FormatFloat(float64(longitude), 'f', 2, 32)
 	lat := strconv.FormatFloat(float64(latitude), 'f', 2, 32)
 
-	//Struct that contains the temperature (an array of hourly measurements of temperature in one day)
-	// and for precipitation in one day
+	//Struct that contains the hourly measurements of the variables (and the time of each hour, which is not used),
+	// and the daily values of the forecast
 	var myWeather struct {
-		Hourly struct {
-			Temperature   []myFloat `json:"temperature_2m"`
-			Precipitation []myFloat `json:"precipitation"`
-		} `json:"hourly"`
+		Hourly map[string]json.RawMessage `json:"hourly"`
+		Daily  struct {
+			Time             []string  `json:"time"`
+			TemperatureMax   []myFloat `json:"temperature_2m_max"`
+			TemperatureMin   []myFloat `json:"temperature_2m_min"`
+			PrecipitationSum []myFloat `json:"precipitation_sum"`
+			WeatherCode      []int     `json:"weather_code"`
+		} `json:"daily"`
+	}
+
+	//Only the variables of the features are asked for
+	variables := make([]string, len(features))
+	for i, feature := range features {
+		variables[i] = utils.WeatherVariables[feature]
+	}
+	query := "latitude=" + lat + "&longitude=" + long
+	if len(variables) > 0 {
+		query += "&hourly=" + strings.Join(variables, ",")
+	}
+
+	//The hours and days are those of the timezone of the coordinates, so today is the same day with or without
+	// the forecast
+	query += "&timezone=auto"
+	if days > 0 {
+		query += "&daily=temperature_2m_max,temperature_2m_min,precipitation_sum,weather_code" +
+			"&forecast_days=" + strconv.Itoa(days)
+	} else {
+		query += "&forecast_days=1"
 	}
 
 	//Fetching data from the forecast API
-	err := utils.FetchURLdata(urlAPI+"latitude="+lat+"&longitude="+long+"&hourly=temperature_2m,precipitation&forecast_days=1", w, &myWeather)
+	err := upstream.GetJSON(ctx, urlAPI+query, &myWeather)
 	if err != nil {
-		return 0, 0, err
+		return nil, nil, err
+	}
+
+	//Only the hours of the first day are aggregated. A variable that was asked for but is missing would aggregate
+	// to 0, so the response is a bad payload
+	hourly := make(map[string][]myFloat, len(features))
+	for i, feature := range features {
+		var values []myFloat
+		raw, ok := myWeather.Hourly[variables[i]]
+		if !ok {
+			return nil, nil, &upstream.Error{URL: urlAPI + query, StatusCode: http.StatusOK, Kind: upstream.ErrBadPayload,
+				Err: errors.New("hourly " + variables[i] + " is missing")}
+		}
+		if err := json.Unmarshal(raw, &values); err != nil {
+			return nil, nil, &upstream.Error{URL: urlAPI + query, StatusCode: http.StatusOK, Kind: upstream.ErrBadPayload, Err: err}
+		}
+		hourly[feature] = firstDay(values)
 	}
-	//Initializing sum of all temperatures, and add them together
-	sumTemp := myFloat(0.0)
-	for _, value := range myWeather.Hourly.Temperature {
-		sumTemp += value
+	if days == 0 {
+		return hourly, nil, nil
 	}
-	//finds average temperature using sumTemp and number of measurements
-	avgTemp := sumTemp / myFloat(len(myWeather.Hourly.Temperature))
 
-	//Initializing sum of all precipitation, and add them together
-	sumPrecipitation := myFloat(0.0)
-	for _, value := range myWeather.Hourly.Precipitation {
-		sumPrecipitation += value
+	//Puts the daily values together by day
+	daily := myWeather.Daily
+	forecast := make([]ForecastDay, len(daily.Time))
+	for i, date := range daily.Time {
+		forecast[i].Date = date
+		if forecast[i].TemperatureMin, err = floatFormat(valueAt(daily.TemperatureMin, i)); err != nil {
+			return nil, nil, err
+		}
+		if forecast[i].TemperatureMax, err = floatFormat(valueAt(daily.TemperatureMax, i)); err != nil {
+			return nil, nil, err
+		}
+		if forecast[i].PrecipitationSum, err = floatFormat(valueAt(daily.PrecipitationSum, i)); err != nil {
+			return nil, nil, err
+		}
+		if i < len(daily.WeatherCode) {
+			forecast[i].Weather = utils.WeatherDescription(daily.WeatherCode[i])
+		}
 	}
-	//finds average precipitiation using sumPrecipitation and number of measurements
-	avgPrecipitation := sumPrecipitation / myFloat(len(myWeather.Hourly.Precipitation))
 
 	//Returns data
-	return avgTemp, avgPrecipitation, nil
+	return hourly, forecast, nil
+}
+
+// Function that retrieves the current air quality at the coordinates
+func retrieveAirQuality(ctx context.Context, urlAPI string, longitude myFloat, latitude myFloat) (AirQuality, error) {
+	var result AirQuality
+
+	long := strconv.FormatFloat(float64(longitude), 'f', 2, 32)
+	lat := strconv.FormatFloat(float64(latitude), 'f', 2, 32)
+
+	//Struct that contains the current values of the air quality
+	var myAirQuality struct {
+		Current struct {
+			EuropeanAQI myFloat `json:"european_aqi"`
+			USAQI       myFloat `json:"us_aqi"`
+			PM25        myFloat `json:"pm2_5"`
+			PM10        myFloat `json:"pm10"`
+		} `json:"current"`
+	}
+
+	//Fetching data from the air quality API
+	err := upstream.GetJSON(ctx, urlAPI+"latitude="+lat+"&longitude="+long+"&current=european_aqi,us_aqi,pm2_5,pm10", &myAirQuality)
+	if err != nil {
+		return result, err
+	}
+
+	//The indexes are whole numbers, and the particulate matter is rounded to two decimals
+	current := myAirQuality.Current
+	result.EuropeanAQI = int(math.Round(float64(current.EuropeanAQI)))
+	result.USAQI = int(math.Round(float64(current.USAQI)))
+	if result.PM25, err = floatFormat(current.PM25); err != nil {
+		return result, err
+	}
+	if result.PM10, err = floatFormat(current.PM10); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// Returns the values of the first 24 hours
+func firstDay(hourly []myFloat) []myFloat {
+	if len(hourly) > 24 {
+		return hourly[:24]
+	}
+	return hourly
+}
+
+// Returns the value at the index, or 0 if there is none
+func valueAt(values []myFloat, i int) myFloat {
+	if i < len(values) {
+		return values[i]
+	}
+	return 0
+}
+
+// Function that averages the hourly directions in degrees on the circle, rounded to two decimals in [0, 360)
+func meanDirection(values []myFloat) (*WeatherValue, error) {
+	var x, y float64
+	for _, value := range values {
+		radians := float64(value) * math.Pi / 180
+		x += math.Cos(radians)
+		y += math.Sin(radians)
+	}
+	degrees := math.Mod(math.Atan2(y, x)*180/math.Pi+360, 360)
+
+	formatted, err := floatFormat(myFloat(degrees))
+	if err != nil {
+		return nil, err
+	}
+	//Rounding can give 360, which is north as well
+	if formatted >= 360 {
+		formatted = 0
+	}
+	return &WeatherValue{Value: formatted}, nil
+}
+
+/*
+Function that aggregates the hourly values of a weather feature into their mean, minimum, maximum or sum,
+rounded to two decimals. With the hourly aggregation every value is rounded, and the series is kept
+*/
+func aggregate(values []myFloat, aggregation string) (*WeatherValue, error) {
+	if aggregation == utils.AggregationHourly {
+		hourly := make([]myFloat, len(values))
+		for i, value := range values {
+			formatted, err := floatFormat(value)
+			if err != nil {
+				return nil, err
+			}
+			hourly[i] = formatted
+		}
+		return &WeatherValue{Hourly: hourly}, nil
+	}
+
+	//No values are aggregated into 0
+	if len(values) == 0 {
+		return &WeatherValue{}, nil
+	}
+
+	var result myFloat
+	switch aggregation {
+	case utils.AggregationMin, utils.AggregationMax:
+		result = values[0]
+		for _, value := range values[1:] {
+			if aggregation == utils.AggregationMin && value < result || aggregation == utils.AggregationMax && value > result {
+				result = value
+			}
+		}
+	case utils.AggregationSum, utils.AggregationMean:
+		for _, value := range values {
+			result += value
+		}
+		if aggregation == utils.AggregationMean {
+			result /= myFloat(len(values))
+		}
+	default:
+		return nil, fmt.Errorf("unknown aggregation %s", aggregation)
+	}
+
+	formatted, err := floatFormat(result)
+	if err != nil {
+		return nil, err
+	}
+	return &WeatherValue{Value: formatted}, nil
 }
 
 // Function that retrieves currency rates for said currency, returns a map that contains the currency rates
-func retrieveCurrencyExchangeRates(apiURL, currency string, w http.ResponseWriter, r *http.Request) (map[string]myFloat, error) {
+func retrieveCurrencyExchangeRates(ctx context.Context, apiURL, currency string) (map[string]myFloat, error) {
 	//Making a new map that will contain the currency rates
 	currencyData := make(map[string]myFloat)
 
@@ -332,7 +1089,7 @@ func retrieveCurrencyExchangeRates(apiURL, currency string, w http.ResponseWrite
 	}
 
 	//Fetching url data from currency Api with said currency and putting the data into the struct
-	err := utils.FetchURLdata(apiURL+currency, w, &Currencies)
+	err := upstream.GetJSON(ctx, apiURL+currency, &Currencies)
 	if err != nil {
 		return nil, err
 	}
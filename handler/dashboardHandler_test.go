@@ -1,72 +1,25 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
-// Test function for fetchURLdata
-func TestFetchURLData(t *testing.T) {
-	// Start a local HTTP server
-	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		// Send response to be tested
-		rw.Write([]byte(`{"key":"value"}`))
-	}))
-	// Close the server when test finishes
-	defer server.Close()
-
-	// Use server.URL as the argument to fetchURLdata
-	var data map[string]string
-	err := utils.FetchURLdata(server.URL, nil, &data)
-	if err != nil {
-		t.Fatal("Expected error, got nil")
-	}
-
-	// Check the data
-	expected := "Failed to fetch url: " + server.URL
-	if data["key"] != "value" {
-		t.Errorf("Expected value for key to be '%s', got %s", expected, err.Error())
-	}
-
-	// Create a mock HTTP server that returns an error
-	server3 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		http.Error(rw, "Internal Server Error", http.StatusInternalServerError)
-	}))
-	defer server3.Close()
-
-	// Call fetchURLdata with the mock server's URL
-	var data2 interface{}
-	rw := httptest.NewRecorder()
-	err2 := utils.FetchURLdata(server3.URL, rw, &data2)
-	if err2 == nil {
-		t.Error("expected an error, got nil")
-	}
-
-	// Create a mock HTTP server that returns an invalid JSON
-	server2 := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
-		rw.Write([]byte(`{invalid json}`))
-	}))
-	defer server2.Close()
-
-	// Call fetchURLdata with the second mock server's URL
-	err3 := utils.FetchURLdata(server2.URL, rw, &data2)
-	if err3 == nil {
-		t.Error("expected an error, got nil")
-	}
-
-	// Call fetchURLdata with an invalid URL
-	var data3 interface{}
-	rw2 := httptest.NewRecorder()
-	err4 := utils.FetchURLdata("http://invalid url", rw2, &data3)
-	if err4 == nil {
-		t.Error("expected an error, got nil")
-	}
-
-}
-
 // Test function for floatFormat
 func TestFloatFormat(t *testing.T) {
 	// Call the function
@@ -96,7 +49,7 @@ func TestRetrieveCountryData(t *testing.T) {
 	defer server.Close()
 
 	// Call the function with the mock server URL
-	population, capital, currency, area, err := retrieveCountryData(server.URL+"/name/", "TestCountry", nil, nil)
+	population, capital, currency, area, err := retrieveCountryData(context.Background(), server.URL+"/name/", "TestCountry")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -118,7 +71,7 @@ func TestRetrieveCoordinates(t *testing.T) {
 	defer server.Close()
 
 	// Call the function with the mock server URL
-	longitude, latitude, err := retrieveCoordinates(server.URL+"/json?address=", "TestLocation", nil, nil)
+	longitude, latitude, err := retrieveCoordinates(context.Background(), server.URL+"/json?address=", "TestLocation")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -140,7 +93,7 @@ func TestRetrieveCurrencyExchangeRates(t *testing.T) {
 	defer server.Close()
 
 	// Call the function with the mock server URL
-	currencyData, err := retrieveCurrencyExchangeRates(server.URL+"/latest?base=", "USD", nil, nil)
+	currencyData, err := retrieveCurrencyExchangeRates(context.Background(), server.URL+"/latest?base=", "USD")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -153,41 +106,101 @@ func TestRetrieveCurrencyExchangeRates(t *testing.T) {
 
 // Test function for retrieveWeather
 func TestRetrieveWeather(t *testing.T) {
-	// Create a mock HTTP server
+	// Create a mock HTTP server, which records the query
+	var query url.Values
 	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		query = req.URL.Query()
 		// Respond with a body set by the test
 		rw.Write([]byte(`{
             "hourly": {
+                "time": ["2024-03-01T00:00", "2024-03-01T01:00", "2024-03-01T02:00"],
                 "temperature_2m": [20.1, 21.2, 22.3],
-                "precipitation": [0.0, 0.0, 0.1]
+                "wind_speed_10m": [3.5, 4.0, 4.5]
             }
         }`))
 	}))
 	defer server.Close()
 
-	// Create a new HTTP response writer
-	rw := httptest.NewRecorder()
-
 	// Call retrieveWeather with the mock server's URL
-	avgTemp, avgPrecipitation, err := retrieveWeather(server.URL+"/", 50.1234, 5.1234, rw, nil)
+	hourly, forecast, err := retrieveWeather(context.Background(), server.URL+"/?", 50.1234, 5.1234, []string{"temperature", "windSpeed"}, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if forecast != nil {
+		t.Errorf("expected no forecast, got %v", forecast)
+	}
+
+	// Only the variables of the features are asked for
+	if query.Get("hourly") != "temperature_2m,wind_speed_10m" || query.Get("daily") != "" || query.Get("forecast_days") != "1" ||
+		query.Get("timezone") != "auto" {
+		t.Errorf("unexpected query %v", query)
+	}
 
-	// Check the returned average temperature and precipitation
-	expectedAvgTemp := myFloat((20.1 + 21.200001 + 22.3) / 3)
-	if avgTemp != expectedAvgTemp {
-		t.Errorf("expected average temperature to be %v, got %v", expectedAvgTemp, avgTemp)
+	// Check the returned hourly values
+	if !reflect.DeepEqual(hourly["temperature"], []myFloat{20.1, 21.2, 22.3}) {
+		t.Errorf("expected the hourly temperature [20.1 21.2 22.3], got %v", hourly["temperature"])
 	}
-	expectedAvgPrecipitation := myFloat((0.0 + 0.0 + 0.1) / 3)
-	if avgPrecipitation != expectedAvgPrecipitation {
-		t.Errorf("expected average precipitation to be %v, got %v", expectedAvgPrecipitation, avgPrecipitation)
+	if !reflect.DeepEqual(hourly["windSpeed"], []myFloat{3.5, 4.0, 4.5}) {
+		t.Errorf("expected the hourly wind speed [3.5 4 4.5], got %v", hourly["windSpeed"])
+	}
+}
+
+// Test that a response without one of the hourly variables asked for is a bad payload, and not aggregated to 0
+func TestRetrieveWeatherMissingVariable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Write([]byte(`{
+            "hourly": {
+                "time": ["2024-03-01T00:00", "2024-03-01T01:00"],
+                "temperature_2m": [20.1, 21.2]
+            }
+        }`))
+	}))
+	defer server.Close()
+
+	_, _, err := retrieveWeather(context.Background(), server.URL+"/?", 50.1234, 5.1234, []string{"temperature", "windSpeed"}, 0)
+	if !errors.Is(err, upstream.ErrBadPayload) || !strings.Contains(err.Error(), "wind_speed_10m") {
+		t.Errorf("Expected a bad payload without wind_speed_10m, got %v", err)
+	}
+}
+
+// Test for aggregate, and of how the aggregated values are written as JSON
+func TestAggregate(t *testing.T) {
+	values := []myFloat{0.4, 1.2, 0.1, 0.0}
+	tests := []struct {
+		aggregation string
+		want        string
+	}{
+		{utils.AggregationMean, `0.43`},
+		{utils.AggregationMin, `0`},
+		{utils.AggregationMax, `1.2`},
+		{utils.AggregationSum, `1.7`},
+		{utils.AggregationHourly, `[0.4,1.2,0.1,0]`},
+	}
+	for _, test := range tests {
+		value, err := aggregate(values, test.aggregation)
+		if err != nil {
+			t.Fatalf("aggregate(%s) failed: %v", test.aggregation, err)
+		}
+		data, err := json.Marshal(value)
+		if err != nil || string(data) != test.want {
+			t.Errorf("aggregate(%s) = %s, %v, want %s", test.aggregation, data, err, test.want)
+		}
+
+		// Snapshots of the dashboard are read back from JSON
+		var read WeatherValue
+		if err := json.Unmarshal(data, &read); err != nil || !reflect.DeepEqual(&read, value) {
+			t.Errorf("Expected %s to be read back as %+v, got %+v, %v", data, value, read, err)
+		}
+	}
+
+	if _, err := aggregate(values, "median"); err == nil {
+		t.Error("Expected an unknown aggregation to fail")
 	}
 }
 
 func TestDashboardsHandler(t *testing.T) {
 	// Initialize handler instance
-	handler := DashboardHandler()
+	handler := DashboardHandler(store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
 
 	// set up structure to be used for testing and close when finished testing
 	server := httptest.NewServer(http.HandlerFunc(handler))
@@ -223,10 +236,632 @@ func TestDashboardFunc(t *testing.T) {
 	// Create a ResponseRecorder to record the response.
 	w := httptest.NewRecorder()
 	// Call the function
-	DashboardFunc(w, req)
+	DashboardFunc(w, req, store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
 	// Check the result
 	if w.Result().StatusCode != http.StatusBadRequest {
 		t.Errorf("dashboard() returned wrong status code for empty myID: got %v want %v", w.Result().StatusCode, http.StatusBadRequest)
 	}
 
 }
+
+// Transport of the tests that records the upstream APIs requested, and runs hold before passing requests on
+type recordingTransport struct {
+	next http.RoundTripper
+	hold func(source string, req *http.Request) error
+
+	mu      sync.Mutex
+	sources []string
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	source := ""
+	for name, prefix := range map[string]string{utils.CountriesSource: utils.COUNTRIES_API,
+		utils.GeocodingSource: utils.GEOCODING_API, utils.ForecastSource: utils.FORECAST_API,
+		utils.AirQualitySource: utils.AIR_QUALITY_API, utils.CurrencySource: utils.CURRENCY_API} {
+		if strings.HasPrefix(url, prefix) {
+			source = name
+		}
+	}
+
+	t.mu.Lock()
+	t.sources = append(t.sources, source)
+	t.mu.Unlock()
+
+	if t.hold != nil {
+		if err := t.hold(source, req); err != nil {
+			return nil, err
+		}
+	}
+	return t.next.RoundTrip(req)
+}
+
+// Transport of the tests, which passes the requests on to the recording transport of the running test, if any. It is
+// installed as http.DefaultTransport once, since requests of an earlier test may still be sent when the next starts
+type testTransport struct {
+	next http.RoundTripper
+
+	mu        sync.Mutex
+	recording *recordingTransport
+}
+
+// Transport of the tests, installed by TestMain
+var upstreams *testTransport
+
+// RoundTrip implements http.RoundTripper
+func (t *testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mu.Lock()
+	recording := t.recording
+	t.mu.Unlock()
+
+	if recording == nil {
+		return t.next.RoundTrip(req)
+	}
+	return recording.RoundTrip(req)
+}
+
+// Passes the requests on to the recording transport, or straight on if it is nil
+func (t *testTransport) record(recording *recordingTransport) {
+	t.mu.Lock()
+	t.recording = recording
+	t.mu.Unlock()
+}
+
+// Records the requests to the upstream APIs with a recording transport until the test is done
+func recordUpstreams(t *testing.T, hold func(source string, req *http.Request) error) *recordingTransport {
+	transport := &recordingTransport{next: upstreams.next, hold: hold}
+	upstreams.record(transport)
+	t.Cleanup(func() { upstreams.record(nil) })
+	return transport
+}
+
+// Test that only the upstream APIs of the enabled features are requested
+func TestRetrieveDashboardDataSkipsSources(t *testing.T) {
+	tests := []struct {
+		features utils.Features_Get
+		sources  []string
+	}{
+		{utils.Features_Get{}, nil},
+		{utils.Features_Get{Population: true, Area: true}, []string{utils.CountriesSource}},
+		{utils.Features_Get{Coordinates: true}, []string{utils.CountriesSource, utils.GeocodingSource}},
+		{utils.Features_Get{Temperature: true}, []string{utils.CountriesSource, utils.GeocodingSource, utils.ForecastSource}},
+		{utils.Features_Get{TargetCurrencies: []string{"EUR"}}, []string{utils.CountriesSource, utils.CurrencySource}},
+		{utils.Features_Get{AirQuality: true}, []string{utils.CountriesSource, utils.GeocodingSource, utils.AirQualitySource}},
+	}
+	for _, test := range tests {
+		transport := recordUpstreams(t, nil)
+		if _, failed := retrieveDashboardData(context.Background(), "Norway", test.features); len(failed) != 0 {
+			t.Fatalf("retrieveDashboardData(%+v) failed: %v", test.features, failed)
+		}
+		upstreams.record(nil)
+
+		sort.Strings(transport.sources)
+		sort.Strings(test.sources)
+		if strings.Join(transport.sources, ",") != strings.Join(test.sources, ",") {
+			t.Errorf("Features %+v requested %v, want %v", test.features, transport.sources, test.sources)
+		}
+	}
+}
+
+// Test that the coordinates and the rates are fetched at the same time, and that a source that does not answer in
+// time leaves its features out, or fails the dashboard with a timeout in strict mode
+func TestDashboardFuncConcurrentFetches(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Coordinates: true, Temperature: true, TargetCurrencies: []string{"EUR"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rates are held until the coordinates are requested, which never happens if they are fetched one after another.
+	// A fetch that is still held when the test is done is failed, so later requests do not share it
+	geocoding := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	var once sync.Once
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		switch source {
+		case utils.GeocodingSource:
+			once.Do(func() { close(geocoding) })
+		case utils.CurrencySource:
+			select {
+			case <-geocoding:
+			case <-req.Context().Done():
+				return req.Context().Err()
+			case <-done:
+				return errors.New("test is done")
+			}
+		}
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result OutputDashboardWithData
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Features.Temperature == nil || result.Features.Temperature.Value != 11.5 || result.Features.Coordinates.Latitude == 0 || result.Features.TargetCurrencies["EUR"] == 0 {
+		t.Errorf("Unexpected features %+v", result.Features)
+	}
+
+	// The rates are held until their deadline has passed
+	timeout := sourceTimeouts[utils.CurrencySource]
+	sourceTimeouts[utils.CurrencySource] = 50 * time.Millisecond
+	defer func() { sourceTimeouts[utils.CurrencySource] = timeout }()
+	geocoding = make(chan struct{})
+
+	rr = httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	result = OutputDashboardWithData{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Features.Temperature == nil || result.Features.Temperature.Value != 11.5 || result.Features.TargetCurrencies != nil || len(result.Errors) != 1 ||
+		!strings.Contains(result.Errors["targetCurrencies"], "did not answer in time") {
+		t.Errorf("Unexpected partial result %+v", result)
+	}
+
+	rr = httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id+"?strict=true", nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusGatewayTimeout {
+		t.Errorf("Expected %d in strict mode, got %d: %s", http.StatusGatewayTimeout, rr.Code, rr.Body.String())
+	}
+}
+
+// Test that a dashboard fails when none of its features can be retrieved, and that strict must be a boolean
+func TestDashboardFuncFailures(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Atlantis", IsoCode: "AT",
+		Features: utils.Features_Get{Capital: true, Temperature: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, status := range map[string]int{id: http.StatusBadGateway, id + "?strict=yes": http.StatusBadRequest} {
+		rr := httptest.NewRecorder()
+		DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+path, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+		if rr.Code != status {
+			t.Errorf("GET %s returned %d, want %d: %s", path, rr.Code, status, rr.Body.String())
+		}
+	}
+}
+
+// Test that features that can not be fetched are served stale from the last dashboard, and that its snapshot is
+// refreshed in the background once the upstream APIs answer again
+func TestDashboardFuncStaleSnapshot(t *testing.T) {
+	// Requests are not retried, and breakers opened by the test do not affect the other tests
+	client := upstream.DefaultClient
+	upstream.DefaultClient = upstream.NewClient()
+	upstream.DefaultClient.Retries = 0
+	defer func() { upstream.DefaultClient = client }()
+	interval := snapshotRefreshInterval
+	snapshotRefreshInterval = 10 * time.Millisecond
+	defer func() { snapshotRefreshInterval = interval }()
+
+	dashboards := store.NewMemoryDashboards()
+	snapshots := store.NewMemorySnapshots()
+	refresher := NewSnapshotRefresher(context.Background())
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Capital: true, Temperature: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failing atomic.Bool
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		if failing.Load() && source == utils.CountriesSource {
+			return errors.New("countries API is down")
+		}
+		return nil
+	})
+
+	// Fetches the dashboard, and checks the status code
+	get := func(status int) OutputDashboardWithData {
+		rr := httptest.NewRecorder()
+		DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), snapshots, refresher)
+		if rr.Code != status {
+			t.Fatalf("Expected %d, got %d: %s", status, rr.Code, rr.Body.String())
+		}
+		var result OutputDashboardWithData
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	fresh := get(http.StatusOK)
+	if fresh.Freshness["capital"].Stale || fresh.Freshness["temperature"].FetchedAt.IsZero() {
+		t.Fatalf("Expected fresh features, got %+v", fresh.Freshness)
+	}
+
+	// Every feature depends on the countries API, so all are served from the snapshot
+	failing.Store(true)
+	stale := get(http.StatusOK)
+	if !reflect.DeepEqual(stale.Features, fresh.Features) || len(stale.Errors) != 0 {
+		t.Errorf("Expected the features %+v of the snapshot, got %+v with errors %v", fresh.Features, stale.Features, stale.Errors)
+	}
+	for feature, freshness := range stale.Freshness {
+		if !freshness.Stale || !freshness.FetchedAt.Equal(fresh.Freshness[feature].FetchedAt) {
+			t.Errorf("Expected %s to be stale since %v, got %+v", feature, fresh.Freshness[feature].FetchedAt, freshness)
+		}
+	}
+
+	// Strict mode does not accept stale features
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id+"?strict=true", nil), dashboards, store.NewMemoryWebhooks(), snapshots, refresher)
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected %d in strict mode, got %d: %s", http.StatusBadGateway, rr.Code, rr.Body.String())
+	}
+
+	// The snapshot is made older, so it is seen when the refresh replaces it
+	old := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	snapshot, err := snapshots.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for feature, value := range snapshot.Features {
+		value.FetchedAt = old
+		snapshot.Features[feature] = value
+	}
+	if err := snapshots.Put(context.Background(), id, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	failing.Store(false)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if !refresher.refreshing(id) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The snapshot was not refreshed in time")
+		}
+	}
+	snapshot, err = snapshots.Get(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Features) != 2 || !snapshot.Features["capital"].FetchedAt.After(old) || !snapshot.Features["temperature"].FetchedAt.After(old) {
+		t.Errorf("Expected the snapshot to be refreshed, got %+v", snapshot)
+	}
+}
+
+// Test that a refresh stops when the configuration is deleted or at another revision, without storing a snapshot,
+// and that the refreshes stop when the context of the refresher is cancelled
+func TestSnapshotRefresherStops(t *testing.T) {
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	snapshots := store.NewMemorySnapshots()
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Capital: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboard, err := dashboards.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !refreshSnapshot(ctx, id, dashboard.Revision-1, dashboards, snapshots) {
+		t.Error("Expected the refresh of an earlier revision to stop")
+	}
+	if !refreshSnapshot(ctx, "gone", dashboard.Revision, dashboards, snapshots) {
+		t.Error("Expected the refresh of a deleted configuration to stop")
+	}
+	if _, err := snapshots.Get(ctx, id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected no snapshot, got %v", err)
+	}
+
+	// The refresh is waiting for its first attempt when the refresher is cancelled
+	interval := snapshotRefreshInterval
+	snapshotRefreshInterval = time.Hour
+	defer func() { snapshotRefreshInterval = interval }()
+	refreshCtx, cancel := context.WithCancel(ctx)
+	refresher := NewSnapshotRefresher(refreshCtx)
+	refresher.Refresh(id, dashboard.Revision, dashboards, snapshots)
+	if !refresher.refreshing(id) {
+		t.Fatal("Expected the snapshot to be refreshed")
+	}
+
+	cancel()
+	stopped := make(chan struct{})
+	go func() {
+		refresher.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("The refresh did not stop when the refresher was cancelled")
+	}
+
+	// A cancelled refresher does not start refreshes
+	refresher.Refresh(id, dashboard.Revision, dashboards, snapshots)
+	if refresher.refreshing(id) {
+		t.Error("Expected a cancelled refresher not to refresh")
+	}
+}
+
+// Test that the snapshot of an earlier revision of the configuration is not served, and that changing the
+// configuration removes the snapshot
+func TestDashboardFuncSnapshotOfRevision(t *testing.T) {
+	client := upstream.DefaultClient
+	upstream.DefaultClient = upstream.NewClient()
+	upstream.DefaultClient.Retries = 0
+	defer func() { upstream.DefaultClient = client }()
+
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	snapshots := store.NewMemorySnapshots()
+	registrations := RegistrationHandler(dashboards, store.NewMemoryWebhooks(), store.NewMemoryAuditLog(), snapshots)
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Capital: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var failing atomic.Bool
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		if failing.Load() && source == utils.CountriesSource {
+			return errors.New("countries API is down")
+		}
+		return nil
+	})
+
+	// Fetches the dashboard, and returns the response
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), snapshots, nil)
+		return rr
+	}
+	if rr := get(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"capital":"Oslo"`) {
+		t.Fatalf("Expected the capital of Norway, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// A configuration changed behind the handler still has the snapshot of the earlier revision, which is not served
+	if _, err := dashboards.Update(ctx, utils.Dashboard_Get{ID: id, Country: "Sweden", IsoCode: "SE",
+		Features: utils.Features_Get{Capital: true}}, store.AnyRevision); err != nil {
+		t.Fatal(err)
+	}
+	failing.Store(true)
+	if rr := get(); rr.Code != http.StatusBadGateway || strings.Contains(rr.Body.String(), "Oslo") {
+		t.Errorf("Expected %d without the snapshot of Norway, got %d: %s", http.StatusBadGateway, rr.Code, rr.Body.String())
+	}
+
+	// The snapshot of the current revision is served
+	failing.Store(false)
+	if rr := get(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"capital":"Stockholm"`) {
+		t.Fatalf("Expected the capital of Sweden, got %d: %s", rr.Code, rr.Body.String())
+	}
+	failing.Store(true)
+	if rr := get(); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"capital":"Stockholm"`) {
+		t.Errorf("Expected the capital of Sweden from the snapshot, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Changing the configuration removes its snapshot
+	failing.Store(false)
+	rr := httptest.NewRecorder()
+	registrations(rr, httptest.NewRequest(http.MethodPatch, utils.REGISTRATION_LINE_PATH+id,
+		strings.NewReader(`{"country": "Norway", "isoCode": "NO"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, err := snapshots.Get(ctx, id); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("Expected the snapshot to be removed by the change, got %v", err)
+	}
+}
+
+// Test that the weather features are aggregated as chosen by the configuration, and that the dashboard tells how
+func TestDashboardFuncAggregation(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Temperature: true, Precipitation: true,
+			Aggregation: map[string]string{"temperature": utils.AggregationMax, "precipitation": utils.AggregationSum}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result OutputDashboardWithData
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Features.Temperature == nil || result.Features.Temperature.Value != 23 ||
+		result.Features.Precipitation == nil || result.Features.Precipitation.Value != 3 {
+		t.Errorf("Expected the maximum temperature 23 and the precipitation sum 3, got %s", rr.Body.String())
+	}
+	if result.Aggregation["temperature"] != utils.AggregationMax || result.Aggregation["precipitation"] != utils.AggregationSum {
+		t.Errorf("Unexpected aggregation %v", result.Aggregation)
+	}
+
+	// The hourly series is shown as it is, while the precipitation is averaged by default
+	id, err = dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Temperature: true, Precipitation: true,
+			Aggregation: map[string]string{"temperature": utils.AggregationHourly}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr = httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	result = OutputDashboardWithData{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Features.Temperature == nil || len(result.Features.Temperature.Hourly) != 24 || result.Features.Temperature.Hourly[23] != 23 ||
+		result.Features.Precipitation == nil || result.Features.Precipitation.Value != 0.12 {
+		t.Errorf("Expected the hourly temperature and the mean precipitation, got %s", rr.Body.String())
+	}
+	if result.Aggregation["precipitation"] != utils.AggregationMean {
+		t.Errorf("Expected the precipitation to be aggregated by %s, got %v", utils.AggregationMean, result.Aggregation)
+	}
+}
+
+// Test that the daily forecast is shown for the days of the configuration, while the weather features still show today
+func TestDashboardFuncForecast(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Temperature: true, Forecast: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result OutputDashboardWithData
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ForecastDay{
+		{Date: "2024-03-01", TemperatureMin: 0, TemperatureMax: 23, PrecipitationSum: 3, Weather: "Clear sky"},
+		{Date: "2024-03-02", TemperatureMin: 0, TemperatureMax: 23, PrecipitationSum: 3, Weather: "Overcast"},
+		{Date: "2024-03-03", TemperatureMin: 0, TemperatureMax: 23, PrecipitationSum: 3, Weather: "Slight rain"},
+	}
+	if !reflect.DeepEqual(result.Features.Forecast, want) {
+		t.Errorf("Expected the forecast %+v, got %+v", want, result.Features.Forecast)
+	}
+	if result.Features.Temperature == nil || result.Features.Temperature.Value != 11.5 {
+		t.Errorf("Expected the mean temperature of today 11.5, got %s", rr.Body.String())
+	}
+	if freshness, ok := result.Freshness["forecast"]; !ok || freshness.Stale {
+		t.Errorf("Expected a fresh forecast, got %+v", result.Freshness)
+	}
+}
+
+// Test that the weather features are of the same day whether the forecast is shown or not
+func TestDashboardFuncForecastSameDay(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+
+	// Returns the hourly temperature of today of a dashboard showing the forecast of the days
+	temperature := func(days int) []myFloat {
+		id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+			Features: utils.Features_Get{Temperature: true, Forecast: days,
+				Aggregation: map[string]string{"temperature": utils.AggregationHourly}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+		}
+		var result OutputDashboardWithData
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		if result.Features.Temperature == nil {
+			t.Fatalf("Expected the temperature, got %s", rr.Body.String())
+		}
+		return result.Features.Temperature.Hourly
+	}
+
+	without, with := temperature(0), temperature(3)
+	if len(without) != 24 || without[0] != 0 || !reflect.DeepEqual(without, with) {
+		t.Errorf("Expected the same local day from midnight, got %v without the forecast and %v with it", without, with)
+	}
+}
+
+// Test that the weather variables are shown when they are enabled, and only those are asked for
+func TestDashboardFuncWeatherVariables(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{WindSpeed: true, WindDirection: true, Humidity: true, CloudCover: true, UVIndex: true,
+			ApparentTemperature: true, Aggregation: map[string]string{"uvIndex": utils.AggregationMax}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var forecastQueries []url.Values
+	var mu sync.Mutex
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		if source == utils.ForecastSource {
+			mu.Lock()
+			forecastQueries = append(forecastQueries, req.URL.Query())
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result OutputDashboardWithData
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]myFloat{"windSpeed": 5, "windDirection": 0, "humidity": 80, "cloudCover": 50, "uvIndex": 4,
+		"apparentTemperature": 9.5}
+	for feature, value := range want {
+		if got := *result.Features.weather(feature); got == nil || got.Value != value {
+			t.Errorf("Expected %s to be %v, got %+v", feature, value, got)
+		}
+	}
+	if result.Features.Temperature != nil || result.Features.Precipitation != nil {
+		t.Errorf("Expected no temperature or precipitation, got %s", rr.Body.String())
+	}
+
+	if len(forecastQueries) != 1 || forecastQueries[0].Get("hourly") !=
+		"wind_speed_10m,wind_direction_10m,relative_humidity_2m,cloud_cover,uv_index,apparent_temperature" {
+		t.Errorf("Expected one forecast request for the enabled variables, got %v", forecastQueries)
+	}
+}
+
+// Test that the air quality is shown at the coordinates of the capital, fetched alongside the weather
+func TestDashboardFuncAirQuality(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: "Norway", IsoCode: "NO",
+		Features: utils.Features_Get{Temperature: true, AirQuality: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var airQualityQueries []url.Values
+	var mu sync.Mutex
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		if source == utils.AirQualitySource {
+			mu.Lock()
+			airQualityQueries = append(airQualityQueries, req.URL.Query())
+			mu.Unlock()
+		}
+		return nil
+	})
+
+	rr := httptest.NewRecorder()
+	DashboardFunc(rr, httptest.NewRequest(http.MethodGet, utils.DASHBOARD_PATH+id, nil), dashboards, store.NewMemoryWebhooks(), store.NewMemorySnapshots(), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+	var result OutputDashboardWithData
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	want := AirQuality{EuropeanAQI: 21, USAQI: 30, PM25: 4.6, PM10: 7.2}
+	if result.Features.AirQuality == nil || *result.Features.AirQuality != want {
+		t.Errorf("Expected the air quality %+v, got %+v", want, result.Features.AirQuality)
+	}
+	if result.Features.Temperature == nil {
+		t.Errorf("Expected the temperature alongside the air quality, got %s", rr.Body.String())
+	}
+
+	if len(airQualityQueries) != 1 || airQualityQueries[0].Get("current") != "european_aqi,us_aqi,pm2_5,pm10" ||
+		airQualityQueries[0].Get("latitude") == "" || airQualityQueries[0].Get("longitude") == "" {
+		t.Errorf("Expected one air quality request at the coordinates, got %v", airQualityQueries)
+	}
+}
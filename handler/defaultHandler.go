@@ -22,9 +22,54 @@ func DefaultHandler(w http.ResponseWriter, r *http.Request) {
 	DashboardFunction(w)
 	NotificationFunction(w)
 	StatusFunction(w)
+	AdminFunction(w)
 
 }
 
+// Format and write admin endpoint data
+func AdminFunction(w http.ResponseWriter) {
+	// Define type for output
+	type OutputsAdmin []utils.DefaultEndpointStruct
+
+	// Define data
+	outputAdmin := OutputsAdmin{
+		utils.DefaultEndpointStruct{
+			Url:         utils.API_KEY_PATH,
+			Method:      "POST",
+			Description: "Issue an API key for an owner (admins only)"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.API_KEY_PATH,
+			Method:      "GET",
+			Description: "View all API keys (admins only)"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.API_KEY_PATH + "{id}",
+			Method:      "GET",
+			Description: "View a specific API key (admins only)"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.API_KEY_PATH + "{id}",
+			Method:      "DELETE",
+			Description: "Revoke an API key (admins only)"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.AUDIT_PATH,
+			Method:      "GET",
+			Description: "View the audit log of changes to configurations and webhooks (admins only)"},
+	}
+
+	// Marshall data into JSON with proper indentation
+	jsonDataAdmin, err := json.MarshalIndent(outputAdmin, "", "\t")
+	if err != nil {
+		http.Error(w, "Error converting to JSON", http.StatusInternalServerError)
+	}
+
+	// Write message to response
+	_, err2 := fmt.Fprintf(w, "\n\nAdmin endpoint:\n")
+	if err2 != nil {
+		http.Error(w, "Error when returning output", http.StatusInternalServerError)
+	}
+	// Write data to response
+	w.Write(jsonDataAdmin)
+}
+
 // Format and write status endpoint data
 func StatusFunction(w http.ResponseWriter) {
 	// Define type for output
@@ -67,7 +112,15 @@ func NotificationFunction(w http.ResponseWriter) {
 		utils.DefaultEndpointStruct{
 			Url:         utils.NOTIFICATION_PATH + "{id}",
 			Method:      "DELETE",
-			Description: "Deletion of Webhook"},
+			Description: "Deletion of Webhook, which is moved to the trash"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.NOTIFICATION_PATH + "trash",
+			Method:      "GET",
+			Description: "View all deleted Webhooks in the trash"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.NOTIFICATION_PATH + "trash/{id}/restore",
+			Method:      "POST",
+			Description: "Restore a deleted Webhook from the trash"},
 		utils.DefaultEndpointStruct{
 			Url:         utils.NOTIFICATION_PATH + "{id}",
 			Method:      "GET",
@@ -107,7 +160,7 @@ func DashboardFunction(w http.ResponseWriter) {
 		utils.DefaultEndpointStruct{
 			Url:         utils.DASHBOARD_PATH + "{id}",
 			Method:      "GET",
-			Description: "Retrieve populated dashboard"},
+			Description: "Retrieve populated dashboard. Use ?strict=true to fail instead of leaving out features that could not be retrieved"},
 	}
 
 	// Marshall data into JSON with proper indentation
@@ -156,6 +209,30 @@ func Registrationfunction(w http.ResponseWriter) {
 			Url:         utils.REGISTRATION_PATH + "{id}",
 			Method:      "DELETE",
 			Description: "Delete a specific registered dashboard configuration"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "{id}/revisions",
+			Method:      "GET",
+			Description: "View all revisions of a registered dashboard configuration"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "{id}/revisions/{n}",
+			Method:      "GET",
+			Description: "View a specific revision of a registered dashboard configuration"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "{id}/revisions/diff{?from=<n>&to=<m>}",
+			Method:      "GET",
+			Description: "View the changes between two revisions of a registered dashboard configuration"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "{id}/revisions/{n}/restore",
+			Method:      "POST",
+			Description: "Restore a revision of a registered dashboard configuration"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "trash",
+			Method:      "GET",
+			Description: "View all deleted dashboard configurations in the trash"},
+		utils.DefaultEndpointStruct{
+			Url:         utils.REGISTRATION_LINE_PATH + "trash/{id}/restore",
+			Method:      "POST",
+			Description: "Restore a deleted dashboard configuration from the trash"},
 	}
 
 	// Marshall data into JSON with proper indentation
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Returns the entity tag of a stored dashboard configuration, derived from its revision
+func etagOf(dashboard utils.Dashboard_Get) string {
+	return strconv.Quote(strconv.Itoa(store.RevisionNumber(dashboard)))
+}
+
+// Checks the 'If-Match' header of the request against the current configuration.
+// Without the header, or with '*', any revision matches. Weak tags never match, as writes need a strong comparison
+func ifMatch(r *http.Request, current utils.Dashboard_Get) bool {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+
+	etag := etagOf(current)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Answers a write made on another revision than the current one
+func preconditionFailed(w http.ResponseWriter, dashboardID string) {
+	http.Error(w, "Document with ID "+dashboardID+" has been changed, 'If-Match' does not match its current ETag",
+		http.StatusPreconditionFailed)
+}
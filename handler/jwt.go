@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/sync/singleflight"
+)
+
+// How often a JWKS loaded from a URL is fetched again at most, when a token is signed with an unknown key
+const jwksRefreshInterval = time.Minute
+
+// Signing methods accepted for bearer tokens. Tokens are only signed with the private keys of the JWKS
+var tokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+// A JSON Web Key, with the members of RSA and EC public keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// JWKS is a set of public keys that bearer tokens are signed with, by key ID. Keys loaded from a URL are fetched
+// again when a token is signed with a key that is not in the set
+type JWKS struct {
+	source string
+	// Fetch of the keys in flight, shared by the tokens signed with unknown keys
+	refreshes singleflight.Group
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// LoadJWKS loads the public keys from a JWKS file, or from a http(s) URL
+func LoadJWKS(source string) (*JWKS, error) {
+	keys, err := readJWKS(source)
+	if err != nil {
+		return nil, err
+	}
+	return &JWKS{source: source, keys: keys, fetchedAt: time.Now()}, nil
+}
+
+// Reports whether the source of keys is a URL
+func remoteJWKS(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// Reads the keys from the file or URL
+func readJWKS(source string) (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if remoteJWKS(source) {
+		data, err = fetchJWKS(source)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, errors.New("Error loading JWKS from " + source + ": " + err.Error())
+	}
+
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, errors.New("Invalid JWKS from " + source + ": " + err.Error())
+	}
+	return keys, nil
+}
+
+// Fetches the JWKS from the URL
+func fetchJWKS(url string) ([]byte, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d", res.StatusCode)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// Parses the RSA and EC public keys of a JWKS. Keys used for other things than signatures are left out
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return nil, errors.New("key '" + jwk.Kid + "': " + err.Error())
+		}
+		keys[jwk.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return keys, nil
+}
+
+// Decodes a base64url encoded number of a key
+func decodeNumber(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid number '" + value + "'")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// Returns the public key of the JSON Web Key
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.Kty {
+	case "RSA":
+		n, err := decodeNumber(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeNumber(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("unsupported exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("unsupported curve '" + jwk.Crv + "'")
+		}
+		x, err := decodeNumber(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeNumber(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, errors.New("unsupported key type '" + jwk.Kty + "'")
+	}
+}
+
+// Returns the key with the key ID. A token without key ID can only be verified by a set with one key
+func (s *JWKS) key(kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	key, ok := s.lookup(kid)
+	refresh := !ok && remoteJWKS(s.source) && time.Since(s.fetchedAt) >= jwksRefreshInterval
+	s.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	// The keys may have been rotated since they were fetched. They are fetched without the lock, so tokens signed
+	// with known keys are not held up by a slow fetch
+	if refresh {
+		if _, err, _ := s.refreshes.Do(s.source, s.refresh); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		key, ok = s.lookup(kid)
+		s.mu.Unlock()
+		if ok {
+			return key, nil
+		}
+	}
+	return nil, errors.New("unknown signing key '" + kid + "'")
+}
+
+// Fetches the keys again, and swaps them in
+func (s *JWKS) refresh() (interface{}, error) {
+	keys, err := readJWKS(s.source)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil, nil
+}
+
+// Looks up the key with the key ID. Must be called with the lock held
+func (s *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// TokenVerifier verifies bearer tokens signed with the keys of a JWKS, and maps their claims to a principal
+type TokenVerifier struct {
+	keys     *JWKS
+	issuer   string
+	audience string
+
+	// Claim with the owner, by default 'sub', and claim with the roles, by default 'roles'
+	OwnerClaim string
+	RolesClaim string
+}
+
+// NewTokenVerifier gives a verifier of tokens signed with the keys, which must have the issuer and audience
+func NewTokenVerifier(keys *JWKS, issuer string, audience string) *TokenVerifier {
+	return &TokenVerifier{keys: keys, issuer: issuer, audience: audience, OwnerClaim: "sub", RolesClaim: "roles"}
+}
+
+// Verify checks the signature, issuer, audience and expiry of the token, and returns the principal of its claims
+func (v *TokenVerifier) Verify(token string) (Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return v.keys.key(kid)
+	}, jwt.WithValidMethods(tokenMethods), jwt.WithIssuer(v.issuer), jwt.WithAudience(v.audience),
+		jwt.WithExpirationRequired())
+	if err != nil {
+		return Principal{}, err
+	}
+
+	owner, _ := claims[v.OwnerClaim].(string)
+	if owner == "" {
+		return Principal{}, errors.New("token has no '" + v.OwnerClaim + "' claim")
+	}
+	return Principal{Owner: owner, Roles: rolesClaim(claims[v.RolesClaim])}, nil
+}
+
+// Returns the roles of a claim, given as a list of strings or as a space separated string
+func rolesClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		roles := make([]string, 0, len(value))
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	default:
+		return nil
+	}
+}
+
+// Returns the bearer token of the 'Authorization' header, if any
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Issuer and audience of the tokens of the tests
+const (
+	testIssuer   = "https://gateway.example.com/"
+	testAudience = "dashboard-service"
+)
+
+// Returns the JSON Web Key of a public key of the tests
+func testJWK(kid string, key crypto.PublicKey) jsonWebKey {
+	encode := func(n *big.Int, size int) string {
+		return base64.RawURLEncoding.EncodeToString(n.FillBytes(make([]byte, size)))
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return jsonWebKey{Kty: "RSA", Kid: kid, Use: "sig", N: encode(key.N, key.Size()), E: encode(big.NewInt(int64(key.E)), 3)}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		return jsonWebKey{Kty: "EC", Kid: kid, Crv: key.Curve.Params().Name, X: encode(key.X, size), Y: encode(key.Y, size)}
+	}
+	return jsonWebKey{}
+}
+
+// Returns the JWKS of the keys as JSON
+func testJWKS(t *testing.T, keys ...jsonWebKey) []byte {
+	data, err := json.Marshal(map[string][]jsonWebKey{"keys": keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Signs a token with the claims, with the key ID in its header
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key crypto.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// Returns the claims of a valid token of the owner, which expires in an hour
+func validClaims(owner string, roles interface{}) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"sub":   owner,
+		"roles": roles,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+// Test of verifying tokens signed with RSA and EC keys of a JWKS file
+func TestTokenVerifier(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, testJWKS(t, testJWK("ec", &ecKey.PublicKey), testJWK("rsa", &rsaKey.PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewTokenVerifier(keys, testIssuer, testAudience)
+
+	// Changes one claim of a valid token
+	with := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims("alice", []string{RoleEditor})
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  Principal
+		valid bool
+	}{
+		{"EC signed", signToken(t, jwt.SigningMethodES256, "ec", ecKey, validClaims("alice", []string{RoleEditor})),
+			Principal{Owner: "alice", Roles: []string{RoleEditor}}, true},
+		{"RSA signed, roles as string", signToken(t, jwt.SigningMethodRS256, "rsa", rsaKey, validClaims("bob", "viewer admin")),
+			Principal{Owner: "bob", Roles: []string{RoleViewer, RoleAdmin}}, true},
+		{"audience list", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("aud", []string{"other", testAudience})),
+			Principal{Owner: "alice", Roles: []string{RoleEditor}}, true},
+		{"wrong issuer", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("iss", "https://evil.example.com/")), Principal{}, false},
+		{"wrong audience", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("aud", "other")), Principal{}, false},
+		{"expired", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("exp", time.Now().Add(-time.Minute).Unix())), Principal{}, false},
+		{"no expiry", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("exp", nil)), Principal{}, false},
+		{"no owner", signToken(t, jwt.SigningMethodES256, "ec", ecKey, with("sub", nil)), Principal{}, false},
+		{"unknown key", signToken(t, jwt.SigningMethodES256, "unknown", ecKey, validClaims("alice", nil)), Principal{}, false},
+		{"no key ID", signToken(t, jwt.SigningMethodES256, "", ecKey, validClaims("alice", nil)), Principal{}, false},
+		{"other signer", signToken(t, jwt.SigningMethodES256, "ec", otherKey, validClaims("alice", nil)), Principal{}, false},
+		{"HMAC", signToken(t, jwt.SigningMethodHS256, "ec", []byte("secret"), validClaims("alice", nil)), Principal{}, false},
+		{"garbage", "not.a.token", Principal{}, false},
+	}
+	for _, test := range tests {
+		got, err := verifier.Verify(test.token)
+		if test.valid && (err != nil || !reflect.DeepEqual(got, test.want)) {
+			t.Errorf("%s: Verify() = %+v, %v, want %+v", test.name, got, err, test.want)
+		}
+		if !test.valid && err == nil {
+			t.Errorf("%s: Verify() accepted the token as %+v", test.name, got)
+		}
+	}
+}
+
+// Test of loading a JWKS from a URL, and of a token without key ID verified by a JWKS with one key
+func TestLoadJWKSURL(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := testJWKS(t, testJWK("only", &key.PublicKey))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/jwks.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+
+	keys, err := LoadJWKS(server.URL + "/jwks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verifier := NewTokenVerifier(keys, testIssuer, testAudience)
+	if _, err := verifier.Verify(signToken(t, jwt.SigningMethodES256, "", key, validClaims("alice", nil))); err != nil {
+		t.Errorf("Token without key ID was refused: %v", err)
+	}
+
+	if _, err := LoadJWKS(server.URL + "/missing.json"); err == nil {
+		t.Errorf("Expected error loading a missing JWKS URL")
+	}
+	if _, err := LoadJWKS(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("Expected error loading a missing JWKS file")
+	}
+}
+
+// Test of authenticating requests with bearer tokens
+func TestAuthenticateBearer(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, testJWKS(t, testJWK("ec", &key.PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	jwks, err := LoadJWKS(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := NewTokenVerifier(jwks, testIssuer, testAudience)
+
+	// Sends a request with the bearer token, and returns the response and the principal that reached the handler
+	send := func(tokens *TokenVerifier, token string) (*httptest.ResponseRecorder, Principal) {
+		var reached Principal
+		handler := Authorize(store.NewMemoryAPIKeys(), "", tokens, func(w http.ResponseWriter, r *http.Request) {
+			reached, _ = principalOf(r)
+		})
+		req := httptest.NewRequest(http.MethodGet, utils.REGISTRATION_LINE_PATH, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr, reached
+	}
+
+	editor := signToken(t, jwt.SigningMethodES256, "ec", key, validClaims("alice", []string{RoleEditor}))
+	if rr, principal := send(tokens, editor); rr.Code != http.StatusOK || principal.Owner != "alice" {
+		t.Errorf("Valid token returned %d with %+v", rr.Code, principal)
+	}
+
+	// The roles of the token are checked like those of API keys
+	viewer := signToken(t, jwt.SigningMethodES256, "ec", key, validClaims("alice", []string{RoleViewer}))
+	if rr, _ := send(tokens, viewer); rr.Code != http.StatusForbidden {
+		t.Errorf("Token of a viewer returned %d, want %d", rr.Code, http.StatusForbidden)
+	}
+
+	if rr, _ := send(tokens, "not.a.token"); rr.Code != http.StatusUnauthorized || rr.Header().Get("WWW-Authenticate") == "" {
+		t.Errorf("Invalid token returned %d, want %d with WWW-Authenticate", rr.Code, http.StatusUnauthorized)
+	}
+	if rr, _ := send(nil, editor); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Token without verifier returned %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
+
+// Test that tokens signed with known keys are verified while the JWKS is fetched again for an unknown key
+func TestJWKSRefreshWithoutLock(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := testJWKS(t, testJWK("known", &key.PublicKey))
+	refetching := make(chan struct{})
+	release := make(chan struct{})
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests > 1 {
+			close(refetching)
+			<-release
+		}
+		w.Write(jwks)
+	}))
+	defer server.Close()
+	defer close(release)
+
+	keys, err := LoadJWKS(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys.fetchedAt = time.Time{}
+
+	unknown := make(chan error)
+	go func() {
+		_, err := keys.key("rotated")
+		unknown <- err
+	}()
+	<-refetching
+
+	found := make(chan error)
+	go func() {
+		_, err := keys.key("known")
+		found <- err
+	}()
+	select {
+	case err := <-found:
+		if err != nil {
+			t.Errorf("Known key was not found: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Known key waited for the fetch of the JWKS")
+	}
+
+	release <- struct{}{}
+	if err := <-unknown; err == nil {
+		t.Errorf("Expected the rotated key to be unknown after the fetch")
+	}
+}
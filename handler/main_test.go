@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"assignment2/stub"
+	"assignment2/utils"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// Answers requests for the third-party APIs with canned data while the tests run, through the transport the tests
+// record the requests with
+func TestMain(m *testing.M) {
+	restore := stub.Install(utils.COUNTRIES_API, utils.CURRENCY_API, utils.GEOCODING_API, utils.FORECAST_API, utils.AIR_QUALITY_API)
+	upstreams = &testTransport{next: http.DefaultTransport}
+	http.DefaultTransport = upstreams
+	code := m.Run()
+	http.DefaultTransport = upstreams.next
+	restore()
+	os.Exit(code)
+}
@@ -1,62 +1,72 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
 	structs "assignment2/utils"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
-
-	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
+	"time"
 )
 
-// name of collection used for webhooks
-const webhookCollection = "webhooks"
-
-func NotificationHandler() func(w http.ResponseWriter, r *http.Request) {
+func NotificationHandler(webhooks store.WebhookStore, audit store.AuditStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// The trash of webhooks is handled separately
+		if elem, ok := trashPath(r.URL.Path, structs.NOTIFICATION_PATH); ok {
+			webhookTrashHandler(w, r, webhooks, audit, elem)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodPost:
-			postWebhook(w, r)
+			postWebhook(w, r, webhooks, audit)
 		case http.MethodDelete:
-			deleteWebhook(w, r)
+			deleteWebhook(w, r, webhooks, audit)
 		case http.MethodGet:
-			getWebHooks(w, r)
+			getWebHooks(w, r, webhooks)
 		default:
 			http.Error(w, "Method "+r.Method+" not supported for "+structs.NOTIFICATION_PATH, http.StatusMethodNotAllowed)
 		}
 	}
 }
 
-// Function to delete a webhook by its ID
-func deleteWebhook(w http.ResponseWriter, r *http.Request) {
+// Function to move a webhook to the trash by its ID
+func deleteWebhook(w http.ResponseWriter, r *http.Request, webhooks store.WebhookStore, audit store.AuditStore) {
 	// Extract dashboard ID from URL
 	elem := strings.Split(r.URL.Path, "/")
 	webhookID := elem[4]
 
 	if len(webhookID) != 0 {
-		// Get reference to the document
-		doc, err := GetDocumentByID(ctx, webhookCollection, webhookID)
-		if err != nil {
+		// Webhooks of other owners are not found
+		hook, err := webhooks.Get(r.Context(), webhookID)
+		if err == nil && !canAccess(r, hook.Owner) {
+			err = store.ErrNotFound
+		}
+		if err == nil {
+			// Delete the document
+			err = webhooks.Delete(r.Context(), webhookID)
+		}
+		if errors.Is(err, store.ErrNotFound) {
 			// Document not found
 			errorMessage := "Document with ID " + webhookID + " not found"
 			http.Error(w, errorMessage, http.StatusNotFound)
 			return
 		}
-
-		// Delete the document
-		_, err = doc.Ref.Delete(ctx)
 		if err != nil {
 			log.Println("Error deleting document:", err)
 			http.Error(w, "Error deleting document", http.StatusInternalServerError)
 			return
 		}
+		recordChange(r, audit, store.AuditDelete, store.AuditWebhook, webhookID, hook, nil)
 
 		// Return success message
 		w.WriteHeader(http.StatusNoContent)
@@ -69,7 +79,7 @@ func deleteWebhook(w http.ResponseWriter, r *http.Request) {
 }
 
 // POST requests being handled with this function
-func postWebhook(w http.ResponseWriter, r *http.Request) {
+func postWebhook(w http.ResponseWriter, r *http.Request, webhooks store.WebhookStore, audit store.AuditStore) {
 
 	decoder := json.NewDecoder(r.Body)
 
@@ -89,7 +99,7 @@ func postWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	//if event is not REGISTER, CHANGE, INVOKE or DELETE, returns error
+	//if event is not REGISTER, CHANGE, INVOKE, DELETE or PURGE, returns error
 	if !utils.ValidateEvent(hook.Event) {
 		http.Error(w, "Event is not added in correctly", http.StatusBadRequest)
 		return
@@ -134,60 +144,47 @@ func postWebhook(w http.ResponseWriter, r *http.Request) {
 
 	//If it is a regular url, it does a HEAD request,
 	//and if it returns status code other than 200, error is returned
-	check, _ := http.Head(hook.Url)
-	if check.StatusCode != http.StatusOK {
-		http.Error(w, "Url provided is not valid", http.StatusBadRequest)
+	if err := checkWebhookURL(r.Context(), hook.Url); err != nil {
+		http.Error(w, "Url provided is not valid: "+err.Error(), http.StatusBadRequest)
 		return
-
-	}
-	//Checks if country is valid
-	a, _ := http.Get(structs.COUNTRIES_API_ISOCODE + hook.Country)
-	// If country is not valid, country is set to blank (works also if country is not written in)
-	if a.StatusCode != http.StatusOK {
-		hook.Country = ""
 	}
 
-	a.Body.Close()
-
-	//Unique id for webhook
-	var uniqueID string
-
-	for {
-		uniqueID = utils.GenerateUID(5)
-
-		// Check if the generated ID already exists in a document
-		iter := client.Collection(collection).Where("id", "==", uniqueID).Limit(1).Documents(ctx)
-
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			// No document found with current ID, continue with further processing
-			break
-		}
-		if err != nil {
-			log.Println("Error retrieving document:", err)
-			break
-		}
-		if doc != nil {
-			// ID already exists, generating a new one
-			log.Println("ID already exists...generating new one")
-			continue
+	//Checks if country is valid. Without a country, the webhook applies to every country
+	if !utils.IsEmptyField(hook.Country) {
+		if err := checkWebhookCountry(r.Context(), hook.Country); err != nil {
+			if errors.Is(err, upstream.ErrNotFound) {
+				http.Error(w, "Country '"+hook.Country+"' not found", http.StatusBadRequest)
+				return
+			}
+			log.Println("Error checking country of webhook:", err)
+			status := http.StatusBadGateway
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			http.Error(w, "Failed to check country '"+hook.Country+"'", status)
+			return
 		}
 	}
 
 	//Capitalizes isocode
 	isocode := strings.ToUpper(hook.Country)
 
-	//Adds document to webhook collection in firestore with data
-	_, _, err1 := client.Collection(webhookCollection).Add(ctx,
-		map[string]interface{}{
-			"id":      uniqueID,
-			"url":     hook.Url,
-			"country": isocode,
-			"event":   hook.Event,
-		})
+	//Adds webhook to the store with data, which gives it a unique id
+	created := utils.WebhookGetResponse{
+		Url:     hook.Url,
+		Country: isocode,
+		Event:   hook.Event,
+		Owner:   ownerOf(r),
+	}
+	uniqueID, err1 := webhooks.Create(r.Context(), created)
 	if err1 != nil {
+		log.Println("Error adding webhook:", err1)
+		http.Error(w, "Failed to add webhook", http.StatusInternalServerError)
 		return
 	} else {
+		created.Id = uniqueID
+		recordChange(r, audit, store.AuditCreate, store.AuditWebhook, uniqueID, nil, created)
+
 		//Response to user with id that is given to webhook
 		response := struct {
 			ID string `json:"id"`
@@ -203,16 +200,46 @@ func postWebhook(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// Function to retrieve document data and write JSON response
-func retrieveWebHookData(w http.ResponseWriter, doc *firestore.DocumentSnapshot) {
-	// Map document data to WebhookGetResponse struct
-	var document utils.WebhookGetResponse
-	if err := doc.DataTo(&document); err != nil {
-		log.Println("Error retrieving document data:", err)
-		http.Error(w, "Error retrieving document data", http.StatusInternalServerError)
-		return
+// Time the url of a webhook is given to answer the HEAD request it is checked with
+var webhookCheckTimeout = 5 * time.Second
+
+// Checks that the url of a webhook answers a HEAD request with 200 OK
+func checkWebhookURL(ctx context.Context, hookURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, webhookCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, hookURL, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
 	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("HEAD " + hookURL + " returned " + res.Status)
+	}
+	return nil
+}
+
+// Checks that the country API knows the ISO code, within the time the countries source is given
+func checkWebhookCountry(ctx context.Context, isoCode string) error {
+	ctx, cancel := context.WithTimeout(ctx, sourceTimeouts[utils.CountriesSource])
+	defer cancel()
 
+	var found []utils.CountryInfo
+	if err := upstream.GetJSON(ctx, utils.COUNTRIES_API_ISOCODE+url.PathEscape(isoCode), &found); err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return &upstream.Error{URL: utils.COUNTRIES_API_ISOCODE + isoCode, StatusCode: http.StatusOK, Kind: upstream.ErrNotFound}
+	}
+	return nil
+}
+
+// Function to write a webhook as JSON response
+func retrieveWebHookData(w http.ResponseWriter, document utils.WebhookGetResponse) {
 	// Marshal the document to JSON
 	jsonData, err := json.Marshal(document)
 	if err != nil {
@@ -232,26 +259,25 @@ func retrieveWebHookData(w http.ResponseWriter, doc *firestore.DocumentSnapshot)
 	}
 }
 
-// Gets one webhook based on its Firestore ID. If no ID is provided it gets all webhooks
-func getWebHooks(w http.ResponseWriter, r *http.Request) {
-	if client == nil {
-		http.Error(w, "Error retrieving document", http.StatusInternalServerError)
-		return
-	}
+// Gets one webhook based on its ID. If no ID is provided it gets a page of all webhooks
+func getWebHooks(w http.ResponseWriter, r *http.Request, webhooks store.WebhookStore) {
 
 	// Extract webhook ID from URL
 	elem := strings.Split(r.URL.Path, "/")
-	webhookID := elem[4]
 
-	if len(webhookID) != 0 {
-		// Query documents where the 'id' field matches the provided webhookID
-		query := client.Collection(webhookCollection).Where("id", "==", webhookID).Limit(1)
-		iter := query.Documents(ctx)
+	var webhookID string
+	if len(elem) >= 5 {
+		webhookID = elem[4]
+	}
 
-		// Retrieve reference to document
-		doc, err := iter.Next()
+	if len(webhookID) != 0 {
+		hook, err := webhooks.Get(r.Context(), webhookID)
+		// Webhooks of other owners are not found
+		if err == nil && !canAccess(r, hook.Owner) {
+			err = store.ErrNotFound
+		}
 		if err != nil {
-			if err == iterator.Done {
+			if errors.Is(err, store.ErrNotFound) {
 				// Document not found
 				errorMessage := "Document with ID " + webhookID + " not found"
 				http.Error(w, errorMessage, http.StatusNotFound)
@@ -263,76 +289,87 @@ func getWebHooks(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Retrieves document and writes JSON response
-		retrieveWebHookData(w, doc)
+		// Writes JSON response
+		retrieveWebHookData(w, hook)
 	} else {
-		// Collective retrieval of documents
-		iter := client.Collection(webhookCollection).Documents(ctx)
-
-		for {
-			doc, err := iter.Next()
-			if errors.Is(err, iterator.Done) {
-				break
-			}
-			if err != nil {
-				log.Printf("Failed to iterate: %v", err)
-				return
-			}
+		// Collective retrieval of documents, one page at a time
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-			// Retrieves document and writes JSON response
-			retrieveWebHookData(w, doc)
+		hooks, err := webhooks.List(r.Context(), scope(r), opts)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor '"+opts.Cursor+"'. Use the nextCursor of the previous page", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+			return
 		}
+
+		// Writes JSON response
+		writePage(w, hooks)
 	}
 }
 
 /*
-Handles the invocation of events
+Handles the invocation of events on a configuration of the owner
 */
-func invocationHandler(w http.ResponseWriter, event string, isocode string) bool {
-	if !checkWebhook(isocode) {
+func invocationHandler(ctx context.Context, w http.ResponseWriter, webhooks store.WebhookStore, event string, isocode string,
+	owner string) bool {
+	err := invokeWebhooks(ctx, webhooks, event, isocode, owner, func(hook utils.WebhookInvokeMessage) {
+		callUrl(w, hook)
+	})
+	if err != nil {
+		log.Println("Error retrieving webhooks: ", err)
+		http.Error(w, "Error retrieving webhooks ", http.StatusInternalServerError)
 		return false
 	}
+	return true
+}
 
-	if event == "REGISTER" || event == "CHANGE" || event == "DELETE" || event == "INVOKE" {
-
-		// retrieve the webhooks which will be triggered by the conditions
-		query := client.Collection(webhookCollection).
-			Where("event", "==", event).
-			Where("country", "in", []string{isocode, ""})
-
-		iter := query.Documents(ctx)
-		for {
-			doc, err := iter.Next()
-			if err == iterator.Done {
-				break // finished iterating
-			}
-			if err != nil {
-				log.Println("Error iterating over webhook documents: ", err)
-				http.Error(w, "Error iterating over webhook documents ", http.StatusInternalServerError)
-				return false
-			}
+// Calls every webhook of the owner triggered by the event on the country in the background, with call.
+// Configurations without owner trigger the webhooks of any owner
+func invokeWebhooks(ctx context.Context, webhooks store.WebhookStore, event string, isocode string, owner string,
+	call func(utils.WebhookInvokeMessage)) error {
+	if !utils.ValidateEvent(event) {
+		return nil
+	}
 
-			log.Println(event + " event triggered...")
+	// retrieve the webhooks which will be triggered by the conditions
+	hooks, err := webhooks.Matching(ctx, event, isocode, owner)
+	if err != nil {
+		return err
+	}
 
-			//  Message body when invoking url
-			var hook utils.WebhookInvokeMessage
-			if err := doc.DataTo(&hook); err != nil {
-				log.Println("Error retrieving document data: ", err)
-				http.Error(w, "Error retrieving document data ", http.StatusInternalServerError)
-				return false
-			}
+	for _, hook := range hooks {
+		log.Println(event + " event triggered...")
 
-			// Call the url
-			go callUrl(w, hook)
-		}
+		// Call the url, with the webhook as message body
+		go call(utils.WebhookInvokeMessage{
+			Id:      hook.Id,
+			Url:     hook.Url,
+			Country: hook.Country,
+			Event:   hook.Event,
+		})
 	}
-	return false
+	return nil
 }
 
 /*
 Calls given URL with given content and awaits response (status and body)
 */
 func callUrl(w http.ResponseWriter, hook utils.WebhookInvokeMessage) {
+	if err := invokeUrl(hook); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// Calls the URL of the webhook with the message, and logs the response. Returns an error if no request could be made
+func invokeUrl(hook utils.WebhookInvokeMessage) error {
 	url := hook.Url
 	event := hook.Event
 	country := hook.Country
@@ -348,15 +385,13 @@ func callUrl(w http.ResponseWriter, hook utils.WebhookInvokeMessage) {
 	payload, err := json.Marshal(hook)
 	if err != nil {
 		log.Println("Error marshaling JSON: ", err)
-		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
-		return
+		return errors.New("Error marshaling JSON")
 	}
 
 	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
 	if err != nil {
 		log.Println("Error creating HTTP request: ", err)
-		http.Error(w, "Error creating HTTP request", http.StatusInternalServerError)
-		return
+		return errors.New("Error creating HTTP request")
 	}
 
 	// Set content-Type header
@@ -367,7 +402,7 @@ func callUrl(w http.ResponseWriter, hook utils.WebhookInvokeMessage) {
 	res, err := client.Do(req)
 	if err != nil {
 		log.Println("Error in HTTP request. Error: ", err)
-		return
+		return nil
 	}
 	defer res.Body.Close()
 
@@ -375,35 +410,10 @@ func callUrl(w http.ResponseWriter, hook utils.WebhookInvokeMessage) {
 	response, err := io.ReadAll(res.Body)
 	if err != nil {
 		log.Println("Error reading invocation response: ", err)
-		return
+		return nil
 	}
 
 	log.Println("Webhook " + url + " invoked. Received status code " +
 		strconv.Itoa(res.StatusCode) + " and body: " + string(response))
-}
-
-/*
-Checks if the event meets the conditions to trigger @ the individual handles and their methods
-*/
-func checkWebhook(isocode string) bool {
-
-	webhookCollection := "webhooks"
-
-	query := client.Collection(webhookCollection).Where("country", "in", []string{isocode, ""})
-	iter := query.Documents(ctx)
-
-	// Retrieve reference to document
-	_, err := iter.Next()
-	if err != nil {
-		if err == iterator.Done {
-			// Document not found
-			return false
-		}
-		// If trouble retrieving document
-		log.Println("Error retrieving document:", err)
-		return false
-	} else {
-		// iterate through webhooks and trigger based on country and event conditions
-		return true
-	}
+	return nil
 }
@@ -1,8 +1,13 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -29,7 +34,7 @@ func TestValidEvent(t *testing.T) {
 func TestNotificationHandler(t *testing.T) {
 
 	// Initialize handler instance
-	handler := NotificationHandler()
+	handler := NotificationHandler(store.NewMemoryWebhooks(), store.NewMemoryAuditLog())
 
 	// set up structure to be used for testing and close when finished testing
 	server := httptest.NewServer(http.HandlerFunc(handler))
@@ -46,7 +51,7 @@ func TestNotificationHandler(t *testing.T) {
 
 	// Create a ResponseRecorder to record the response.
 	rr := httptest.NewRecorder()
-	handler2 := http.HandlerFunc(StatusHandler())
+	handler2 := http.HandlerFunc(StatusHandler(store.NewMemoryWebhooks()))
 
 	handler2.ServeHTTP(rr, req)
 
@@ -75,7 +80,7 @@ func TestNotificationHandler_POST(t *testing.T) {
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(NotificationHandler())
+	handler := http.HandlerFunc(NotificationHandler(store.NewMemoryWebhooks(), store.NewMemoryAuditLog()))
 
 	// Call ServeHTTP directly and pass in our Request and ResponseRecorder
 	handler.ServeHTTP(rr, req)
@@ -104,7 +109,7 @@ func TestNotificationHandler_UnsupportedMethod(t *testing.T) {
 
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
-	handler := http.HandlerFunc(NotificationHandler())
+	handler := http.HandlerFunc(NotificationHandler(store.NewMemoryWebhooks(), store.NewMemoryAuditLog()))
 
 	// Call ServeHTTP directly and pass in our Request and ResponseRecorder
 	handler.ServeHTTP(rr, req)
@@ -125,35 +130,74 @@ func TestNotificationHandler_UnsupportedMethod(t *testing.T) {
 
 // Test function for GetWebHooks function
 func TestGetWebHooks(t *testing.T) {
-	// Create a mock http.ResponseWriter
-	w := httptest.NewRecorder()
+	// Create a store with one registered webhook
+	webhooks := store.NewMemoryWebhooks()
+	id, err := webhooks.Create(context.Background(), utils.WebhookGetResponse{
+		Url:     "http://test.com",
+		Country: "NO",
+		Event:   "INVOKE",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Call getWebHooks with a nil Firestore client
-	client = nil
-	getWebHooks(w, nil)
+	// Get the registered webhook
+	req, err := http.NewRequest("GET", utils.NOTIFICATION_PATH+id, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	getWebHooks(rr, req, webhooks)
 
-	// Check the response status code
-	if status := w.Code; status != http.StatusInternalServerError {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	// Check the response status code and body
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"id":"`+id+`"`) {
+		t.Errorf("handler returned wrong body: got %v", rr.Body.String())
 	}
 
-	// Check the response body
-	expected := "Error retrieving document"
-	if strings.TrimSpace(w.Body.String()) != strings.TrimSpace(expected) {
-		t.Errorf("handler returned wrong body: got %v want %v", strings.TrimSpace(w.Body.String()), strings.TrimSpace(expected))
+	// Get a webhook that does not exist
+	req, err = http.NewRequest("GET", utils.NOTIFICATION_PATH+"unknown", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	getWebHooks(rr, req, webhooks)
+
+	// The expected status code is 404 Not Found
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
 
-	req, err := http.NewRequest("GET", "/webhook/", nil)
+	// List all webhooks
+	req, err = http.NewRequest("GET", utils.NOTIFICATION_PATH, nil)
 	if err != nil {
 		t.Fatalf("http.NewRequest() returned error: %v", err)
 	}
+	rr = httptest.NewRecorder()
+	getWebHooks(rr, req, webhooks)
 
-	rr := httptest.NewRecorder()
-	getWebHooks(rr, req)
+	// The listing is one JSON object with the webhook
+	var page store.Page[utils.WebhookGetResponse]
+	if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+		t.Fatalf("handler returned invalid JSON %v: %v", rr.Body.String(), err)
+	}
+	if page.Total != 1 || len(page.Items) != 1 || page.Items[0].Id != id || page.NextCursor != "" {
+		t.Errorf("handler returned wrong listing: got %v", rr.Body.String())
+	}
+
+	// List with a cursor the listing did not return
+	req, err = http.NewRequest("GET", utils.NOTIFICATION_PATH+"?cursor=garbage", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	getWebHooks(rr, req, webhooks)
 
 	// The expected status code is 400 Bad Request
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 }
 
@@ -264,7 +308,9 @@ func TestPostWebhook(t *testing.T) {
 			}
 
 			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc(postWebhook)
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				postWebhook(w, r, store.NewMemoryWebhooks(), store.NewMemoryAuditLog())
+			})
 			handler.ServeHTTP(rr, req)
 
 			if status := rr.Code; status != test.wantStatus {
@@ -274,13 +320,68 @@ func TestPostWebhook(t *testing.T) {
 	}
 
 	// Check that the generated ID is of the correct length
-	uniqueID := utils.GenerateUID(5)
-
+	uniqueID, err := utils.RandomIDs(5).NewID(store.WebhookCollection)
+	if err != nil {
+		t.Fatal(err)
+	}
 	if len(uniqueID) != 5 {
 		t.Errorf("Expected length to be 5, got %v", len(uniqueID))
 	}
 }
 
+// Test that the url and the country of a webhook are checked when it is registered, and that a country API that
+// fails is a bad gateway
+func TestPostWebhookChecks(t *testing.T) {
+	// Requests are not retried, and breakers opened by the test do not affect the other tests
+	client := upstream.DefaultClient
+	upstream.DefaultClient = upstream.NewClient()
+	upstream.DefaultClient.Retries = 0
+	defer func() { upstream.DefaultClient = client }()
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/hook" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer receiver.Close()
+
+	// Registers the webhook, and returns the status
+	post := func(url string, country string) int {
+		body := `{"url": "` + url + `", "country": "` + country + `", "event": "INVOKE"}`
+		rr := httptest.NewRecorder()
+		postWebhook(rr, httptest.NewRequest(http.MethodPost, utils.NOTIFICATION_PATH, strings.NewReader(body)),
+			store.NewMemoryWebhooks(), store.NewMemoryAuditLog())
+		return rr.Code
+	}
+
+	tests := []struct {
+		url     string
+		country string
+		status  int
+	}{
+		{receiver.URL + "/hook", "NO", http.StatusOK},
+		{receiver.URL + "/hook", "", http.StatusOK},
+		{receiver.URL + "/hook", "XX", http.StatusBadRequest},
+		{receiver.URL + "/missing", "NO", http.StatusBadRequest},
+		{"http://127.0.0.1:0/hook", "NO", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if status := post(test.url, test.country); status != test.status {
+			t.Errorf("Webhook to %s for '%s' returned %d, want %d", test.url, test.country, status, test.status)
+		}
+	}
+
+	recordUpstreams(t, func(source string, req *http.Request) error {
+		if source == utils.CountriesSource {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if status := post(receiver.URL+"/hook", "NO"); status != http.StatusBadGateway {
+		t.Errorf("Webhook with a failing country API returned %d, want %d", status, http.StatusBadGateway)
+	}
+}
+
 // Test function for DeleteWebhook function
 func TestDeleteWebhook(t *testing.T) {
 	// Test without webhook ID in the URL
@@ -290,7 +391,7 @@ func TestDeleteWebhook(t *testing.T) {
 	}
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
-	deleteWebhook(rr, req)
+	deleteWebhook(rr, req, store.NewMemoryWebhooks(), store.NewMemoryAuditLog())
 
 	// Test the expected error output
 	if status := rr.Code; status != http.StatusBadRequest {
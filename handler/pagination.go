@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"assignment2/store"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Reads the 'limit' and 'cursor' query parameters of a listing
+func listOptions(r *http.Request) (store.ListOptions, error) {
+	query := r.URL.Query()
+	opts := store.ListOptions{Cursor: query.Get("cursor")}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return opts, errors.New("Invalid limit '" + limit + "'. Expected a positive number, at most " +
+				strconv.Itoa(store.MaxPageSize))
+		}
+		opts.Limit = n
+	}
+	return opts, nil
+}
+
+// Writes a page of a listing as JSON response
+func writePage[T any](w http.ResponseWriter, page store.Page[T]) {
+	// Marshal before writing, so an error can still be sent with its status code
+	jsonData, err := json.Marshal(page)
+	if err != nil {
+		log.Println("Error marshaling JSON:", err)
+		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(jsonData); err != nil {
+		log.Println("Error writing JSON response:", err)
+	}
+}
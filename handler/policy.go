@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"net/http"
+	"strings"
+)
+
+// Roles of a caller. Every role is also granted what the roles before it are
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Roles in order of what they are granted, where a later role is granted everything an earlier one is
+var roleOrder = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+// Returns the rank of the role in roleOrder, or -1 if it is unknown
+func roleRank(role string) int {
+	for i, known := range roleOrder {
+		if role == known {
+			return i
+		}
+	}
+	return -1
+}
+
+// Reports whether the principal has the role, or a role that is granted more
+func (p Principal) HasRole(role string) bool {
+	needed := roleRank(role)
+	for _, granted := range p.Roles {
+		if needed >= 0 && roleRank(granted) >= needed {
+			return true
+		}
+	}
+	return false
+}
+
+// Role needed for a route, with the methods it covers. No methods covers all methods
+type rule struct {
+	path    string
+	methods []string
+	role    string
+}
+
+// Role that lets anyone through, without an API key
+const public = ""
+
+/*
+Policy of the routes registered in main.go, where the rule with the longest matching path applies.
+A path without a trailing slash only matches itself and the paths below it.
+Requests with a method no rule covers are denied
+*/
+var policy = []rule{
+	{path: utils.DEFAULT_PATH, methods: []string{http.MethodGet}, role: public},
+	{path: utils.DASHBOARD_PATH, methods: []string{http.MethodGet}, role: RoleViewer},
+	{path: utils.REGISTRATION_PATH, role: RoleEditor},
+	{path: utils.NOTIFICATION_PATH, role: RoleAdmin},
+	{path: utils.STATUS_PATH, methods: []string{http.MethodGet}, role: RoleAdmin},
+	{path: utils.API_KEY_PATH, role: RoleAdmin},
+	{path: utils.AUDIT_PATH, methods: []string{http.MethodGet}, role: RoleAdmin},
+}
+
+// Reports whether the path is the rule path, or below it when the rule path ends in a path segment
+func matchesPath(path string, rulePath string) bool {
+	if strings.HasSuffix(rulePath, "/") {
+		return strings.HasPrefix(path, rulePath)
+	}
+	return path == rulePath || strings.HasPrefix(path, rulePath+"/")
+}
+
+// Returns the role needed for the method on the path. False if the policy does not allow the method
+func requiredRole(method string, path string) (string, bool) {
+	var match *rule
+	for i := range policy {
+		if matchesPath(path, policy[i].path) && (match == nil || len(policy[i].path) > len(match.path)) {
+			match = &policy[i]
+		}
+	}
+	if match == nil {
+		return "", false
+	}
+
+	if len(match.methods) == 0 {
+		return match.role, true
+	}
+	for _, allowed := range match.methods {
+		if method == allowed {
+			return match.role, true
+		}
+	}
+	return "", false
+}
+
+/*
+Authorize checks the method and route of the request against the policy. Public routes are passed on to next,
+other routes need an API key or bearer token with the role of the route. Denied requests are answered with 403 Forbidden
+*/
+func Authorize(keys store.APIKeyStore, adminKey string, tokens *TokenVerifier, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, ok := requiredRole(r.Method, r.URL.Path)
+		if !ok {
+			http.Error(w, "Method "+r.Method+" is not allowed on "+r.URL.Path, http.StatusForbidden)
+			return
+		}
+		if role == public {
+			next(w, r)
+			return
+		}
+
+		Authenticate(keys, adminKey, tokens, func(w http.ResponseWriter, r *http.Request) {
+			principal, _ := principalOf(r)
+			if !principal.HasRole(role) {
+				http.Error(w, "Role '"+role+"' is needed for "+r.Method+" "+r.URL.Path, http.StatusForbidden)
+				return
+			}
+			next(w, r)
+		})(w, r)
+	}
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Test for Authorize, with the role of every route
+func TestAuthorize(t *testing.T) {
+	ctx := context.Background()
+	keys := store.NewMemoryAPIKeys()
+
+	// Keys of every role
+	issued := make(map[string]string)
+	for _, role := range roleOrder {
+		id, err := keys.Create(ctx, utils.APIKey{Owner: role, Roles: []string{role}, Hash: hashSecret("secret")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		issued[role] = id + ".secret"
+	}
+
+	// The handler answers 200 to everything that gets through
+	handler := Authorize(keys, "", nil, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		method string
+		path   string
+		role   string
+		code   int
+	}{
+		{http.MethodGet, utils.DEFAULT_PATH, "", http.StatusOK},
+		{http.MethodPost, utils.DEFAULT_PATH, RoleAdmin, http.StatusForbidden},
+		{http.MethodGet, utils.DASHBOARD_PATH + "abcde", "", http.StatusUnauthorized},
+		{http.MethodGet, utils.DASHBOARD_PATH + "abcde", RoleViewer, http.StatusOK},
+		{http.MethodGet, utils.DASHBOARD_PATH + "abcde", RoleEditor, http.StatusOK},
+		{http.MethodDelete, utils.DASHBOARD_PATH + "abcde", RoleAdmin, http.StatusForbidden},
+		{http.MethodGet, utils.REGISTRATION_LINE_PATH, RoleViewer, http.StatusForbidden},
+		{http.MethodPost, utils.REGISTRATION_PATH, RoleEditor, http.StatusOK},
+		{http.MethodPost, utils.REGISTRATION_PATH + "X", RoleEditor, http.StatusForbidden},
+		{http.MethodDelete, utils.REGISTRATION_LINE_PATH + "abcde", RoleEditor, http.StatusOK},
+		{http.MethodGet, utils.REGISTRATION_LINE_PATH + "abcde", RoleAdmin, http.StatusOK},
+		{http.MethodPost, utils.NOTIFICATION_PATH, RoleEditor, http.StatusForbidden},
+		{http.MethodGet, utils.NOTIFICATION_PATH, RoleAdmin, http.StatusOK},
+		{http.MethodGet, utils.STATUS_PATH, RoleEditor, http.StatusForbidden},
+		{http.MethodGet, utils.STATUS_PATH, RoleAdmin, http.StatusOK},
+		{http.MethodPost, utils.API_KEY_PATH, RoleEditor, http.StatusForbidden},
+		{http.MethodPost, utils.API_KEY_PATH, RoleAdmin, http.StatusOK},
+		{http.MethodGet, utils.AUDIT_PATH, RoleEditor, http.StatusForbidden},
+		{http.MethodGet, utils.AUDIT_PATH, RoleAdmin, http.StatusOK},
+		{http.MethodDelete, utils.AUDIT_PATH, RoleAdmin, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if test.role != "" {
+			req.Header.Set(APIKeyHeader, issued[test.role])
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+
+		if rr.Code != test.code {
+			t.Errorf("%s %s as '%s' returned %d, want %d", test.method, test.path, test.role, rr.Code, test.code)
+		}
+	}
+}
+
+// Test for HasRole
+func TestHasRole(t *testing.T) {
+	editor := Principal{Roles: []string{RoleEditor}}
+	if !editor.HasRole(RoleViewer) || !editor.HasRole(RoleEditor) || editor.HasRole(RoleAdmin) || editor.HasRole("owner") {
+		t.Errorf("Unexpected roles of an editor")
+	}
+	if (Principal{Roles: []string{"owner"}}).HasRole(RoleViewer) {
+		t.Errorf("Unknown roles should not grant any role")
+	}
+}
@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"assignment2/utils"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Buckets kept before full buckets are pruned, as they are the same as new ones
+const maxBuckets = 10000
+
+// RateLimitConfig sets the limits of every client. A zero rate or quota is no limit
+type RateLimitConfig struct {
+	// Requests per second, and the number of requests that can be made at once
+	Rate  float64
+	Burst int
+
+	// Dashboards retrieved, and configurations registered, per day (UTC)
+	RenderQuota       int
+	RegistrationQuota int
+
+	// Requests per second, and at once, of every IP address before it is authenticated, which limits the guessing
+	// of API keys and tokens
+	AddressRate  float64
+	AddressBurst int
+}
+
+// Requests counted against a daily quota
+type quota string
+
+const (
+	renderQuota       quota = "render"
+	registrationQuota quota = "registration"
+)
+
+// Token bucket of a client, refilled at the rate up to the burst
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// RateLimiter limits the requests of every client, identified by its API key, token owner or IP address
+type RateLimiter struct {
+	config RateLimitConfig
+	now    func() time.Time
+
+	// Limits every IP address before it is authenticated, nil if it is not limited
+	addresses *RateLimiter
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	day     string
+	used    map[quota]map[string]int
+}
+
+// NewRateLimiter gives a limiter with the limits of the config. With a rate, at least one request can be made at once
+func NewRateLimiter(config RateLimitConfig) *RateLimiter {
+	if config.Rate > 0 && config.Burst < 1 {
+		config.Burst = 1
+	}
+	limiter := &RateLimiter{
+		config:  config,
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+		used:    make(map[quota]map[string]int),
+	}
+	if config.AddressRate > 0 {
+		limiter.addresses = NewRateLimiter(RateLimitConfig{Rate: config.AddressRate, Burst: config.AddressBurst})
+	}
+	return limiter
+}
+
+// Identifies the client of the request, by its API key or token owner if it is authenticated
+func clientOf(r *http.Request) string {
+	if principal, ok := principalOf(r); ok {
+		if principal.KeyID != "" {
+			return "key:" + principal.KeyID
+		}
+		return "owner:" + principal.Owner
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// Returns the daily quota the request is counted against, if any
+func quotaOf(r *http.Request) (quota, bool) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, utils.DASHBOARD_PATH) && len(r.URL.Path) > len(utils.DASHBOARD_PATH):
+		return renderQuota, true
+	case r.Method == http.MethodPost && strings.TrimSuffix(r.URL.Path, "/") == utils.REGISTRATION_PATH:
+		return registrationQuota, true
+	}
+	return "", false
+}
+
+// Returns the limit of the quota, zero if there is none
+func (l *RateLimiter) quotaLimit(q quota) int {
+	if q == renderQuota {
+		return l.config.RenderQuota
+	}
+	return l.config.RegistrationQuota
+}
+
+// Takes a token of the bucket of the client. Returns the tokens remaining, the time until the bucket is full,
+// and if no token was left, the time until the next one
+func (l *RateLimiter) take(client string, now time.Time) (remaining int, full time.Duration, wait time.Duration) {
+	burst := float64(l.config.Burst)
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.prune(now)
+		}
+		b = &bucket{tokens: burst, updated: now}
+		l.buckets[client] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*l.config.Rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / l.config.Rate * float64(time.Second))
+	} else {
+		b.tokens--
+	}
+	full = time.Duration((burst - b.tokens) / l.config.Rate * float64(time.Second))
+	return int(b.tokens), full, wait
+}
+
+// Removes the buckets that are full by now
+func (l *RateLimiter) prune(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*l.config.Rate >= float64(l.config.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// Counts a request of the client against the daily quota. Returns the requests remaining today, or false
+// if the quota is used up
+func (l *RateLimiter) count(q quota, client string, now time.Time) (int, bool) {
+	if day := now.UTC().Format("2006-01-02"); day != l.day {
+		l.day = day
+		l.used = make(map[quota]map[string]int)
+	}
+	if l.used[q] == nil {
+		l.used[q] = make(map[string]int)
+	}
+
+	limit := l.quotaLimit(q)
+	if l.used[q][client] >= limit {
+		return 0, false
+	}
+	l.used[q][client]++
+	return limit - l.used[q][client], true
+}
+
+// Gives back a request of the client counted against the quota of the day, unless the quotas have started over since.
+// Returns the requests remaining
+func (l *RateLimiter) refund(q quota, client string, day string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.day == day && l.used[q][client] > 0 {
+		l.used[q][client]--
+	}
+	return l.quotaLimit(q) - l.used[q][client]
+}
+
+// Response writer of a registration, which calls failed once if the registration is not created
+type registrationWriter struct {
+	http.ResponseWriter
+	failed  func()
+	written bool
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *registrationWriter) WriteHeader(statusCode int) {
+	if !w.written {
+		w.written = true
+		if statusCode < 200 || statusCode > 299 {
+			w.failed()
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Write implements http.ResponseWriter
+func (w *registrationWriter) Write(data []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Returns the number of whole seconds of the duration, rounded up
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// Writes the RateLimit headers of a limit
+func setRateLimit(w http.ResponseWriter, limit int, remaining int, reset time.Duration) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	w.Header().Set("RateLimit-Reset", seconds(reset))
+}
+
+// Answers a request over a limit, which can be retried after the duration
+func tooManyRequests(w http.ResponseWriter, message string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", seconds(retryAfter))
+	http.Error(w, message, http.StatusTooManyRequests)
+}
+
+/*
+Limit only lets the requests of a client within its rate limit and daily quotas through to next. The RateLimit headers
+tell the client what is left of its rate limit, or of its daily quota for dashboards and registrations if less is left
+of it. Requests over a limit are answered with 429 Too Many Requests, and a Retry-After header with the seconds until
+they can be retried. A registration only counts against the quota if it is created
+*/
+func (l *RateLimiter) Limit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := clientOf(r)
+		now := l.now()
+
+		// Remaining requests of the limit in the headers, the closest limit is shown
+		shown := math.MaxInt
+
+		l.mu.Lock()
+		if l.config.Rate > 0 {
+			remaining, full, wait := l.take(client, now)
+			setRateLimit(w, l.config.Burst, remaining, full)
+			shown = remaining
+			if wait > 0 {
+				l.mu.Unlock()
+				tooManyRequests(w, "Rate limit of "+strconv.Itoa(l.config.Burst)+" requests exceeded", wait)
+				return
+			}
+		}
+
+		if q, ok := quotaOf(r); ok && l.quotaLimit(q) > 0 {
+			tomorrow := now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+			remaining, ok := l.count(q, client, now)
+			quotaShown := remaining < shown
+			if quotaShown {
+				setRateLimit(w, l.quotaLimit(q), remaining, tomorrow.Sub(now))
+			}
+			if !ok {
+				l.mu.Unlock()
+				tooManyRequests(w, "Daily "+string(q)+" quota of "+strconv.Itoa(l.quotaLimit(q))+" exceeded", tomorrow.Sub(now))
+				return
+			}
+
+			if q == registrationQuota {
+				day := l.day
+				w = &registrationWriter{ResponseWriter: w, failed: func() {
+					remaining := l.refund(q, client, day)
+					if quotaShown {
+						w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+					}
+				}}
+			}
+		}
+		l.mu.Unlock()
+
+		next(w, r)
+	}
+}
+
+// LimitAddress only lets the requests of an IP address within the address rate limit through to next. It is meant to
+// come before authentication, so requests with unknown keys or tokens are limited as well. Without an address rate,
+// next is returned as it is
+func (l *RateLimiter) LimitAddress(next http.HandlerFunc) http.HandlerFunc {
+	if l.addresses == nil {
+		return next
+	}
+	return l.addresses.Limit(next)
+}
@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"assignment2/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test of the token bucket of RateLimiter
+func TestRateLimit(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 2})
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Sends a request from the address, and returns the response
+	send := func(addr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, utils.REGISTRATION_LINE_PATH, nil)
+		req.RemoteAddr = addr
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// The burst can be used at once
+	for i, remaining := range []string{"1", "0"} {
+		rr := send("10.0.0.1:1234")
+		if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Limit") != "2" || rr.Header().Get("RateLimit-Remaining") != remaining {
+			t.Errorf("Request %d returned %d with headers %v", i, rr.Code, rr.Header())
+		}
+	}
+
+	rr := send("10.0.0.1:5678")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "1" || rr.Header().Get("RateLimit-Reset") != "2" {
+		t.Errorf("Request over the limit returned %d with headers %v", rr.Code, rr.Header())
+	}
+
+	// Other clients have their own bucket
+	if rr := send("10.0.0.2:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Request of another client returned %d", rr.Code)
+	}
+
+	// The bucket is refilled at the rate
+	now = now.Add(time.Second)
+	if rr := send("10.0.0.1:1234"); rr.Code != http.StatusOK {
+		t.Errorf("Request after refill returned %d", rr.Code)
+	}
+	if rr := send("10.0.0.1:1234"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Second request after refill returned %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
+
+// Test that API keys behind the same IP address have their own bucket when addresses are not limited
+func TestRateLimitKeysOfAddress(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(RateLimitConfig{Rate: 1, Burst: 1})
+	limiter.now = func() time.Time { return now }
+
+	// Authenticates the key in the header, as Authorize would
+	handler := limiter.LimitAddress(func(w http.ResponseWriter, r *http.Request) {
+		limiter.Limit(func(w http.ResponseWriter, r *http.Request) {})(w, withPrincipal(r, Principal{KeyID: r.Header.Get(APIKeyHeader)}))
+	})
+
+	// Sends a request with the API key from the same address, and returns the status
+	send := func(keyID string) int {
+		req := httptest.NewRequest(http.MethodGet, utils.REGISTRATION_LINE_PATH, nil)
+		req.RemoteAddr = "10.0.0.1:1234"
+		req.Header.Set(APIKeyHeader, keyID)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Code
+	}
+
+	if code := send("a"); code != http.StatusOK {
+		t.Errorf("Request with key a returned %d", code)
+	}
+	if code := send("b"); code != http.StatusOK {
+		t.Errorf("Request with key b from the same address returned %d", code)
+	}
+	if code := send("a"); code != http.StatusTooManyRequests {
+		t.Errorf("Second request with key a returned %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
+
+// Test of the daily quotas of RateLimiter, counted per API key
+func TestQuota(t *testing.T) {
+	now := time.Date(2024, 3, 1, 23, 0, 0, 0, time.UTC)
+	limiter := NewRateLimiter(RateLimitConfig{RenderQuota: 2, RegistrationQuota: 1})
+	limiter.now = func() time.Time { return now }
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {})
+
+	// Sends a request with the API key, and returns the response
+	send := func(keyID string, method string, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = withPrincipal(req, Principal{KeyID: keyID, Owner: "alice"})
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 2; i++ {
+		if rr := send("a", http.MethodGet, utils.DASHBOARD_PATH+"abcde"); rr.Code != http.StatusOK {
+			t.Fatalf("Dashboard %d returned %d", i, rr.Code)
+		}
+	}
+	rr := send("a", http.MethodGet, utils.DASHBOARD_PATH+"abcde")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "3600" || rr.Header().Get("RateLimit-Limit") != "2" {
+		t.Errorf("Dashboard over the quota returned %d with headers %v", rr.Code, rr.Header())
+	}
+
+	// Quotas are counted per key and per kind of request, other requests are not counted
+	if rr := send("b", http.MethodGet, utils.DASHBOARD_PATH+"abcde"); rr.Code != http.StatusOK {
+		t.Errorf("Dashboard of another key returned %d", rr.Code)
+	}
+	if rr := send("a", http.MethodPost, utils.REGISTRATION_PATH); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Registration returned %d with headers %v", rr.Code, rr.Header())
+	}
+	if rr := send("a", http.MethodPost, utils.REGISTRATION_LINE_PATH); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Registration over the quota returned %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if rr := send("a", http.MethodGet, utils.REGISTRATION_LINE_PATH); rr.Code != http.StatusOK {
+		t.Errorf("Listing returned %d", rr.Code)
+	}
+
+	// Quotas start over every day
+	now = now.Add(time.Hour)
+	if rr := send("a", http.MethodGet, utils.DASHBOARD_PATH+"abcde"); rr.Code != http.StatusOK {
+		t.Errorf("Dashboard the next day returned %d", rr.Code)
+	}
+}
+
+// Test that registrations that are not created do not count against the quota
+func TestRegistrationQuotaCountsCreated(t *testing.T) {
+	limiter := NewRateLimiter(RateLimitConfig{RegistrationQuota: 1})
+	status := http.StatusBadRequest
+	handler := limiter.Limit(func(w http.ResponseWriter, r *http.Request) {
+		if status != http.StatusOK {
+			http.Error(w, "Invalid input", status)
+			return
+		}
+		w.Write([]byte(`{"id":"abcde"}`))
+	})
+
+	// Sends a registration, and returns the response
+	send := func() *httptest.ResponseRecorder {
+		req := withPrincipal(httptest.NewRequest(http.MethodPost, utils.REGISTRATION_PATH, nil), Principal{KeyID: "a", Owner: "alice"})
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := send(); rr.Code != http.StatusBadRequest || rr.Header().Get("RateLimit-Remaining") != "1" {
+			t.Fatalf("Invalid registration %d returned %d with headers %v", i, rr.Code, rr.Header())
+		}
+	}
+
+	status = http.StatusOK
+	if rr := send(); rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Registration returned %d with headers %v", rr.Code, rr.Header())
+	}
+	if rr := send(); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Registration over the quota returned %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+}
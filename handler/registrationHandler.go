@@ -1,65 +1,44 @@
 package handler
 
 import (
+	"assignment2/store"
 	"assignment2/utils"
-	"context"
 	"encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"strings"
 	"time"
-
-	"cloud.google.com/go/firestore"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-// Firebase context and client used by Firestore functions throughout the program.
-var ctx context.Context
-var client *firestore.Client
-
-// sets the firestore client
-func SetFirestoreClient(c context.Context, cli *firestore.Client) {
-	ctx = c
-	client = cli
-}
-
-// function to get a document based on its id field
-func GetDocumentByID(ctx context.Context, collection string, dashboardID string) (*firestore.DocumentSnapshot, error) {
-	// Query documents where the 'id' field matches the provided dashboardID
-	query := client.Collection(collection).Where("id", "==", dashboardID).Limit(1)
-	iter := query.Documents(ctx)
-
-	// Retrieve reference to document
-	doc, err := iter.Next()
-	if err != nil {
-		return nil, err
-	}
-
-	return doc, nil
-}
-
-// name of collection used for dashboards
-const collection = "Dashboard"
-
 /*
-Handler for all registration-related operations
+Handler for all registration-related operations. The snapshots of the dashboards of changed or deleted configurations
+are removed
 */
-func RegistrationHandler() func(w http.ResponseWriter, r *http.Request) {
+func RegistrationHandler(dashboards store.DashboardStore, webhooks store.WebhookStore, audit store.AuditStore,
+	snapshots store.SnapshotStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// The trash and the revisions of a configuration are handled separately
+		if elem, ok := trashPath(r.URL.Path, utils.REGISTRATION_LINE_PATH); ok {
+			dashboardTrashHandler(w, r, dashboards, audit, elem)
+			return
+		}
+		if elem := strings.Split(r.URL.Path, "/"); len(elem) > 5 && elem[5] == "revisions" {
+			revisionHandler(w, r, dashboards, webhooks, audit, snapshots)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodPost:
-			postRegistration(w, r)
+			postRegistration(w, r, dashboards, webhooks, audit)
 		case http.MethodGet:
-			getDashboards(w, r)
+			getDashboards(w, r, dashboards)
 		case http.MethodPut:
-			updateDashboard(w, r, true)
+			updateDashboard(w, r, true, dashboards, webhooks, audit, snapshots)
 		case http.MethodPatch:
-			updateDashboard(w, r, false)
+			updateDashboard(w, r, false, dashboards, webhooks, audit, snapshots)
 		case http.MethodDelete:
-			deleteDashboard(w, r)
+			deleteDashboard(w, r, dashboards, webhooks, audit, snapshots)
 		default:
 			log.Println("Unsupported request method" + r.Method)
 			http.Error(w, "Unsupported request method"+r.Method, http.StatusMethodNotAllowed)
@@ -69,9 +48,10 @@ func RegistrationHandler() func(w http.ResponseWriter, r *http.Request) {
 }
 
 /*
-Handler for registering a new dashboard configuration, which get sendt to Firestore as a document
+Handler for registering a new dashboard configuration, which get sendt to the dashboard store
 */
-func postRegistration(w http.ResponseWriter, r *http.Request) {
+func postRegistration(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	audit store.AuditStore) {
 
 	// Instantiate decoder
 	decoder := json.NewDecoder(r.Body)
@@ -96,7 +76,12 @@ func postRegistration(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validCountry, validIso, err := utils.CheckCountry(dashboard.Country, dashboard.IsoCode, w)
+	if err := utils.ValidateFeatures(dashboard.Features); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	validCountry, validIso, err := utils.CheckCountry(r.Context(), dashboard.Country, dashboard.IsoCode)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -105,138 +90,93 @@ func postRegistration(w http.ResponseWriter, r *http.Request) {
 	dashboard.Country = validCountry
 	dashboard.IsoCode = validIso
 
-	validCurrencies := utils.CheckCurrencies(dashboard.Features.TargetCurrencies, w)
+	validCurrencies := utils.CheckCurrencies(r.Context(), dashboard.Features.TargetCurrencies, w)
 
-	_, checkIfMissingElements, missingElements := utils.UpdatedData(&dashboard, &dashboard, w)
+	_, checkIfMissingElements, missingElements := utils.UpdatedData(r.Context(), &dashboard, &dashboard, w)
 	if checkIfMissingElements {
 		http.Error(w, "Missing variables: "+strings.Join(missingElements, ", "), http.StatusBadRequest)
 		return
 	}
 
-	// Generate a random ID
-	var uniqueID string
+	dashboard.Features.TargetCurrencies = validCurrencies
+	dashboard.LastChange = time.Now()
 
-	for {
-		uniqueID = utils.GenerateUID(5)
+	// The configuration belongs to the caller
+	registration := utils.ToDashboard(&dashboard)
+	registration.Owner = ownerOf(r)
 
-		// Check if the generated ID already exists in a document
-		iter := client.Collection(collection).Where("id", "==", uniqueID).Limit(1).Documents(ctx)
+	// Add the decoded data to the store, which gives it a unique ID
+	uniqueID, err := dashboards.Create(r.Context(), registration)
+	if err != nil {
+		log.Println("Error adding document:", err)
+		http.Error(w, "Failed to add document", http.StatusInternalServerError)
+		return
+	}
+	registration.ID = uniqueID
+	registration.Revision = 1
+	recordChange(r, audit, store.AuditCreate, store.AuditRegistration, uniqueID, nil, toRegistrationResponse(registration))
 
-		doc, err := iter.Next()
-		if err == iterator.Done {
-			// No document found with current ID, continue with further processing
-			break
-		}
-		if err != nil {
-			log.Println("Error retrieving document:", err)
-			break
-		}
-		if doc != nil {
-			// ID already exists, generating a new one
-			log.Println("ID already exists...generating new one")
-			continue
-		}
+	response := struct {
+		ID         string `json:"id"`
+		Lastchange string `json:"lastChange"`
+	}{
+		ID:         uniqueID,
+		Lastchange: utils.WhatTimeNow(),
 	}
-	// Add the decoded date into Firestore
-	_, _, err1 := client.Collection(collection).Add(ctx,
-		map[string]interface{}{
-			"id":      uniqueID,
-			"country": dashboard.Country,
-			"isoCode": dashboard.IsoCode,
-			"features": map[string]interface{}{
-				"temperature":      dashboard.Features.Temperature,
-				"precipitation":    dashboard.Features.Precipitation,
-				"capital":          dashboard.Features.Capital,
-				"coordinates":      dashboard.Features.Coordinates,
-				"population":       dashboard.Features.Population,
-				"area":             dashboard.Features.Area,
-				"targetCurrencies": validCurrencies,
-			},
-			"lastChange": time.Now(),
-		})
-	if err1 != nil {
-		http.Error(w, "Failed to add document", http.StatusInternalServerError)
+	w.Header().Set("Content-type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode result", http.StatusInternalServerError)
 		return
-	} else {
-		response := struct {
-			ID         string `json:"id"`
-			Lastchange string `json:"lastChange"`
-		}{
-			ID:         uniqueID,
-			Lastchange: utils.WhatTimeNow(),
-		}
-		w.Header().Set("Content-type", "application/json")
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			http.Error(w, "failed to encode result", http.StatusInternalServerError)
-			return
-		}
+	}
 
-		// Trigger event if registered configuration has a registered webhook to invoke
-		if !invocationHandler(w, "REGISTER", dashboard.IsoCode) {
-			return
-		}
+	// Trigger event if registered configuration has a registered webhook to invoke
+	if !invocationHandler(r.Context(), w, webhooks, "REGISTER", dashboard.IsoCode, registration.Owner) {
+		return
 	}
 }
 
-// Function to retrieve document data and write JSON response
-func retrieveDocumentData(w http.ResponseWriter, doc *firestore.DocumentSnapshot) {
+// A dashboard configuration as shown to the client
+type registrationResponse struct {
+	ID       string `json:"id"`
+	Country  string `json:"country"`
+	IsoCode  string `json:"isoCode"`
+	Features struct {
+		Temperature         bool              `json:"temperature"`
+		Precipitation       bool              `json:"precipitation"`
+		Capital             bool              `json:"capital"`
+		Coordinates         bool              `json:"coordinates"`
+		Population          bool              `json:"population"`
+		Area                bool              `json:"area"`
+		TargetCurrencies    []string          `json:"targetCurrencies"`
+		WindSpeed           bool              `json:"windSpeed"`
+		WindDirection       bool              `json:"windDirection"`
+		Humidity            bool              `json:"humidity"`
+		CloudCover          bool              `json:"cloudCover"`
+		UVIndex             bool              `json:"uvIndex"`
+		ApparentTemperature bool              `json:"apparentTemperature"`
+		AirQuality          bool              `json:"airQuality"`
+		Aggregation         map[string]string `json:"aggregation,omitempty"`
+		Forecast            int               `json:"forecast,omitempty"`
+	} `json:"features"`
+	LastChange string `json:"lastChange"`
+	Revision   int    `json:"revision"`
+	Owner      string `json:"owner,omitempty"`
+}
 
-	// Map document data to Firestore struct
-	var originalDoc utils.Dashboard_Get
-	if err := doc.DataTo(&originalDoc); err != nil {
-		log.Println("Error retrieving document data:", err)
-		http.Error(w, "Error retrieving document data ", http.StatusInternalServerError)
-		return
-	}
-	// Create a Registration struct to create desired structure
-	response := struct {
-		ID       string `json:"id"`
-		Country  string `json:"country"`
-		IsoCode  string `json:"isoCode"`
-		Features struct {
-			Temperature      bool     `json:"temperature"`
-			Precipitation    bool     `json:"precipitation"`
-			Capital          bool     `json:"capital"`
-			Coordinates      bool     `json:"coordinates"`
-			Population       bool     `json:"population"`
-			Area             bool     `json:"area"`
-			TargetCurrencies []string `json:"targetCurrencies"`
-		} `json:"features"`
-		LastChange string `json:"lastChange"`
-	}{
-		ID:      originalDoc.ID,
-		Country: originalDoc.Country,
-		IsoCode: originalDoc.IsoCode,
-		Features: struct {
-			Temperature      bool     `json:"temperature"`
-			Precipitation    bool     `json:"precipitation"`
-			Capital          bool     `json:"capital"`
-			Coordinates      bool     `json:"coordinates"`
-			Population       bool     `json:"population"`
-			Area             bool     `json:"area"`
-			TargetCurrencies []string `json:"targetCurrencies"`
-		}{
-			Temperature:      originalDoc.Features.Temperature,
-			Precipitation:    originalDoc.Features.Precipitation,
-			Capital:          originalDoc.Features.Capital,
-			Coordinates:      originalDoc.Features.Coordinates,
-			Population:       originalDoc.Features.Population,
-			Area:             originalDoc.Features.Area,
-			TargetCurrencies: originalDoc.Features.TargetCurrencies,
-		},
-		LastChange: originalDoc.LastChange.Format("20060102 15:04"),
-	}
+// Function to write a dashboard configuration as JSON response
+func retrieveDocumentData(w http.ResponseWriter, originalDoc utils.Dashboard_Get) {
 
 	// Marshal the desired document to JSON
-	jsonData, err := json.Marshal(response)
+	jsonData, err := json.Marshal(toRegistrationResponse(originalDoc))
 	if err != nil {
 		log.Println("Error marshaling JSON:", err)
 		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
 		return
 	}
 
-	// Set the Content-Type header to application/json
+	// Set the Content-Type header to application/json, and the ETag of the revision
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etagOf(originalDoc))
 
 	// Write the JSON data to the response
 	if _, err := w.Write(jsonData); err != nil {
@@ -246,8 +186,55 @@ func retrieveDocumentData(w http.ResponseWriter, doc *firestore.DocumentSnapshot
 	}
 }
 
-// Gets one dashboard based on its Firestore ID. If no ID is provided it gets all dashboards
-func getDashboards(w http.ResponseWriter, r *http.Request) {
+// Function to create the desired structure of a stored dashboard configuration
+func toRegistrationResponse(originalDoc utils.Dashboard_Get) registrationResponse {
+	return registrationResponse{
+		ID:      originalDoc.ID,
+		Country: originalDoc.Country,
+		IsoCode: originalDoc.IsoCode,
+		Features: struct {
+			Temperature         bool              `json:"temperature"`
+			Precipitation       bool              `json:"precipitation"`
+			Capital             bool              `json:"capital"`
+			Coordinates         bool              `json:"coordinates"`
+			Population          bool              `json:"population"`
+			Area                bool              `json:"area"`
+			TargetCurrencies    []string          `json:"targetCurrencies"`
+			WindSpeed           bool              `json:"windSpeed"`
+			WindDirection       bool              `json:"windDirection"`
+			Humidity            bool              `json:"humidity"`
+			CloudCover          bool              `json:"cloudCover"`
+			UVIndex             bool              `json:"uvIndex"`
+			ApparentTemperature bool              `json:"apparentTemperature"`
+			AirQuality          bool              `json:"airQuality"`
+			Aggregation         map[string]string `json:"aggregation,omitempty"`
+			Forecast            int               `json:"forecast,omitempty"`
+		}{
+			Temperature:         originalDoc.Features.Temperature,
+			Precipitation:       originalDoc.Features.Precipitation,
+			Capital:             originalDoc.Features.Capital,
+			Coordinates:         originalDoc.Features.Coordinates,
+			Population:          originalDoc.Features.Population,
+			Area:                originalDoc.Features.Area,
+			TargetCurrencies:    originalDoc.Features.TargetCurrencies,
+			WindSpeed:           originalDoc.Features.WindSpeed,
+			WindDirection:       originalDoc.Features.WindDirection,
+			Humidity:            originalDoc.Features.Humidity,
+			CloudCover:          originalDoc.Features.CloudCover,
+			UVIndex:             originalDoc.Features.UVIndex,
+			ApparentTemperature: originalDoc.Features.ApparentTemperature,
+			AirQuality:          originalDoc.Features.AirQuality,
+			Aggregation:         originalDoc.Features.Aggregation,
+			Forecast:            originalDoc.Features.Forecast,
+		},
+		LastChange: originalDoc.LastChange.Format("20060102 15:04"),
+		Revision:   originalDoc.Revision,
+		Owner:      originalDoc.Owner,
+	}
+}
+
+// Gets one dashboard based on its ID. If no ID is provided it gets a page of all dashboards
+func getDashboards(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore) {
 
 	// Extract dashboard ID from URL
 	elem := strings.Split(r.URL.Path, "/")
@@ -256,12 +243,15 @@ func getDashboards(w http.ResponseWriter, r *http.Request) {
 	if len(elem) >= 5 {
 		dashboardID = elem[4]
 	}
-	//dashboardID := elem[4]
 
 	if len(dashboardID) != 0 {
-		doc, err := GetDocumentByID(ctx, collection, dashboardID)
+		dashboard, err := dashboards.Get(r.Context(), dashboardID)
+		// Configurations of other owners are not found
+		if err == nil && !canAccess(r, dashboard.Owner) {
+			err = store.ErrNotFound
+		}
 		if err != nil {
-			if err == iterator.Done {
+			if errors.Is(err, store.ErrNotFound) {
 				// Document not found
 				errorMessage := "Document with ID " + dashboardID + " not found"
 				http.Error(w, errorMessage, http.StatusNotFound)
@@ -273,30 +263,145 @@ func getDashboards(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// Retrieves document and writes JSON response
-		retrieveDocumentData(w, doc)
+		// Writes JSON response
+		retrieveDocumentData(w, dashboard)
 	} else {
-		// Collective retrieval of documents
-		iter := client.Collection(collection).Documents(ctx)
+		// Collective retrieval of documents, one page at a time
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		for {
-			doc, err := iter.Next()
-			if errors.Is(err, iterator.Done) {
-				break
-			}
+		// Filters and sort order of the listing, of the configurations of the caller
+		query, err := dashboardQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		query.Owner = scope(r)
+
+		// Fields to include of each configuration, all if not given
+		var fields []string
+		if value := r.URL.Query().Get("fields"); value != "" {
+			fields = strings.Split(value, ",")
+		}
+		if _, err := projectFields(registrationResponse{}, fields); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		all, err := dashboards.List(r.Context(), query, opts)
+		if errors.Is(err, store.ErrInvalidCursor) {
+			http.Error(w, "Invalid cursor '"+opts.Cursor+"'. Use the nextCursor of the previous page", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to iterate: %v", err)
+			http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+			return
+		}
+
+		page := store.Page[json.RawMessage]{
+			Items:      make([]json.RawMessage, 0, len(all.Items)),
+			NextCursor: all.NextCursor,
+			Total:      all.Total,
+		}
+		for _, dashboard := range all.Items {
+			item, err := projectFields(toRegistrationResponse(dashboard), fields)
 			if err != nil {
-				log.Printf("Failed to iterate: %v", err)
+				log.Println("Error marshaling JSON:", err)
+				http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
 				return
 			}
+			page.Items = append(page.Items, item)
+		}
+
+		// Writes JSON response
+		writePage(w, page)
+	}
+}
 
-			// Retrieves document and writes JSON response
-			retrieveDocumentData(w, doc)
+// Reads the filters and sort order of a listing from the query parameters
+// country, isoCode, feature, currency, changedSince and sort
+func dashboardQuery(r *http.Request) (store.DashboardQuery, error) {
+	params := r.URL.Query()
+
+	query := store.DashboardQuery{
+		Country:  params.Get("country"),
+		Currency: strings.ToUpper(params.Get("currency")),
+		Sort:     params.Get("sort"),
+	}
+
+	// Lists are comma separated, isocodes are matched in upper case
+	if isoCodes := params.Get("isoCode"); isoCodes != "" {
+		query.IsoCodes = strings.Split(strings.ToUpper(isoCodes), ",")
+	}
+	if features := params.Get("feature"); features != "" {
+		query.Features = strings.Split(features, ",")
+	}
+
+	// A sort order starting with '-' is descending, such as -lastChange
+	if strings.HasPrefix(query.Sort, "-") {
+		query.Sort = query.Sort[1:]
+		query.Descending = true
+	}
+
+	// Configurations changed since a time are listed in order of lastChange, unless another order is given
+	if changedSince := params.Get("changedSince"); changedSince != "" {
+		since, err := parseTime(changedSince)
+		if err != nil {
+			return query, err
+		}
+		query.ChangedSince = since
+		if query.Sort == "" {
+			query.Sort = store.SortByLastChange
 		}
 	}
+
+	if err := query.Validate(); err != nil {
+		return query, errors.New("Invalid query: " + err.Error())
+	}
+	return query, nil
+}
+
+// Parses a time given as RFC 3339 (2024-02-29T14:07:00Z), as date (2024-02-29), or in the format of
+// lastChange (20240229 14:07). Dates and lastChange are in UTC
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "20060102 15:04"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Invalid time '" + value + "'. Expected for example 2024-02-29T14:07:00Z or 2024-02-29")
 }
 
-// Deletes a specific dashboard based on its 'id' field
-func deleteDashboard(w http.ResponseWriter, r *http.Request) {
+// Returns the given fields of the configuration as JSON, or all fields if none are given
+func projectFields(dashboard registrationResponse, fields []string) (json.RawMessage, error) {
+	jsonData, err := json.Marshal(dashboard)
+	if err != nil || len(fields) == 0 {
+		return jsonData, err
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(jsonData, &all); err != nil {
+		return nil, err
+	}
+
+	projected := make(map[string]json.RawMessage, len(fields))
+	for _, field := range fields {
+		value, ok := all[field]
+		if !ok {
+			return nil, errors.New("Unknown field '" + field + "'. Supported: id, country, isoCode, features, lastChange, revision, owner")
+		}
+		projected[field] = value
+	}
+	return json.Marshal(projected)
+}
+
+// Moves a specific dashboard to the trash based on its 'id' field
+func deleteDashboard(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	audit store.AuditStore, snapshots store.SnapshotStore) {
 	// Extract dashboard ID from URL
 	elem := strings.Split(r.URL.Path, "/")
 
@@ -308,33 +413,40 @@ func deleteDashboard(w http.ResponseWriter, r *http.Request) {
 	dashboardID := elem[4]
 
 	if len(dashboardID) != 0 {
-		doc, err := GetDocumentByID(ctx, collection, dashboardID)
-		if err != nil {
+		// Retrieve the configuration, its isocode is used for a possible webhook event
+		dashboard, err := dashboards.Get(r.Context(), dashboardID)
+		if err != nil || !canAccess(r, dashboard.Owner) {
 			// Document not found
 			errorMessage := "Document with ID " + dashboardID + " not found"
 			http.Error(w, errorMessage, http.StatusNotFound)
 			return
 		}
 
-		// Retrieve isocode value from the document for webhook possible event
-		isocode, ok := doc.Data()["isoCode"].(string)
-		if !ok {
-			log.Println("Error accessing isocode field")
+		// The document is only deleted if it is the revision the client expects
+		if !ifMatch(r, dashboard) {
+			preconditionFailed(w, dashboardID)
+			return
 		}
 
-		// Delete the document
-		_, err = doc.Ref.Delete(ctx)
+		// Move the document to the trash, unless it has been changed since it was retrieved
+		err = dashboards.Delete(r.Context(), dashboardID, store.RevisionNumber(dashboard))
+		if errors.Is(err, store.ErrConflict) {
+			preconditionFailed(w, dashboardID)
+			return
+		}
 		if err != nil {
 			log.Println("Error deleting document:", err)
 			http.Error(w, "Error deleting document", http.StatusInternalServerError)
 			return
 		}
+		recordChange(r, audit, store.AuditDelete, store.AuditRegistration, dashboardID, toRegistrationResponse(dashboard), nil)
+		dropSnapshot(r.Context(), snapshots, dashboardID)
 
 		// Return success message
 		w.WriteHeader(http.StatusNoContent)
 
-		// // Trigger event if deleted configuration has a registered webhook to invoke
-		if !invocationHandler(w, "DELETE", isocode) {
+		// Trigger event if deleted configuration has a registered webhook to invoke
+		if !invocationHandler(r.Context(), w, webhooks, "DELETE", dashboard.IsoCode, dashboard.Owner) {
 			return
 		}
 
@@ -346,19 +458,32 @@ func deleteDashboard(w http.ResponseWriter, r *http.Request) {
 }
 
 // Function that updates a dashboard. Works as both PUT and PATCH, depending on bool given
-func updateDashboard(w http.ResponseWriter, r *http.Request, isPut bool) {
+func updateDashboard(w http.ResponseWriter, r *http.Request, isPut bool, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	audit store.AuditStore, snapshots store.SnapshotStore) {
 
 	//Fetching ID from URL
 	myId := r.URL.Path[len(utils.REGISTRATION_LINE_PATH):]
 
-	documentExists := true
-
-	doc, err := GetDocumentByID(ctx, collection, myId)
+	current, err := dashboards.Get(r.Context(), myId)
+	// Configurations of other owners are not found
+	if err == nil && !canAccess(r, current.Owner) {
+		err = store.ErrNotFound
+	}
 	if err != nil {
-		// Document not found
-		errorMessage := "Document with ID " + myId + "not found"
-		http.Error(w, errorMessage, http.StatusNotFound)
-		documentExists = false
+		if errors.Is(err, store.ErrNotFound) {
+			// Document not found
+			errorMessage := "Document with ID " + myId + " not found"
+			http.Error(w, errorMessage, http.StatusNotFound)
+			return
+		}
+		log.Println("Error retrieving document:", err)
+		http.Error(w, "Error retrieving document", http.StatusInternalServerError)
+		return
+	}
+
+	// The document is only updated if it is the revision the client expects
+	if !ifMatch(r, current) {
+		preconditionFailed(w, myId)
 		return
 	}
 
@@ -368,26 +493,18 @@ func updateDashboard(w http.ResponseWriter, r *http.Request, isPut bool) {
 		http.Error(w, "Error: decoding JSON, Invalid input \n"+err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	//Time is set to now
-	myObject.LastChange = time.Now()
-
-	var docRef interface{} = nil
-
-	if documentExists {
-		//Reference to the document with specified id (will be changed later)
-		docRef = client.Collection(collection).Doc(doc.Ref.ID)
+	if err := utils.ValidateFeatures(myObject.Features); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Retrieve current isocode value from the document for webhook event
-	isocode, ok := doc.Data()["isoCode"].(string)
-	if !ok {
-		log.Println("Error accessing isocode field")
-	}
+	// Current isocode value of the document is used for the webhook event
+	isocode := current.IsoCode
+	var stored utils.Dashboard_Get
 
 	//If the user puts in PUT request
 	if isPut {
-		validCountry, validIso, err := utils.CheckCountry(myObject.Country, myObject.IsoCode, w)
+		validCountry, validIso, err := utils.CheckCountry(r.Context(), myObject.Country, myObject.IsoCode)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -397,152 +514,68 @@ func updateDashboard(w http.ResponseWriter, r *http.Request, isPut bool) {
 		myObject.IsoCode = validIso
 		var p utils.Firestore
 		//Checks for missing elements from user input
-		_, checkIfMissingElements, missingElements := utils.UpdatedData(&p, &myObject, w)
+		_, checkIfMissingElements, missingElements := utils.UpdatedData(r.Context(), &p, &myObject, w)
 		if checkIfMissingElements {
 			http.Error(w, "Missing variables: "+strings.Join(missingElements, ", "), http.StatusBadRequest)
 			return
 		}
 
-		myObject.Features.TargetCurrencies = utils.CheckCurrencies(myObject.Features.TargetCurrencies, w)
-
-		//taking the data from the object and applies them to a map
-		data := map[string]interface{}{
-			"country": myObject.Country,
-			"isoCode": myObject.IsoCode,
-			"features": map[string]interface{}{
-				"temperature":      myObject.Features.Temperature,
-				"precipitation":    myObject.Features.Precipitation,
-				"capital":          myObject.Features.Capital,
-				"coordinates":      myObject.Features.Coordinates,
-				"population":       myObject.Features.Population,
-				"area":             myObject.Features.Area,
-				"targetCurrencies": myObject.Features.TargetCurrencies,
-			},
-			"lastChange": time.Now(),
-		}
-		if docRef != nil {
-			if firestoreDocRef, ok := docRef.(*firestore.DocumentRef); ok {
-
-				//Creates a new object, that fetches data from firebase
-				var newObject utils.Firestore
-
-				//Fetching the document, checks if it exists
-				doc, err := firestoreDocRef.Get(ctx)
-				if err != nil {
-					if status.Code(err) == codes.NotFound {
-						http.Error(w, "Document does not exist, cannot PATCH", http.StatusBadRequest)
-						return
-					}
-				}
-				doc.DataTo(&newObject)
-
-				data["id"] = newObject.ID
-
-				_, err1 := firestoreDocRef.Set(ctx, data)
-				if err1 != nil {
-					http.Error(w, "failed to update data", http.StatusInternalServerError)
-					return
-
-				}
-			}
-		} else {
-			var uniqueID string
-
-			for {
-				uniqueID = utils.GenerateUID(5)
-
-				// Check if the generated ID already exists in a document
-				iter := client.Collection(collection).Where("id", "==", uniqueID).Limit(1).Documents(ctx)
-
-				doc, err := iter.Next()
-				if err == iterator.Done {
-					// No document found with current ID, continue with further processing
-					break
-				}
-				if err != nil {
-					log.Println("Error retrieving document:", err)
-					break
-				}
-				if doc != nil {
-					// ID already exists, generating a new one
-					log.Println("ID already exists...generating new one")
-					continue
-				}
-			}
-			data["id"] = uniqueID
-			_, _, err := client.Collection(collection).Add(ctx, data)
-			if err != nil {
-				http.Error(w, "Failed to add new document: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
+		myObject.Features.TargetCurrencies = utils.CheckCurrencies(r.Context(), myObject.Features.TargetCurrencies, w)
 
-		}
+		//The ID is kept, and time is set to now
+		myObject.ID = current.ID
+		myObject.LastChange = time.Now()
 
-		// Trigger event if changed configuration has a registered webhook to invoke
-		if !invocationHandler(w, "CHANGE", isocode) {
+		// Updates the document, unless it has been changed since it was retrieved
+		stored, err = dashboards.Update(r.Context(), utils.ToDashboard(&myObject), store.RevisionNumber(current))
+		if errors.Is(err, store.ErrConflict) {
+			preconditionFailed(w, myId)
+			return
+		}
+		if err != nil {
+			http.Error(w, "failed to update data", http.StatusInternalServerError)
 			return
 		}
 
 		//If user put in a PATCH request
 	} else {
-		if docRef != nil {
-			if firestoreDocRef, ok := docRef.(*firestore.DocumentRef); ok {
-
-				//Creates a new object, that fetches data from firebase
-				var newObject utils.Firestore
-
-				//Fetching the document, checks if it exists
-				doc, err := firestoreDocRef.Get(ctx)
-				if err != nil {
-					if status.Code(err) == codes.NotFound {
-						http.Error(w, "Document does not exist, cannot PATCH", http.StatusBadRequest)
-						return
-					}
-				}
-				doc.DataTo(&newObject)
-
-				validCountry, validIso, err := utils.CheckCountry(myObject.Country, myObject.IsoCode, w)
-				//If it turns out that country name or isocode provided in the PATCH request are valid, it will change both variables.
-				//Otherwise, it will not
-				if err == nil {
-					myObject.Country = validCountry
-					myObject.IsoCode = validIso
-				}
-
-				//Merges the data from firebase with user input (that has been written)
-				final, _, _ := utils.UpdatedData(&newObject, &myObject, w)
-
-				final.Features.TargetCurrencies = utils.CheckCurrencies(final.Features.TargetCurrencies, w)
-
-				//Updates the document
-				_, err = firestoreDocRef.Set(ctx, map[string]interface{}{
-					"id":      newObject.ID,
-					"country": final.Country,
-					"isoCode": final.IsoCode,
-					"features": map[string]interface{}{
-						"temperature":      final.Features.Temperature,
-						"precipitation":    final.Features.Precipitation,
-						"capital":          final.Features.Capital,
-						"coordinates":      final.Features.Coordinates,
-						"population":       final.Features.Population,
-						"area":             final.Features.Area,
-						"targetCurrencies": final.Features.TargetCurrencies,
-					},
-					"lastChange": time.Now(),
-				})
-				if err != nil {
-					http.Error(w, "Failed to patch", http.StatusInternalServerError)
-					return
-				}
-			}
-		} else {
-			http.Error(w, "Document does not exist", http.StatusBadRequest)
-			return
+		//Creates a new object, from the stored configuration
+		newObject := utils.FromDashboard(current)
+
+		validCountry, validIso, err := utils.CheckCountry(r.Context(), myObject.Country, myObject.IsoCode)
+		//If it turns out that country name or isocode provided in the PATCH request are valid, it will change both variables.
+		//Otherwise, it will not
+		if err == nil {
+			myObject.Country = validCountry
+			myObject.IsoCode = validIso
 		}
 
-		// Trigger event if changed configuration has a registered webhook to invoke
-		if !invocationHandler(w, "CHANGE", isocode) {
+		//Merges the stored data with user input (that has been written)
+		final, _, _ := utils.UpdatedData(r.Context(), &newObject, &myObject, w)
+
+		final.Features.TargetCurrencies = utils.CheckCurrencies(r.Context(), final.Features.TargetCurrencies, w)
+		final.LastChange = time.Now()
+
+		//Updates the document, unless it has been changed since it was retrieved
+		stored, err = dashboards.Update(r.Context(), utils.ToDashboard(final), store.RevisionNumber(current))
+		if errors.Is(err, store.ErrConflict) {
+			preconditionFailed(w, myId)
 			return
 		}
+		if err != nil {
+			http.Error(w, "Failed to patch", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	recordChange(r, audit, store.AuditUpdate, store.AuditRegistration, myId, toRegistrationResponse(current), toRegistrationResponse(stored))
+	dropSnapshot(r.Context(), snapshots, myId)
+
+	// The ETag of the stored revision lets the client make its next change
+	w.Header().Set("ETag", etagOf(stored))
+
+	// Trigger event if changed configuration has a registered webhook to invoke
+	if !invocationHandler(r.Context(), w, webhooks, "CHANGE", isocode, current.Owner) {
+		return
 	}
 }
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"assignment2/store"
 	"assignment2/utils"
 	"bytes"
 	"context"
@@ -12,15 +13,13 @@ import (
 	"strings"
 	"testing"
 	"time"
-
-	"cloud.google.com/go/firestore"
 )
 
 // Test function for RegistrationHandler
 func TestRegistrationHandler(t *testing.T) {
 
 	// Initialize handler instance
-	handler := RegistrationHandler()
+	handler := RegistrationHandler(store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemoryAuditLog(), store.NewMemorySnapshots())
 
 	// set up structure to be used for testing and close when finished testing
 	server := httptest.NewServer(http.HandlerFunc(handler))
@@ -52,27 +51,168 @@ func TestRegistrationHandler(t *testing.T) {
 	}
 
 }
-func TestSetFirestoreClient(t *testing.T) {
-	// Create a mock context
-	ctx := context.Background()
 
-	// Create a mock Firestore client
-	client := &firestore.Client{} // Replace with your actual Firestore client
+// Test for getDashboards function
+func TestGetDashboards(t *testing.T) {
+	// Create a store with one registered configuration
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(context.Background(), utils.Dashboard_Get{
+		Country:    "Norway",
+		IsoCode:    "NO",
+		Features:   utils.Features_Get{Temperature: true, TargetCurrencies: []string{"EUR"}},
+		LastChange: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
 
-	// Call the function with the mock context and client
-	SetFirestoreClient(ctx, client)
+	// Get the registered configuration
+	req, err := http.NewRequest("GET", utils.REGISTRATION_LINE_PATH+id, nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr := httptest.NewRecorder()
+	getDashboards(rr, req, dashboards)
+
+	// Check the result
+	if rr.Code != http.StatusOK {
+		t.Errorf("getDashboards() returned status code %v; want %v", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), `"id":"`+id+`"`) || !strings.Contains(rr.Body.String(), `"temperature":true`) {
+		t.Errorf("getDashboards() returned wrong body: got %v", rr.Body.String())
+	}
+
+	// Get a configuration that does not exist
+	req, err = http.NewRequest("GET", utils.REGISTRATION_LINE_PATH+"unknown", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	getDashboards(rr, req, dashboards)
 
 	// Check the result
-	if client == nil {
-		t.Errorf("SetFirestoreClient() did not correctly set the client")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("getDashboards() returned status code %v; want %v", rr.Code, http.StatusNotFound)
 	}
-	if ctx == nil {
-		t.Errorf("SetFirestoreClient() did not correctly set the context")
+
+	// Register two more configurations, and page through all three, two at a time
+	for _, country := range []string{"Sweden", "Denmark"} {
+		if _, err := dashboards.Create(context.Background(), utils.Dashboard_Get{Country: country}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seen := map[string]bool{}
+	cursor := ""
+	for pages := 1; ; pages++ {
+		req, err = http.NewRequest("GET", utils.REGISTRATION_LINE_PATH+"?limit=2&cursor="+cursor, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest() returned error: %v", err)
+		}
+		rr = httptest.NewRecorder()
+		getDashboards(rr, req, dashboards)
+
+		var page store.Page[registrationResponse]
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil {
+			t.Fatalf("getDashboards() returned invalid JSON %v: %v", rr.Body.String(), err)
+		}
+		if page.Total != 3 || len(page.Items) > 2 {
+			t.Errorf("getDashboards() returned wrong page: got %v", rr.Body.String())
+		}
+		for _, dashboard := range page.Items {
+			seen[dashboard.Country] = true
+		}
+
+		cursor = page.NextCursor
+		if cursor == "" {
+			if pages != 2 {
+				t.Errorf("Expected 2 pages, got %v", pages)
+			}
+			break
+		}
+		if pages > 2 {
+			t.Fatal("getDashboards() does not stop paging")
+		}
+	}
+	if len(seen) != 3 {
+		t.Errorf("Expected all three configurations to be listed, got %v", seen)
+	}
+
+	// An invalid limit is rejected
+	req, err = http.NewRequest("GET", utils.REGISTRATION_LINE_PATH+"?limit=none", nil)
+	if err != nil {
+		t.Fatalf("http.NewRequest() returned error: %v", err)
+	}
+	rr = httptest.NewRecorder()
+	getDashboards(rr, req, dashboards)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("getDashboards() returned status code %v; want %v", rr.Code, http.StatusBadRequest)
+	}
+}
+
+// Test of filtering, sorting and projecting the listing of getDashboards
+func TestGetDashboardsQuery(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	changed := time.Date(2024, 2, 29, 14, 7, 0, 0, time.UTC)
+	for i, dashboard := range []utils.Dashboard_Get{
+		{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Temperature: true, TargetCurrencies: []string{"EUR"}}},
+		{Country: "Sweden", IsoCode: "SE", Features: utils.Features_Get{TargetCurrencies: []string{"EUR"}}},
+		{Country: "Denmark", IsoCode: "DK", Features: utils.Features_Get{Temperature: true}},
+	} {
+		dashboard.LastChange = changed.AddDate(0, 0, i)
+		if _, err := dashboards.Create(context.Background(), dashboard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		query      string
+		wantStatus int
+		want       string
+	}{
+		{"?country=Norway&fields=country", http.StatusOK, `[{"country":"Norway"}]`},
+		{"?isoCode=no,se&sort=-lastChange&fields=isoCode", http.StatusOK, `[{"isoCode":"SE"},{"isoCode":"NO"}]`},
+		{"?feature=temperature&sort=country&fields=country", http.StatusOK, `[{"country":"Denmark"},{"country":"Norway"}]`},
+		{"?currency=eur&changedSince=2024-03-01&fields=country,lastChange", http.StatusOK, `[{"country":"Sweden","lastChange":"20240301 14:07"}]`},
+		{"?changedSince=20240301 14:08&sort=lastChange&fields=country", http.StatusOK, `[{"country":"Denmark"}]`},
+		{"?feature=weather", http.StatusBadRequest, ""},
+		{"?sort=population", http.StatusBadRequest, ""},
+		{"?changedSince=yesterday", http.StatusBadRequest, ""},
+		{"?changedSince=2024-03-01&sort=country", http.StatusBadRequest, ""},
+		{"?fields=id,name", http.StatusBadRequest, ""},
+		{"?cursor=unknown", http.StatusBadRequest, ""},
+	}
+	for _, test := range tests {
+		req, err := http.NewRequest("GET", utils.REGISTRATION_LINE_PATH, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest() returned error: %v", err)
+		}
+		req.URL.RawQuery = strings.ReplaceAll(test.query[1:], " ", "%20")
+		rr := httptest.NewRecorder()
+		getDashboards(rr, req, dashboards)
+
+		if rr.Code != test.wantStatus {
+			t.Errorf("getDashboards(%v) returned status code %v; want %v", test.query, rr.Code, test.wantStatus)
+			continue
+		}
+		if test.want == "" {
+			continue
+		}
+
+		var page struct {
+			Items json.RawMessage `json:"items"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &page); err != nil || string(page.Items) != test.want {
+			t.Errorf("getDashboards(%v) returned %v; want items %v", test.query, rr.Body.String(), test.want)
+		}
 	}
 }
 
 // Test for postRegistration function
 func TestPostRegistration(t *testing.T) {
+	// Stores used by the handler
+	dashboards := store.NewMemoryDashboards()
+	webhooks := store.NewMemoryWebhooks()
+
 	// Create a Firestore object with all fields filled
 	dashboard := utils.Firestore{
 		ID:      "testID",
@@ -106,7 +246,7 @@ func TestPostRegistration(t *testing.T) {
 	rr := httptest.NewRecorder()
 
 	// Call postRegistration with the request and the ResponseRecorder
-	postRegistration(rr, req)
+	postRegistration(rr, req, dashboards, webhooks, store.NewMemoryAuditLog())
 
 	// Check the result
 	if rr.Code != http.StatusInternalServerError {
@@ -132,7 +272,7 @@ func TestPostRegistration(t *testing.T) {
 	}
 	// Create a ResponseRecorder to record the response
 	rr = httptest.NewRecorder()
-	postRegistration(rr, req)
+	postRegistration(rr, req, dashboards, webhooks, store.NewMemoryAuditLog())
 	// Check the result
 	if rr.Code != http.StatusBadRequest {
 		t.Errorf("postRegistration() returned status code %v; want %v", rr.Code, http.StatusBadRequest)
@@ -149,7 +289,7 @@ func TestPostRegistration(t *testing.T) {
 		t.Fatalf("http.NewRequest() returned error: %v", err)
 	}
 	// call the function with the new request
-	postRegistration(rr, req2)
+	postRegistration(rr, req2, dashboards, webhooks, store.NewMemoryAuditLog())
 
 	// Check the response
 	response := rr.Result()
@@ -168,6 +308,50 @@ func TestPostRegistration(t *testing.T) {
 	}
 }
 
+// Test that the aggregations of the weather features are validated, stored, and merged by a PATCH
+func TestRegistrationAggregation(t *testing.T) {
+	dashboards := store.NewMemoryDashboards()
+	handler := RegistrationHandler(dashboards, store.NewMemoryWebhooks(), store.NewMemoryAuditLog(), store.NewMemorySnapshots())
+	features := `"temperature": true, "precipitation": true, "capital": true, "coordinates": true, "population": true,
+		"area": true, "targetCurrencies": ["EUR"]`
+
+	// Sends the request to the handler
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(method, utils.REGISTRATION_LINE_PATH+path, strings.NewReader(body)))
+		return rr
+	}
+
+	for _, aggregation := range []string{`{"temperature": "median"}`, `{"capital": "sum"}`, `{"temperature": "sum"}`} {
+		rr := send(http.MethodPost, "", `{"country": "Norway", "features": {`+features+`, "aggregation": `+aggregation+`}}`)
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid aggregation") {
+			t.Errorf("POST with the aggregation %s returned %d: %s", aggregation, rr.Code, rr.Body.String())
+		}
+	}
+
+	rr := send(http.MethodPost, "", `{"country": "Norway", "features": {`+features+`, "aggregation": {"precipitation": "sum"}}}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("POST returned %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+
+	if rr := send(http.MethodPatch, created.ID, `{"features": {"aggregation": {"temperature": "hourly"}}}`); rr.Code != http.StatusOK {
+		t.Fatalf("PATCH returned %d: %s", rr.Code, rr.Body.String())
+	}
+	stored, err := dashboards.Get(context.Background(), created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Features.AggregationOf("precipitation") != utils.AggregationSum || stored.Features.AggregationOf("temperature") != utils.AggregationHourly {
+		t.Errorf("Unexpected aggregation %v", stored.Features.Aggregation)
+	}
+}
+
 // Test for DeleteDashboard function
 func TestDeleteDashboard(t *testing.T) {
 	// Test without dashboard ID
@@ -177,9 +361,57 @@ func TestDeleteDashboard(t *testing.T) {
 	}
 	// Create a ResponseRecorder to record the response
 	rr := httptest.NewRecorder()
-	deleteDashboard(rr, req)
+	deleteDashboard(rr, req, store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemoryAuditLog(), store.NewMemorySnapshots())
 	// Check the result is as expected
 	if status := rr.Code; status != http.StatusBadRequest {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
 	}
 }
+
+// Test for the ETag and If-Match handling of single configurations
+func TestRegistrationETag(t *testing.T) {
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dashboards.Update(ctx, utils.Dashboard_Get{ID: id, Country: "Norway", IsoCode: "NO"}, store.AnyRevision); err != nil {
+		t.Fatal(err)
+	}
+	handler := RegistrationHandler(dashboards, store.NewMemoryWebhooks(), store.NewMemoryAuditLog(), store.NewMemorySnapshots())
+
+	send := func(method string, ifMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, utils.REGISTRATION_LINE_PATH+id, strings.NewReader("{}"))
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// The ETag is derived from the revision
+	rr := send(http.MethodGet, "")
+	if rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Fatalf("Expected 200 with ETag \"2\", got %d with ETag %s", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	// Writes on an earlier revision are refused
+	for _, method := range []string{http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if rr := send(method, `"1"`); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("%s with stale If-Match returned %d, want %d", method, rr.Code, http.StatusPreconditionFailed)
+		}
+	}
+	if rr := send(http.MethodDelete, `W/"2"`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("DELETE with weak If-Match returned %d, want %d", rr.Code, http.StatusPreconditionFailed)
+	}
+	if _, err := dashboards.Get(ctx, id); err != nil {
+		t.Fatalf("Configuration was deleted despite failed precondition: %v", err)
+	}
+
+	// A matching tag in the list lets the write through
+	if rr := send(http.MethodDelete, `"1", "2"`); rr.Code != http.StatusNoContent {
+		t.Errorf("DELETE with matching If-Match returned %d, want %d", rr.Code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A changed field between two revisions
+type revisionChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// The changes from one revision to another
+type revisionDiff struct {
+	ID      string           `json:"id"`
+	From    int              `json:"from"`
+	To      int              `json:"to"`
+	Changes []revisionChange `json:"changes"`
+}
+
+/*
+Handler for the revisions of a dashboard configuration:
+GET {id}/revisions, GET {id}/revisions/{n}, GET {id}/revisions/diff?from={n}&to={m} and POST {id}/revisions/{n}/restore
+*/
+func revisionHandler(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	audit store.AuditStore, snapshots store.SnapshotStore) {
+	// Path elements after the registrations path: {id}, "revisions", and optionally {n} or "diff", and "restore"
+	elem := strings.Split(strings.TrimSuffix(r.URL.Path[len(utils.REGISTRATION_LINE_PATH):], "/"), "/")
+	dashboardID := elem[0]
+
+	// The revisions of configurations of other owners are not found
+	if scope(r) != "" {
+		current, err := dashboards.Get(r.Context(), dashboardID)
+		if err == nil && !canAccess(r, current.Owner) {
+			err = store.ErrNotFound
+		}
+		if err != nil {
+			revisionError(w, err, dashboardID)
+			return
+		}
+	}
+
+	switch {
+	case len(elem) == 2 && r.Method == http.MethodGet:
+		getRevisions(w, r, dashboards, dashboardID)
+	case len(elem) == 3 && elem[2] == "diff" && r.Method == http.MethodGet:
+		diffRevisions(w, r, dashboards, dashboardID)
+	case len(elem) == 3 && r.Method == http.MethodGet:
+		getRevision(w, r, dashboards, dashboardID, elem[2])
+	case len(elem) == 4 && elem[3] == "restore" && r.Method == http.MethodPost:
+		restoreRevision(w, r, dashboards, webhooks, audit, snapshots, dashboardID, elem[2])
+	case len(elem) > 4 || (len(elem) == 4 && elem[3] != "restore"):
+		http.Error(w, "Unknown path "+r.URL.Path, http.StatusNotFound)
+	default:
+		http.Error(w, "Method "+r.Method+" not supported for "+r.URL.Path, http.StatusMethodNotAllowed)
+	}
+}
+
+// Writes the value as JSON response
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	jsonData, err := json.Marshal(value)
+	if err != nil {
+		log.Println("Error marshaling JSON:", err)
+		http.Error(w, "Error marshaling JSON", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(jsonData); err != nil {
+		log.Println("Error writing JSON response:", err)
+	}
+}
+
+// Writes the error of reading revisions, as not found if the configuration or revision does not exist
+func revisionError(w http.ResponseWriter, err error, dashboardID string) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Revision of document with ID "+dashboardID+" not found", http.StatusNotFound)
+		return
+	}
+	log.Println("Error retrieving revisions:", err)
+	http.Error(w, "Error retrieving revisions", http.StatusInternalServerError)
+}
+
+// Parses a revision number
+func revisionNumber(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, errors.New("Invalid revision '" + value + "'. Expected a positive number")
+	}
+	return n, nil
+}
+
+// Gets every revision of a dashboard configuration, oldest first
+func getRevisions(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, dashboardID string) {
+	revisions, err := dashboards.Revisions(r.Context(), dashboardID)
+	if err != nil {
+		revisionError(w, err, dashboardID)
+		return
+	}
+
+	page := store.Page[registrationResponse]{
+		Items: make([]registrationResponse, 0, len(revisions)),
+		Total: len(revisions),
+	}
+	for _, revision := range revisions {
+		page.Items = append(page.Items, toRegistrationResponse(revision))
+	}
+	writePage(w, page)
+}
+
+// Gets one revision of a dashboard configuration
+func getRevision(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, dashboardID string, number string) {
+	n, err := revisionNumber(number)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	revision, err := dashboards.Revision(r.Context(), dashboardID, n)
+	if err != nil {
+		revisionError(w, err, dashboardID)
+		return
+	}
+	retrieveDocumentData(w, revision)
+}
+
+// Flattens the JSON object into fields named by their path, such as features.temperature
+func flattenFields(prefix string, object map[string]interface{}, fields map[string]interface{}) {
+	for name, value := range object {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flattenFields(prefix+name+".", nested, fields)
+		} else {
+			fields[prefix+name] = value
+		}
+	}
+}
+
+// Returns the changed fields of the configuration between the two revisions, sorted by field.
+// The revision number and time of change are left out
+func changesBetween(from utils.Dashboard_Get, to utils.Dashboard_Get) ([]revisionChange, error) {
+	fields := make([]map[string]interface{}, 2)
+	for i, revision := range []utils.Dashboard_Get{from, to} {
+		jsonData, err := json.Marshal(toRegistrationResponse(revision))
+		if err != nil {
+			return nil, err
+		}
+		var object map[string]interface{}
+		if err := json.Unmarshal(jsonData, &object); err != nil {
+			return nil, err
+		}
+		delete(object, "revision")
+		delete(object, "lastChange")
+
+		fields[i] = make(map[string]interface{})
+		flattenFields("", object, fields[i])
+	}
+
+	// Fields left out of one of the revisions, such as the optional ones, are null in it
+	changes := make([]revisionChange, 0)
+	for i, revisionFields := range fields {
+		for field := range revisionFields {
+			if _, inFrom := fields[0][field]; i == 1 && inFrom {
+				continue
+			}
+			if !reflect.DeepEqual(fields[0][field], fields[1][field]) {
+				changes = append(changes, revisionChange{Field: field, From: fields[0][field], To: fields[1][field]})
+			}
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes, nil
+}
+
+// Gets the changes between two revisions of a dashboard configuration. By default, the changes of the
+// latest revision are given. Query parameter 'to' selects another revision, and 'from' the revision to compare with
+func diffRevisions(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, dashboardID string) {
+	revisions, err := dashboards.Revisions(r.Context(), dashboardID)
+	// A configuration stored without its revisions has no latest revision to compare
+	if err == nil && len(revisions) == 0 {
+		err = store.ErrNotFound
+	}
+	if err != nil {
+		revisionError(w, err, dashboardID)
+		return
+	}
+
+	diff := revisionDiff{ID: dashboardID, To: revisions[len(revisions)-1].Revision}
+	if to := r.URL.Query().Get("to"); to != "" {
+		if diff.To, err = revisionNumber(to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	diff.From = diff.To - 1
+	if from := r.URL.Query().Get("from"); from != "" {
+		if diff.From, err = revisionNumber(from); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if diff.From < 1 {
+		http.Error(w, "Revision "+strconv.Itoa(diff.To)+" has no earlier revision. Give the revision to compare with as 'from'",
+			http.StatusBadRequest)
+		return
+	}
+
+	var found [2]utils.Dashboard_Get
+	for i, n := range []int{diff.From, diff.To} {
+		found[i], err = dashboards.Revision(r.Context(), dashboardID, n)
+		if err != nil {
+			revisionError(w, err, dashboardID)
+			return
+		}
+	}
+
+	diff.Changes, err = changesBetween(found[0], found[1])
+	if err != nil {
+		log.Println("Error comparing revisions:", err)
+		http.Error(w, "Error comparing revisions", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, diff)
+}
+
+// Restores a revision of a dashboard configuration, by storing it as a new revision
+func restoreRevision(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	audit store.AuditStore, snapshots store.SnapshotStore, dashboardID string, number string) {
+	n, err := revisionNumber(number)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Current isocode value of the document is used for the webhook event
+	current, err := dashboards.Get(r.Context(), dashboardID)
+	if err != nil {
+		revisionError(w, err, dashboardID)
+		return
+	}
+
+	if !ifMatch(r, current) {
+		preconditionFailed(w, dashboardID)
+		return
+	}
+
+	revision, err := dashboards.Revision(r.Context(), dashboardID, n)
+	if err != nil {
+		revisionError(w, err, dashboardID)
+		return
+	}
+
+	revision.LastChange = time.Now()
+	restored, err := dashboards.Update(r.Context(), revision, store.RevisionNumber(current))
+	if errors.Is(err, store.ErrConflict) {
+		preconditionFailed(w, dashboardID)
+		return
+	}
+	if err != nil {
+		log.Println("Error restoring revision:", err)
+		http.Error(w, "Failed to restore revision", http.StatusInternalServerError)
+		return
+	}
+	recordChange(r, audit, store.AuditUpdate, store.AuditRegistration, dashboardID, toRegistrationResponse(current), toRegistrationResponse(restored))
+	dropSnapshot(r.Context(), snapshots, dashboardID)
+	retrieveDocumentData(w, restored)
+
+	// Trigger event if changed configuration has a registered webhook to invoke
+	if !invocationHandler(r.Context(), w, webhooks, "CHANGE", current.IsoCode, current.Owner) {
+		return
+	}
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test of listing, comparing and restoring the revisions of a configuration
+func TestRevisionHandler(t *testing.T) {
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	webhooks := store.NewMemoryWebhooks()
+	handler := RegistrationHandler(dashboards, webhooks, store.NewMemoryAuditLog(), store.NewMemorySnapshots())
+
+	// Client service receiving the CHANGE event
+	changes := make(chan utils.WebhookInvokeMessage, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hook utils.WebhookInvokeMessage
+		if err := json.NewDecoder(r.Body).Decode(&hook); err == nil {
+			changes <- hook
+		}
+	}))
+	defer receiver.Close()
+	if _, err := webhooks.Create(ctx, utils.WebhookGetResponse{Url: receiver.URL, Country: "NO", Event: "CHANGE"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A configuration with three revisions
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Temperature: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, features := range []utils.Features_Get{
+		{Temperature: true, Capital: true},
+		{Capital: true, TargetCurrencies: []string{"EUR"}},
+	} {
+		if _, err := dashboards.Update(ctx, utils.Dashboard_Get{ID: id, Country: "Norway", IsoCode: "NO", Features: features}, store.AnyRevision); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Sends a request to the handler, and decodes the JSON response into value if the status code is 200
+	send := func(method string, path string, value interface{}) int {
+		req, err := http.NewRequest(method, utils.REGISTRATION_LINE_PATH+id+path, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest() returned error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code == http.StatusOK && value != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), value); err != nil {
+				t.Fatalf("%s %s returned invalid JSON %v: %v", method, path, rr.Body.String(), err)
+			}
+		}
+		return rr.Code
+	}
+
+	// List the revisions
+	var revisions store.Page[registrationResponse]
+	if code := send("GET", "/revisions", &revisions); code != http.StatusOK || revisions.Total != 3 || revisions.Items[2].Revision != 3 {
+		t.Errorf("GET revisions returned %v: %v", code, revisions)
+	}
+
+	// Get the first revision
+	var first registrationResponse
+	if code := send("GET", "/revisions/1", &first); code != http.StatusOK || first.Revision != 1 || first.Features.Capital {
+		t.Errorf("GET revision 1 returned %v: %v", code, first)
+	}
+	for _, path := range []string{"/revisions/4", "/revisions/0", "/revisions/x"} {
+		if code := send("GET", path, nil); code == http.StatusOK {
+			t.Errorf("GET %v returned %v", path, code)
+		}
+	}
+
+	// Compare the latest revision with the one before, and the first with the latest
+	var diff revisionDiff
+	if code := send("GET", "/revisions/diff", &diff); code != http.StatusOK || diff.From != 2 || diff.To != 3 || len(diff.Changes) != 2 {
+		t.Errorf("GET diff returned %v: %v", code, diff)
+	}
+	if code := send("GET", "/revisions/diff?from=1", &diff); code != http.StatusOK || diff.From != 1 || diff.To != 3 || len(diff.Changes) != 3 {
+		t.Errorf("GET diff from 1 returned %v: %v", code, diff)
+	}
+	if len(diff.Changes) == 3 && (diff.Changes[0].Field != "features.capital" || diff.Changes[1].Field != "features.targetCurrencies" ||
+		diff.Changes[2].Field != "features.temperature") {
+		t.Errorf("GET diff from 1 returned wrong changes: %v", diff.Changes)
+	}
+	if code := send("GET", "/revisions/diff?to=1", nil); code != http.StatusBadRequest {
+		t.Errorf("GET diff of first revision returned %v, want %v", code, http.StatusBadRequest)
+	}
+
+	// Restore the first revision, which becomes revision 4 and fires the CHANGE event
+	var restored registrationResponse
+	if code := send("POST", "/revisions/1/restore", &restored); code != http.StatusOK || restored.Revision != 4 || !restored.Features.Temperature {
+		t.Errorf("POST restore returned %v: %v", code, restored)
+	}
+	if current, _ := dashboards.Get(ctx, id); current.Revision != 4 || current.Features.Capital {
+		t.Errorf("Restored configuration is %v", current)
+	}
+	select {
+	case hook := <-changes:
+		if hook.Event != "CHANGE" || hook.Country != "NO" {
+			t.Errorf("Unexpected webhook invocation %v", hook)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("CHANGE webhook was not invoked")
+	}
+
+	if code := send("DELETE", "/revisions/1", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE revision returned %v, want %v", code, http.StatusMethodNotAllowed)
+	}
+}
+
+// Test that fields only set in the later revision are reported as changed from null, and the other way around
+func TestChangesBetween(t *testing.T) {
+	from := utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Revision: 1, Features: utils.Features_Get{Temperature: true}}
+	to := utils.Dashboard_Get{Country: "Norway", IsoCode: "NO", Revision: 2, Features: utils.Features_Get{Temperature: true,
+		Forecast: 7, Aggregation: map[string]string{"temperature": utils.AggregationMax}}}
+
+	changes, err := changesBetween(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].Field != "features.aggregation.temperature" || changes[0].From != nil ||
+		changes[0].To != utils.AggregationMax || changes[1].Field != "features.forecast" || changes[1].From != nil ||
+		changes[1].To != float64(7) {
+		t.Errorf("changesBetween(1, 2) = %+v", changes)
+	}
+
+	changes, err = changesBetween(to, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || changes[0].To != nil || changes[1].To != nil {
+		t.Errorf("changesBetween(2, 1) = %+v", changes)
+	}
+}
+
+// Store of configurations that keeps none of their revisions
+type noRevisions struct {
+	store.DashboardStore
+}
+
+// Revisions returns no revisions
+func (noRevisions) Revisions(context.Context, string) ([]utils.Dashboard_Get, error) {
+	return nil, nil
+}
+
+// Test that the diff of a configuration without revisions is not found
+func TestDiffRevisionsWithoutRevisions(t *testing.T) {
+	rr := httptest.NewRecorder()
+	diffRevisions(rr, httptest.NewRequest(http.MethodGet, utils.REGISTRATION_LINE_PATH+"abcde/revisions/diff", nil),
+		noRevisions{store.NewMemoryDashboards()}, "abcde")
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected %d, got %d: %s", http.StatusNotFound, rr.Code, rr.Body.String())
+	}
+}
@@ -1,21 +1,21 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
 	"encoding/json"
 	"log"
 	"net/http"
 	"time"
-
-	"cloud.google.com/go/firestore"
 )
 
 // StatusHandler handles the status endpoint
-func StatusHandler() func(w http.ResponseWriter, r *http.Request) {
+func StatusHandler(webhooks store.WebhookStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			statusGetRequest(w, r)
+			statusGetRequest(w, r, webhooks)
 		default:
 			http.Error(w, "Method not supported. Currently only GET is supported.", http.StatusNotImplemented)
 			return
@@ -49,7 +49,7 @@ func urlStatuses(urls []string) map[string]int {
 }
 
 // Function to handle GET requests to the status endpoint
-func statusGetRequest(w http.ResponseWriter, _ *http.Request) {
+func statusGetRequest(w http.ResponseWriter, r *http.Request, webhooks store.WebhookStore) {
 
 	// Time the server has been running since start
 	upTime := time.Since(startTime).Seconds()
@@ -60,24 +60,34 @@ func statusGetRequest(w http.ResponseWriter, _ *http.Request) {
 		utils.CURRENCY_API + "NOK",
 		utils.GEOCODING_API + "Oslo&count=1",
 		utils.FORECAST_API + "latitude=59.91&longitude=10.75&hourly=temperature_2m,precipitation&forecast_days=1",
+		utils.AIR_QUALITY_API + "latitude=59.91&longitude=10.75&current=european_aqi",
 		"https://console.firebase.google.com/project/prog2005-assignment2-ee93a/firestore/databases/-default-/data/~2Fwebhooks",
 	}
 
 	statusCodes := urlStatuses(myApis)
 
-	numOfWebhooks, _ := GetWebhookSize(func() ([]*firestore.DocumentSnapshot, error) {
-		return client.Collection(collectionWebhooks).Documents(ctx).GetAll()
-	})
+	numOfWebhooks, err := webhooks.Count(r.Context())
+	if err != nil {
+		log.Println("Error counting webhooks:", err)
+		numOfWebhooks = -1
+	}
 
 	//result struct with status codes for Apis. Contains version and uptime as well
 	statusStruct := utils.Status{
 		Countriesapi:   statusCodes[utils.COUNTRIES_API_NAME+"Norway"],
 		Meteoapi:       statusCodes[utils.GEOCODING_API+"Oslo&count=1"],
 		Currencyapi:    statusCodes[utils.CURRENCY_API+"NOK"],
+		Airqualityapi:  statusCodes[utils.AIR_QUALITY_API+"latitude=59.91&longitude=10.75&current=european_aqi"],
 		Notificationdb: statusCodes["https://console.firebase.google.com/project/prog2005-assignment2-ee93a/firestore/databases/-default-/data/~2Fwebhooks"],
 		Webhooks:       numOfWebhooks,
 		Version:        "v1",
 		Uptime:         upTime,
+
+		CoalescedRequests: upstream.DefaultClient.Coalesced(),
+		CircuitBreakers:   upstream.DefaultClient.Breakers(),
+	}
+	if utils.UpstreamCache != nil {
+		statusStruct.Cache = utils.UpstreamCache.Stats()
 	}
 	// Set the content-type to be json
 	w.Header().Add("content-type", "application/json")
@@ -93,21 +103,3 @@ func statusGetRequest(w http.ResponseWriter, _ *http.Request) {
 	w.Write(statusJSON)
 
 }
-
-// name of collection used for webhooks
-const collectionWebhooks = "webhooks"
-
-type FirestoreClient interface {
-	Collection(path string) *firestore.CollectionRef
-}
-
-type DocumentRetriever func() ([]*firestore.DocumentSnapshot, error)
-
-// Function for getting the number of webhooks in the database
-func GetWebhookSize(getDocuments DocumentRetriever) (int, error) {
-	webhooks, err := getDocuments()
-	if err != nil {
-		return -1, err
-	}
-	return len(webhooks), nil
-}
@@ -1,48 +1,24 @@
 package handler
 
 import (
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
-
-	"cloud.google.com/go/firestore"
+	"time"
 )
 
-// Test function for GetWebhookSize function
-func TestGetWebhookSize(t *testing.T) {
-	// Create mock documents
-	mockDocuments := []*firestore.DocumentSnapshot{}
-
-	// Test success
-	size, err := GetWebhookSize(func() ([]*firestore.DocumentSnapshot, error) {
-		return mockDocuments, nil
-	})
-	if err != nil {
-		t.Fatal(err)
-	}
-
-	// Check the size
-	if size != len(mockDocuments) {
-		t.Errorf("Expected size to be %v, got %v", len(mockDocuments), size)
-	}
-
-	// Test error
-	size, err = GetWebhookSize(func() ([]*firestore.DocumentSnapshot, error) {
-		return nil, errors.New("test error")
-	})
-
-}
-
 // Test fucntion for StatusHandler
 func TestStatusHandler(t *testing.T) {
 
 	// Initialize handler instance
-	handler := StatusHandler()
+	handler := StatusHandler(store.NewMemoryWebhooks())
 
 	// set up structure to be used for testing and close when finished testing
 	server := httptest.NewServer(http.HandlerFunc(handler))
@@ -59,7 +35,7 @@ func TestStatusHandler(t *testing.T) {
 
 	// Create a ResponseRecorder to record the response.
 	rr := httptest.NewRecorder()
-	handler2 := http.HandlerFunc(StatusHandler())
+	handler2 := http.HandlerFunc(StatusHandler(store.NewMemoryWebhooks()))
 
 	handler2.ServeHTTP(rr, req)
 
@@ -87,6 +63,7 @@ func TestStatusGetRequest(t *testing.T) {
 		Countriesapi:   http.StatusOK,
 		Meteoapi:       http.StatusOK,
 		Currencyapi:    http.StatusOK,
+		Airqualityapi:  http.StatusOK,
 		Notificationdb: http.StatusOK,
 		Webhooks:       5,
 		Version:        "v1",
@@ -147,3 +124,34 @@ func TestUrlStatuses(t *testing.T) {
 		t.Errorf("urlStatuses() returned status code %v for URL %v; want %v", statusCodes[urls[2]], urls[2], http.StatusServiceUnavailable)
 	}
 }
+
+// Test that the status reports the hits and misses of the cached upstream responses, and the circuit breakers of the
+// upstream hosts
+func TestStatusCache(t *testing.T) {
+	utils.UpstreamCache = utils.NewResponseCache(utils.UpstreamSources(time.Hour, time.Hour, time.Hour, time.Hour, time.Hour), nil)
+	upstream.DefaultClient.Cache = utils.UpstreamCache
+	defer func() {
+		utils.UpstreamCache = nil
+		upstream.DefaultClient.Cache = nil
+	}()
+
+	var countries []utils.CountryInfo
+	for i := 0; i < 2; i++ {
+		if err := upstream.GetJSON(context.Background(), utils.COUNTRIES_API_NAME+"Norway", &countries); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	StatusHandler(store.NewMemoryWebhooks())(rr, httptest.NewRequest(http.MethodGet, utils.STATUS_PATH, nil))
+	var status utils.Status
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Invalid status %s: %v", rr.Body.String(), err)
+	}
+	if status.Cache[utils.CountriesSource] != (utils.CacheStats{Hits: 1, Misses: 1}) {
+		t.Errorf("Unexpected cache stats %+v", status.Cache)
+	}
+	if breaker := status.CircuitBreakers["129.241.150.113:8080"]; breaker.State != upstream.BreakerClosed {
+		t.Errorf("Expected the breaker of the countries API to be closed, got %+v", status.CircuitBreakers)
+	}
+}
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A deleted dashboard configuration, in the format of a specific configuration with the time it was deleted
+type trashedRegistration struct {
+	registrationResponse
+	DeletedAt string `json:"deletedAt"`
+}
+
+// A deleted webhook, in the format of a specific webhook with the time it was deleted
+type trashedWebhook struct {
+	utils.WebhookGetResponse
+	DeletedAt string `json:"deletedAt"`
+}
+
+// Reports whether the path is in the trash of the collection path, and returns the path elements after "trash"
+func trashPath(path string, collectionPath string) ([]string, bool) {
+	elem := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, collectionPath), "/"), "/")
+	if elem[0] != "trash" {
+		return nil, false
+	}
+	return elem[1:], true
+}
+
+/*
+Handler for the trash of dashboard configurations:
+GET trash and POST trash/{id}/restore, of the configurations of the caller
+*/
+func dashboardTrashHandler(w http.ResponseWriter, r *http.Request, dashboards store.DashboardStore, audit store.AuditStore, elem []string) {
+	switch {
+	case len(elem) == 0 && r.Method == http.MethodGet:
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		trashed, err := dashboards.Trash(r.Context(), scope(r), opts)
+		if err != nil {
+			trashError(w, err, opts)
+			return
+		}
+
+		page := store.Page[trashedRegistration]{
+			Items:      make([]trashedRegistration, 0, len(trashed.Items)),
+			NextCursor: trashed.NextCursor,
+			Total:      trashed.Total,
+		}
+		for _, item := range trashed.Items {
+			page.Items = append(page.Items, trashedRegistration{
+				registrationResponse: toRegistrationResponse(item.Item),
+				DeletedAt:            item.DeletedAt.Format("20060102 15:04"),
+			})
+		}
+		writePage(w, page)
+	case len(elem) == 2 && elem[1] == "restore" && r.Method == http.MethodPost:
+		restored, err := dashboards.Restore(r.Context(), elem[0], scope(r))
+		if err != nil {
+			restoreError(w, err, elem[0])
+			return
+		}
+		recordChange(r, audit, store.AuditRestore, store.AuditRegistration, elem[0], nil, toRegistrationResponse(restored))
+		retrieveDocumentData(w, restored)
+	case len(elem) == 0 || (len(elem) == 2 && elem[1] == "restore"):
+		http.Error(w, "Method "+r.Method+" not supported for "+r.URL.Path, http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Unknown path "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+/*
+Handler for the trash of webhooks:
+GET trash and POST trash/{id}/restore, of the webhooks of the caller
+*/
+func webhookTrashHandler(w http.ResponseWriter, r *http.Request, webhooks store.WebhookStore, audit store.AuditStore, elem []string) {
+	switch {
+	case len(elem) == 0 && r.Method == http.MethodGet:
+		opts, err := listOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		trashed, err := webhooks.Trash(r.Context(), scope(r), opts)
+		if err != nil {
+			trashError(w, err, opts)
+			return
+		}
+
+		page := store.Page[trashedWebhook]{
+			Items:      make([]trashedWebhook, 0, len(trashed.Items)),
+			NextCursor: trashed.NextCursor,
+			Total:      trashed.Total,
+		}
+		for _, item := range trashed.Items {
+			page.Items = append(page.Items, trashedWebhook{
+				WebhookGetResponse: item.Item,
+				DeletedAt:          item.DeletedAt.Format("20060102 15:04"),
+			})
+		}
+		writePage(w, page)
+	case len(elem) == 2 && elem[1] == "restore" && r.Method == http.MethodPost:
+		restored, err := webhooks.Restore(r.Context(), elem[0], scope(r))
+		if err != nil {
+			restoreError(w, err, elem[0])
+			return
+		}
+		recordChange(r, audit, store.AuditRestore, store.AuditWebhook, elem[0], nil, restored)
+		retrieveWebHookData(w, restored)
+	case len(elem) == 0 || (len(elem) == 2 && elem[1] == "restore"):
+		http.Error(w, "Method "+r.Method+" not supported for "+r.URL.Path, http.StatusMethodNotAllowed)
+	default:
+		http.Error(w, "Unknown path "+r.URL.Path, http.StatusNotFound)
+	}
+}
+
+// Writes the error of listing the trash
+func trashError(w http.ResponseWriter, err error, opts store.ListOptions) {
+	if errors.Is(err, store.ErrInvalidCursor) {
+		http.Error(w, "Invalid cursor '"+opts.Cursor+"'. Use the nextCursor of the previous page", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Failed to iterate: %v", err)
+	http.Error(w, "Error retrieving documents", http.StatusInternalServerError)
+}
+
+// Writes the error of restoring a document from the trash
+func restoreError(w http.ResponseWriter, err error, id string) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "Document with ID "+id+" not found in the trash", http.StatusNotFound)
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, "Document with ID "+id+" already exists", http.StatusConflict)
+	default:
+		log.Println("Error restoring document:", err)
+		http.Error(w, "Error restoring document", http.StatusInternalServerError)
+	}
+}
+
+// PurgeTrash permanently removes the configurations and webhooks that have been in the trash for longer than
+// the retention, and the snapshots of the dashboards of the configurations. The PURGE event is triggered for every
+// removed configuration
+func PurgeTrash(ctx context.Context, dashboards store.DashboardStore, webhooks store.WebhookStore, snapshots store.SnapshotStore,
+	retention time.Duration) error {
+	deletedBefore := time.Now().Add(-retention)
+
+	purged, err := dashboards.Purge(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+	for _, dashboard := range purged {
+		dropSnapshot(ctx, snapshots, dashboard.ID)
+		err := invokeWebhooks(ctx, webhooks, "PURGE", dashboard.IsoCode, dashboard.Owner, func(hook utils.WebhookInvokeMessage) {
+			_ = invokeUrl(hook)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	hooks, err := webhooks.Purge(ctx, deletedBefore)
+	if err != nil {
+		return err
+	}
+
+	if len(purged) > 0 || len(hooks) > 0 {
+		log.Printf("Purged %d configurations and %d webhooks from the trash", len(purged), len(hooks))
+	}
+	return nil
+}
+
+// PurgeTrashEvery purges the trash at every interval, until the context is done
+func PurgeTrashEvery(ctx context.Context, dashboards store.DashboardStore, webhooks store.WebhookStore,
+	snapshots store.SnapshotStore, retention time.Duration, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := PurgeTrash(ctx, dashboards, webhooks, snapshots, retention); err != nil {
+				log.Println("Error purging the trash:", err)
+			}
+		}
+	}
+}
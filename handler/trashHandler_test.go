@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"assignment2/store"
+	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Test of deleting a configuration to the trash, restoring it, and purging it
+func TestDashboardTrash(t *testing.T) {
+	ctx := context.Background()
+	dashboards := store.NewMemoryDashboards()
+	webhooks := store.NewMemoryWebhooks()
+	handler := RegistrationHandler(dashboards, webhooks, store.NewMemoryAuditLog(), store.NewMemorySnapshots())
+
+	// Client service receiving the DELETE and PURGE events
+	events := make(chan utils.WebhookInvokeMessage, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var hook utils.WebhookInvokeMessage
+		if err := json.NewDecoder(r.Body).Decode(&hook); err == nil {
+			events <- hook
+		}
+	}))
+	defer receiver.Close()
+	for _, event := range []string{"DELETE", "PURGE"} {
+		if _, err := webhooks.Create(ctx, utils.WebhookGetResponse{Url: receiver.URL, Country: "NO", Event: event}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectEvent := func(event string) {
+		select {
+		case hook := <-events:
+			if hook.Event != event {
+				t.Errorf("Expected %s event, got %v", event, hook)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s event was not received", event)
+		}
+	}
+
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sends a request to the handler, and decodes the JSON response into value if the status code is 200
+	send := func(method string, path string, value interface{}) int {
+		req, err := http.NewRequest(method, utils.REGISTRATION_LINE_PATH+path, nil)
+		if err != nil {
+			t.Fatalf("http.NewRequest() returned error: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code == http.StatusOK && value != nil {
+			if err := json.Unmarshal(rr.Body.Bytes(), value); err != nil {
+				t.Fatalf("%s %s returned invalid JSON %v: %v", method, path, rr.Body.String(), err)
+			}
+		}
+		return rr.Code
+	}
+
+	// The deleted configuration is listed in the trash, and the DELETE event is triggered
+	if code := send(http.MethodDelete, id, nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE returned %d", code)
+	}
+	expectEvent("DELETE")
+	var trashed store.Page[trashedRegistration]
+	if code := send(http.MethodGet, "trash", &trashed); code != http.StatusOK || len(trashed.Items) != 1 ||
+		trashed.Items[0].ID != id || trashed.Items[0].DeletedAt == "" {
+		t.Fatalf("GET trash returned %d with %v", code, trashed)
+	}
+
+	// Restoring brings the configuration back
+	var restored registrationResponse
+	if code := send(http.MethodPost, "trash/"+id+"/restore", &restored); code != http.StatusOK || restored.ID != id {
+		t.Errorf("Restore returned %d with %v", code, restored)
+	}
+	if _, err := dashboards.Get(ctx, id); err != nil {
+		t.Errorf("Restored configuration not found: %v", err)
+	}
+	if code := send(http.MethodPost, "trash/"+id+"/restore", nil); code != http.StatusNotFound {
+		t.Errorf("Restoring a configuration not in the trash returned %d, want %d", code, http.StatusNotFound)
+	}
+	if code := send(http.MethodDelete, "trash", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE trash returned %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+
+	// Configurations are purged after the retention, which triggers the PURGE event
+	if code := send(http.MethodDelete, id, nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE returned %d", code)
+	}
+	expectEvent("DELETE")
+	if err := PurgeTrash(ctx, dashboards, webhooks, store.NewMemorySnapshots(), time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if trashed, _ := dashboards.Trash(ctx, "", store.ListOptions{}); trashed.Total != 1 {
+		t.Errorf("Configuration purged before the retention: %v", trashed)
+	}
+	if err := PurgeTrash(ctx, dashboards, webhooks, store.NewMemorySnapshots(), -time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent("PURGE")
+	if trashed, _ := dashboards.Trash(ctx, "", store.ListOptions{}); trashed.Total != 0 {
+		t.Errorf("Configuration not purged after the retention: %v", trashed)
+	}
+}
+
+// Test of deleting a webhook to the trash and restoring it
+func TestWebhookTrash(t *testing.T) {
+	ctx := context.Background()
+	webhooks := store.NewMemoryWebhooks()
+	handler := NotificationHandler(webhooks, store.NewMemoryAuditLog())
+
+	id, err := webhooks.Create(ctx, utils.WebhookGetResponse{Url: "http://localhost:8080/", Event: "INVOKE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sends a request to the handler, and returns the response
+	send := func(method string, path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(method, utils.NOTIFICATION_PATH+path, nil))
+		return rr
+	}
+
+	if rr := send(http.MethodDelete, id); rr.Code != http.StatusNoContent {
+		t.Fatalf("DELETE returned %d", rr.Code)
+	}
+	var trashed store.Page[trashedWebhook]
+	rr := send(http.MethodGet, "trash")
+	if err := json.Unmarshal(rr.Body.Bytes(), &trashed); err != nil || len(trashed.Items) != 1 || trashed.Items[0].Id != id {
+		t.Fatalf("GET trash returned %d with %v", rr.Code, rr.Body.String())
+	}
+
+	if rr := send(http.MethodPost, "trash/"+id+"/restore"); rr.Code != http.StatusOK {
+		t.Errorf("Restore returned %d", rr.Code)
+	}
+	if _, err := webhooks.Get(ctx, id); err != nil {
+		t.Errorf("Restored webhook not found: %v", err)
+	}
+	if rr := send(http.MethodGet, "trash/"+id); rr.Code != http.StatusNotFound {
+		t.Errorf("GET trash/{id} returned %d, want %d", rr.Code, http.StatusNotFound)
+	}
+}
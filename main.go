@@ -2,53 +2,332 @@ package main
 
 import (
 	"assignment2/handler"
+	"assignment2/store"
+	"assignment2/upstream"
 	"assignment2/utils"
 	"context"
+	"errors"
 	"log"
+	"math"
 	"net/http"
 	"os"
-
-	"cloud.google.com/go/firestore"
-	firebase "firebase.google.com/go"
-	"google.golang.org/api/option"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 )
 
-// Firebase context and client used by Firestore functions throughout the program.
-var ctx context.Context
-var client *firestore.Client
+// Reads a duration like "720h" from the environment variable, or returns def if it is not set
+func durationEnv(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return 0, errors.New("Invalid $" + name + " " + value + ". Expected a positive duration like 720h")
+	}
+	return duration, nil
+}
+
+// Reads a number like "2.5" from the environment variable, or returns def if it is not set. Zero is allowed
+func numberEnv(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil || number < 0 {
+		return 0, errors.New("Invalid $" + name + " " + value + ". Expected a number of at least 0")
+	}
+	return number, nil
+}
+
+// Limits of every client, from $RATE_LIMIT (requests per second, default 5), $RATE_BURST (default 20),
+// $RENDER_QUOTA (dashboards per day, default 1000) and $REGISTRATION_QUOTA (registrations per day, default 100).
+// With $IP_RATE_LIMIT (default 0, off) and $IP_RATE_BURST (default 50), every IP address is also limited before it is
+// authenticated. Zero turns a limit off
+func rateLimitConfig() (handler.RateLimitConfig, error) {
+	var config handler.RateLimitConfig
+	var err error
+	if config.Rate, err = numberEnv("RATE_LIMIT", 5); err != nil {
+		return config, err
+	}
+	if config.AddressRate, err = numberEnv("IP_RATE_LIMIT", 0); err != nil {
+		return config, err
+	}
+
+	// The rest are whole numbers
+	limits := []struct {
+		name  string
+		def   int
+		value *int
+	}{
+		{"RATE_BURST", 20, &config.Burst},
+		{"RENDER_QUOTA", 1000, &config.RenderQuota},
+		{"REGISTRATION_QUOTA", 100, &config.RegistrationQuota},
+		{"IP_RATE_BURST", 50, &config.AddressBurst},
+	}
+	for _, limit := range limits {
+		number, err := numberEnv(limit.name, float64(limit.def))
+		if err != nil || number != math.Trunc(number) {
+			return config, errors.New("Invalid $" + limit.name + " " + os.Getenv(limit.name) + ". Expected a whole number of at least 0")
+		}
+		*limit.value = int(number)
+	}
+	return config, nil
+}
+
+// Cache of the responses of the upstream APIs, which are kept for $CACHE_TTL_COUNTRIES (default 72h),
+// $CACHE_TTL_GEOCODING (default 72h), $CACHE_TTL_CURRENCY (default 6h), $CACHE_TTL_FORECAST (default 15m)
+// and $CACHE_TTL_AIR_QUALITY (default 15m).
+// Responses are kept in the shared cache as well, unless it is nil
+func upstreamCache(shared utils.SharedCache) (*utils.ResponseCache, error) {
+	ttls := []struct {
+		name  string
+		def   time.Duration
+		value time.Duration
+	}{
+		{name: "CACHE_TTL_COUNTRIES", def: utils.DefaultCountriesTTL},
+		{name: "CACHE_TTL_GEOCODING", def: utils.DefaultGeocodingTTL},
+		{name: "CACHE_TTL_CURRENCY", def: utils.DefaultCurrencyTTL},
+		{name: "CACHE_TTL_FORECAST", def: utils.DefaultForecastTTL},
+		{name: "CACHE_TTL_AIR_QUALITY", def: utils.DefaultAirQualityTTL},
+	}
+	for i := range ttls {
+		ttl, err := durationEnv(ttls[i].name, ttls[i].def)
+		if err != nil {
+			return nil, err
+		}
+		ttls[i].value = ttl
+	}
+
+	sources := utils.UpstreamSources(ttls[0].value, ttls[1].value, ttls[2].value, ttls[3].value, ttls[4].value)
+	return utils.NewResponseCache(sources, shared), nil
+}
+
+// Verifier of the bearer tokens issued by the gateway, if $JWT_JWKS is set to the file or URL of its keys.
+// The tokens must have the issuer $JWT_ISSUER and audience $JWT_AUDIENCE
+func tokenVerifier() (*handler.TokenVerifier, error) {
+	source := os.Getenv("JWT_JWKS")
+	if source == "" {
+		return nil, nil
+	}
+
+	issuer, audience := os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE")
+	if issuer == "" || audience == "" {
+		return nil, errors.New("$JWT_ISSUER and $JWT_AUDIENCE must be set with $JWT_JWKS")
+	}
+
+	keys, err := handler.LoadJWKS(source)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := handler.NewTokenVerifier(keys, issuer, audience)
+	if claim := os.Getenv("JWT_OWNER_CLAIM"); claim != "" {
+		tokens.OwnerClaim = claim
+	}
+	if claim := os.Getenv("JWT_ROLES_CLAIM"); claim != "" {
+		tokens.RolesClaim = claim
+	}
+	log.Println("Accepting bearer tokens from " + issuer + " signed with the keys of " + source)
+	return tokens, nil
+}
+
+// Registers the handlers of all endpoints. Every request is checked against the limit of its IP address, then against
+// the roles of its API key or bearer token, where adminKey is accepted as the key of an admin, and then against the
+// limits of its client.
+// Bearer tokens are only accepted if tokens is not nil, requests are not limited if limiter is nil, and stale snapshots
+// are not refreshed if refresher is nil
+func routes(dashboards store.DashboardStore, webhooks store.WebhookStore, keys store.APIKeyStore, audit store.AuditStore,
+	snapshots store.SnapshotStore, refresher *handler.SnapshotRefresher, adminKey string, tokens *handler.TokenVerifier,
+	limiter *handler.RateLimiter) *http.ServeMux {
+	mux := http.NewServeMux()
+
+	// Wraps the handler, so it is only reached by callers with the role of the route, within their limits.
+	// Requests that fail authentication count against the limit of their address
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		if limiter == nil {
+			return handler.Authorize(keys, adminKey, tokens, next)
+		}
+		return limiter.LimitAddress(handler.Authorize(keys, adminKey, tokens, limiter.Limit(next)))
+	}
+
+	mux.HandleFunc(utils.DEFAULT_PATH, authorized(handler.DefaultHandler))
+	mux.HandleFunc(utils.REGISTRATION_PATH, authorized(handler.RegistrationHandler(dashboards, webhooks, audit, snapshots)))
+	mux.HandleFunc(utils.REGISTRATION_LINE_PATH, authorized(handler.RegistrationHandler(dashboards, webhooks, audit, snapshots)))
+
+	mux.HandleFunc(utils.DASHBOARD_PATH, authorized(handler.DashboardHandler(dashboards, webhooks, snapshots, refresher)))
+	mux.HandleFunc(utils.STATUS_PATH, authorized(handler.StatusHandler(webhooks)))
+	mux.HandleFunc(utils.NOTIFICATION_PATH, authorized(handler.NotificationHandler(webhooks, audit)))
+	mux.HandleFunc(utils.API_KEY_PATH, authorized(handler.APIKeyHandler(keys)))
+	mux.HandleFunc(utils.AUDIT_PATH, authorized(handler.AuditHandler(audit)))
+
+	return mux
+}
 
 func main() {
 
-	// Firebase initialisation
-	ctx = context.Background()
+	// Stores used for dashboard configurations, webhooks, API keys, the audit log and the snapshots of dashboards
+	var dashboards store.DashboardStore
+	var webhooks store.WebhookStore
+	var keys store.APIKeyStore
+	var audit store.AuditStore
+	var snapshots store.SnapshotStore
+
+	// Store of the cached responses of the upstream APIs shared by every instance, if the backend has one
+	var sharedCache utils.SharedCache
 
-	// Loads credential file from firebase
-	sa := option.WithCredentialsFile("firestore_key.json")
-	app, err := firebase.NewApp(ctx, nil, sa)
+	// Selects where configurations and webhooks are stored. Default: firestore
+	backend := os.Getenv("STORAGE_BACKEND")
+
+	switch backend {
+	case "memory":
+		log.Println("Using in-memory storage, data is lost when the service stops")
+		dashboards = store.NewMemoryDashboards()
+		webhooks = store.NewMemoryWebhooks()
+		keys = store.NewMemoryAPIKeys()
+		audit = store.NewMemoryAuditLog()
+		snapshots = store.NewMemorySnapshots()
+	case "bolt":
+		// File the database is kept in
+		path := os.Getenv("STORAGE_PATH")
+		if path == "" {
+			log.Println("$STORAGE_PATH has not been set. Default: dashboard.db")
+			path = "dashboard.db"
+		}
+
+		db, err := store.OpenBolt(path)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Close down database at the end of the function
+		defer func() {
+			errClose := db.Close()
+			if errClose != nil {
+				log.Fatal("Closing of the database failed. Error:", errClose)
+			}
+		}()
+
+		log.Println("Using database file " + path)
+		dashboards = store.NewBoltDashboards(db)
+		webhooks = store.NewBoltWebhooks(db)
+		keys = store.NewBoltAPIKeys(db)
+		audit = store.NewBoltAuditLog(db)
+		snapshots = store.NewBoltSnapshots(db)
+		sharedCache = store.NewBoltCache(db)
+	case "", "firestore":
+		// Firebase initialisation
+		ctx := context.Background()
+
+		// Project to use, by default the project of the credential file (or a demo project on the emulator)
+		projectID := os.Getenv("FIRESTORE_PROJECT_ID")
+
+		if emulator := os.Getenv("FIRESTORE_EMULATOR_HOST"); emulator != "" {
+			log.Println("Using Firestore emulator at " + emulator)
+		}
+
+		//Instantiate client
+		client, err := store.NewFirestoreClient(ctx, projectID, "firestore_key.json")
+
+		// Check whether there is an error when connecting to Firestore
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Close down client at the end of the function
+		defer func() {
+			errClose := client.Close()
+			if errClose != nil {
+				log.Fatal("Closing of the Firebase client failed. Error:", errClose)
+			}
+		}()
+
+		dashboards = store.NewFirestoreDashboards(client)
+		webhooks = store.NewFirestoreWebhooks(client)
+		keys = store.NewFirestoreAPIKeys(client)
+		audit = store.NewFirestoreAuditLog(client)
+		snapshots = store.NewFirestoreSnapshots(client)
+		sharedCache = store.NewFirestoreCache(client)
+	default:
+		log.Println("Unknown $STORAGE_BACKEND " + backend + ". Supported: firestore, bolt, memory")
+		return
+	}
+
+	// Strategy for the IDs of new configurations, webhooks and API keys, shared by the stores, and the length of their
+	// random characters. Default: random, of 5 characters
+	idLength, err := numberEnv("ID_LENGTH", store.DefaultIDLength)
+	if err != nil || idLength != math.Trunc(idLength) {
+		log.Println("Invalid $ID_LENGTH " + os.Getenv("ID_LENGTH") + ". Expected a whole number")
+		return
+	}
+	ids, err := store.NewIDGenerator(os.Getenv("ID_STRATEGY"), int(idLength))
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	dashboards.SetIDGenerator(ids)
+	webhooks.SetIDGenerator(ids)
+	keys.SetIDGenerator(ids)
+	audit.SetIDGenerator(ids)
+
+	// Responses of the upstream APIs are cached in memory, and with $SHARED_CACHE=true in the storage backend as well
+	if os.Getenv("SHARED_CACHE") != "true" {
+		sharedCache = nil
+	} else if sharedCache == nil {
+		log.Println("The memory backend has no shared cache. Responses are only cached in memory")
+	}
+	utils.UpstreamCache, err = upstreamCache(sharedCache)
 	if err != nil {
 		log.Println(err)
 		return
 	}
+	upstream.DefaultClient.Cache = utils.UpstreamCache
 
-	//Instantiate client
-	client, err = app.Firestore(ctx)
+	// Key of the admin, which can issue the API keys of other clients
+	adminKey := os.Getenv("ADMIN_API_KEY")
+	if adminKey == "" {
+		log.Println("$ADMIN_API_KEY has not been set. Only API keys issued earlier can be used")
+	}
 
-	// Check whether there is an error when connecting to Firestore
+	// Bearer tokens of the gateway, accepted besides API keys
+	tokens, err := tokenVerifier()
 	if err != nil {
 		log.Println(err)
 		return
 	}
 
-	// Close down client at the end of the function
-	defer func() {
-		errClose := client.Close()
-		if errClose != nil {
-			log.Fatal("Closing of the Firebase client failed. Error:", errClose)
-		}
-	}()
+	// Rate limits and daily quotas of every client
+	limits, err := rateLimitConfig()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	// How long deleted configurations and webhooks are kept in the trash, and how often it is purged.
+	// Default: 30 days, every hour
+	retention, err := durationEnv("TRASH_RETENTION", store.DefaultRetention)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	purgeInterval, err := durationEnv("TRASH_PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-	// Set Firestore client in handler package
-	handler.SetFirestoreClient(ctx, client)
+	// Cancelled when the service is asked to stop, which stops the purges and the refreshes of snapshots
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go handler.PurgeTrashEvery(ctx, dashboards, webhooks, snapshots, retention, purgeInterval)
+	refresher := handler.NewSnapshotRefresher(ctx)
 
 	port := os.Getenv("PORT")
 
@@ -60,20 +339,23 @@ func main() {
 
 	addr := ":" + port
 
-	http.HandleFunc(utils.DEFAULT_PATH, handler.DefaultHandler)
-	http.HandleFunc(utils.REGISTRATION_PATH, handler.RegistrationHandler())
-	http.HandleFunc(utils.REGISTRATION_LINE_PATH, handler.RegistrationHandler())
+	http.Handle("/", routes(dashboards, webhooks, keys, audit, snapshots, refresher, adminKey, tokens, handler.NewRateLimiter(limits)))
 
-	http.HandleFunc(utils.DASHBOARD_PATH, handler.DashboardHandler())
-	http.HandleFunc(utils.STATUS_PATH, handler.StatusHandler())
-	http.HandleFunc(utils.NOTIFICATION_PATH, handler.NotificationHandler())
+	// Start http Server, which finishes the requests it is serving when the service is asked to stop
+	server := &http.Server{Addr: addr}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("Error shutting down the server:", err)
+		}
+	}()
 
-	// Start http Server
 	log.Println("Starting server on port " + port + "...")
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-
-	log.Printf("Firestore REST service listening on %s ...\n", addr)
-	if errSrv := http.ListenAndServe(addr, nil); errSrv != nil {
-		panic(errSrv)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
 	}
+	refresher.Wait()
+	log.Println("Server stopped")
 }
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"assignment2/handler"
+	"assignment2/store"
+	"assignment2/stub"
+	"assignment2/utils"
+	"context"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Answers requests for the third-party APIs with canned data while the tests run
+func TestMain(m *testing.M) {
+	restore := stub.Install(utils.COUNTRIES_API, utils.CURRENCY_API, utils.GEOCODING_API, utils.FORECAST_API, utils.AIR_QUALITY_API)
+	code := m.Run()
+	restore()
+	os.Exit(code)
+}
+
+// Test of the registration -> dashboard -> webhook flow using in-memory storage
+func TestFlowMemory(t *testing.T) {
+	testFlow(t, store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemoryAPIKeys(), store.NewMemoryAuditLog())
+}
+
+// Test of the registration -> dashboard -> webhook flow against the Firestore emulator.
+// Run the emulator with "gcloud emulators firestore start" and set $FIRESTORE_EMULATOR_HOST to enable it
+func TestFlowFirestoreEmulator(t *testing.T) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("$FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	ctx := context.Background()
+	client, err := store.NewFirestoreClient(ctx, os.Getenv("FIRESTORE_PROJECT_ID"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Start with empty collections
+	for _, collection := range []string{store.DashboardCollection, store.WebhookCollection, store.APIKeyCollection,
+		store.AuditCollection} {
+		docs, err := client.Collection(collection).Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	testFlow(t, store.NewFirestoreDashboards(client), store.NewFirestoreWebhooks(client), store.NewFirestoreAPIKeys(client),
+		store.NewFirestoreAuditLog(client))
+}
+
+// Key of the admin while the tests run
+const testAdminKey = "test-admin-key"
+
+// Sends a request with an optional JSON body and API key to the service, and returns the response and its body
+func send(t *testing.T, key string, method string, url string, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(handler.APIKeyHeader, key)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+// Test that requests with unknown API keys are limited per address, before they are authenticated
+func TestRoutesLimitAddress(t *testing.T) {
+	limiter := handler.NewRateLimiter(handler.RateLimitConfig{AddressRate: 0.001, AddressBurst: 3})
+	service := httptest.NewServer(routes(store.NewMemoryDashboards(), store.NewMemoryWebhooks(), store.NewMemoryAPIKeys(),
+		store.NewMemoryAuditLog(), store.NewMemorySnapshots(), nil, testAdminKey, nil, limiter))
+	defer service.Close()
+
+	for i := 0; i < 3; i++ {
+		if res, data := send(t, "guessed.key", http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH, ""); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Guess %d returned %v: %s", i, res.StatusCode, data)
+		}
+	}
+	res, data := send(t, "guessed.key", http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH, "")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Errorf("Guess over the limit returned %v: %s", res.StatusCode, data)
+	}
+}
+
+// Waits for the next webhook invocation and checks its event and country
+func expectInvocation(t *testing.T, invocations chan utils.WebhookInvokeMessage, event string, country string) {
+	select {
+	case hook := <-invocations:
+		if hook.Event != event || hook.Country != country {
+			t.Errorf("Expected %s webhook for '%s', got %v", event, country, hook)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s webhook was not invoked", event)
+	}
+}
+
+// Registers webhooks and a dashboard, retrieves the populated dashboard and deletes it,
+// checking the responses and the webhook invocations on the way
+func testFlow(t *testing.T, dashboards store.DashboardStore, webhooks store.WebhookStore, keys store.APIKeyStore,
+	audit store.AuditStore) {
+	// Client service receiving the webhook invocations
+	invocations := make(chan utils.WebhookInvokeMessage, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			return
+		}
+		var hook utils.WebhookInvokeMessage
+		if err := json.NewDecoder(r.Body).Decode(&hook); err != nil {
+			t.Errorf("Invalid webhook body: %v", err)
+			return
+		}
+		invocations <- hook
+	}))
+	defer receiver.Close()
+
+	service := httptest.NewServer(routes(dashboards, webhooks, keys, audit, store.NewMemorySnapshots(), nil, testAdminKey, nil, nil))
+	defer service.Close()
+
+	// Requests without a valid API key are refused
+	for _, key := range []string{"", "unknown.key"} {
+		if res, data := send(t, key, http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH, ""); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Listing with API key '%s' returned %v: %s", key, res.StatusCode, data)
+		}
+	}
+
+	// The admin issues the keys of the client, which manages its own webhooks, and of another client
+	issue := func(owner string, role string) string {
+		res, data := send(t, testAdminKey, http.MethodPost, service.URL+utils.API_KEY_PATH,
+			`{"owner": "`+owner+`", "roles": ["`+role+`"]}`)
+		var issued struct {
+			Key string `json:"key"`
+		}
+		if res.StatusCode != http.StatusCreated || json.Unmarshal([]byte(data), &issued) != nil || issued.Key == "" {
+			t.Fatalf("Issuing API key returned %v: %s", res.StatusCode, data)
+		}
+		return issued.Key
+	}
+	key := issue("client", "admin")
+	otherKey := issue("other", "editor")
+
+	// Register webhooks for Norway, and for deletes in all countries
+	for _, body := range []string{
+		`{"url": "` + receiver.URL + `/", "country": "no", "event": "REGISTER"}`,
+		`{"url": "` + receiver.URL + `/", "country": "NO", "event": "INVOKE"}`,
+		`{"url": "` + receiver.URL + `/", "event": "DELETE"}`,
+	} {
+		res, data := send(t, key, http.MethodPost, service.URL+utils.NOTIFICATION_PATH, body)
+		if res.StatusCode != http.StatusOK || !strings.Contains(data, `"id"`) {
+			t.Fatalf("Registering webhook returned %v: %s", res.StatusCode, data)
+		}
+	}
+
+	// Register a dashboard
+	res, data := send(t, key, http.MethodPost, service.URL+utils.REGISTRATION_LINE_PATH, `{
+		"country": "Norway",
+		"isoCode": "NO",
+		"features": {
+			"temperature": true,
+			"precipitation": true,
+			"capital": true,
+			"coordinates": true,
+			"population": true,
+			"area": false,
+			"targetCurrencies": ["EUR", "usd", "XXX"]
+		}
+	}`)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Registering dashboard returned %v: %s", res.StatusCode, data)
+	}
+	var registered struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal([]byte(data), &registered); err != nil || registered.ID == "" {
+		t.Fatalf("Registering dashboard returned no id: %s", data)
+	}
+	expectInvocation(t, invocations, "REGISTER", "NO")
+
+	// The configuration is not visible to other clients
+	res, _ = send(t, otherKey, http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH+registered.ID, "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Configuration of another client returned %v, want %v", res.StatusCode, http.StatusNotFound)
+	}
+	res, data = send(t, otherKey, http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH, "")
+	if res.StatusCode != http.StatusOK || !strings.Contains(data, `"total":0`) {
+		t.Errorf("Listing of another client returned %v: %s", res.StatusCode, data)
+	}
+
+	// The stored configuration has the valid currencies only
+	res, data = send(t, key, http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH+registered.ID, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Getting configuration returned %v: %s", res.StatusCode, data)
+	}
+	if !strings.Contains(data, `"targetCurrencies":["EUR","USD"]`) {
+		t.Errorf("Expected currencies EUR and USD, got %s", data)
+	}
+
+	// Retrieve the populated dashboard
+	res, data = send(t, key, http.MethodGet, service.URL+utils.DASHBOARD_PATH+registered.ID, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("Getting dashboard returned %v: %s", res.StatusCode, data)
+	}
+	var dashboard struct {
+		Country  string `json:"country"`
+		Features struct {
+			Temperature float64 `json:"temperature"`
+			Capital     string  `json:"capital"`
+			Coordinates struct {
+				Latitude  float64 `json:"latitude"`
+				Longitude float64 `json:"longitude"`
+			} `json:"coordinates"`
+			Population       int                `json:"population"`
+			Area             float64            `json:"area"`
+			TargetCurrencies map[string]float64 `json:"targetCurrencies"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal([]byte(data), &dashboard); err != nil {
+		t.Fatalf("Invalid dashboard %s: %v", data, err)
+	}
+	features := dashboard.Features
+	if dashboard.Country != "Norway" || features.Capital != "Oslo" || features.Population != 5379475 || features.Area != 0 {
+		t.Errorf("Unexpected country data in dashboard %s", data)
+	}
+	if features.Temperature != 11.5 || math.Abs(features.Coordinates.Latitude-59.91) > 0.001 {
+		t.Errorf("Unexpected weather or coordinates in dashboard %s", data)
+	}
+	if math.Abs(features.TargetCurrencies["EUR"]-0.92/10.5) > 0.0001 || len(features.TargetCurrencies) != 2 {
+		t.Errorf("Unexpected exchange rates in dashboard %s", data)
+	}
+	expectInvocation(t, invocations, "INVOKE", "NO")
+
+	// Delete the dashboard
+	res, data = send(t, key, http.MethodDelete, service.URL+utils.REGISTRATION_LINE_PATH+registered.ID, "")
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("Deleting dashboard returned %v: %s", res.StatusCode, data)
+	}
+	expectInvocation(t, invocations, "DELETE", "")
+
+	res, _ = send(t, key, http.MethodGet, service.URL+utils.REGISTRATION_LINE_PATH+registered.ID, "")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("Deleted configuration returned %v, want %v", res.StatusCode, http.StatusNotFound)
+	}
+
+	// The registration and the delete are in the audit log, newest first
+	res, data = send(t, key, http.MethodGet, service.URL+utils.AUDIT_PATH+"?resourceId="+registered.ID, "")
+	var records struct {
+		Items []utils.AuditRecord `json:"items"`
+	}
+	if res.StatusCode != http.StatusOK || json.Unmarshal([]byte(data), &records) != nil || len(records.Items) != 2 {
+		t.Fatalf("Audit log of the configuration returned %v: %s", res.StatusCode, data)
+	}
+	if records.Items[0].Action != store.AuditDelete || records.Items[1].Action != store.AuditCreate || records.Items[1].Actor != "client" {
+		t.Errorf("Unexpected audit log of the configuration %s", data)
+	}
+}
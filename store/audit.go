@@ -0,0 +1,92 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"sort"
+	"time"
+)
+
+// name of collection used for the audit log
+const AuditCollection = "audit"
+
+// Actions recorded in the audit log
+const (
+	AuditCreate  = "create"
+	AuditUpdate  = "update"
+	AuditDelete  = "delete"
+	AuditRestore = "restore"
+)
+
+// Types of the resources in the audit log
+const (
+	AuditRegistration = "registration"
+	AuditWebhook      = "webhook"
+)
+
+// AuditStore persists the audit log of the changes made to registrations and webhooks
+type AuditStore interface {
+	// Record stores the record under a newly generated unique ID, which is returned
+	Record(ctx context.Context, record utils.AuditRecord) (string, error)
+	// List returns a page of the records selected by the query, newest first
+	List(ctx context.Context, query AuditQuery, opts ListOptions) (Page[utils.AuditRecord], error)
+	// SetIDGenerator replaces the generator of the IDs of new records
+	SetIDGenerator(ids utils.IDGenerator)
+}
+
+// AuditQuery selects the records of a listing. Empty fields select all records
+type AuditQuery struct {
+	// Records of resources of this type
+	ResourceType string
+	// Records of the resource with this ID
+	ResourceID string
+	// Records of changes made by this actor
+	Actor string
+	// Records made at or after this time
+	Since time.Time
+	// Records made before this time
+	Until time.Time
+}
+
+// Reports whether the record is selected by the query
+func (q AuditQuery) matches(record utils.AuditRecord) bool {
+	if q.ResourceType != "" && record.ResourceType != q.ResourceType {
+		return false
+	}
+	if q.ResourceID != "" && record.ResourceID != q.ResourceID {
+		return false
+	}
+	if q.Actor != "" && record.Actor != q.Actor {
+		return false
+	}
+	if !q.Since.IsZero() && record.Time.Before(q.Since) {
+		return false
+	}
+	return q.Until.IsZero() || record.Time.Before(q.Until)
+}
+
+// Position of a record in the audit log, which is ordered by time
+func auditPosition(record utils.AuditRecord) position {
+	return position{Value: record.Time.UTC().Format(cursorTimeLayout), ID: record.ID}
+}
+
+// Stores keep the time of records the way Firestore does: in UTC, with microsecond precision
+func storedAuditRecord(record utils.AuditRecord) utils.AuditRecord {
+	record.Time = record.Time.UTC().Truncate(time.Microsecond)
+	return record
+}
+
+// Selects the records of the query, and pages them newest first, for stores that can not query
+func auditPage(all []utils.AuditRecord, query AuditQuery, opts ListOptions) (Page[utils.AuditRecord], error) {
+	records := make([]utils.AuditRecord, 0, len(all))
+	for _, record := range all {
+		if query.matches(record) {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return auditPosition(records[i]).before(auditPosition(records[j]), true)
+	})
+	return pageOfSorted(records, opts, auditPosition, true)
+}
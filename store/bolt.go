@@ -0,0 +1,712 @@
+package store
+
+import (
+	"assignment2/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BoltDashboards is a DashboardStore backed by a bucket in an embedded BoltDB file
+type BoltDashboards struct {
+	idSource
+	db *bolt.DB
+}
+
+// BoltWebhooks is a WebhookStore backed by a bucket in an embedded BoltDB file
+type BoltWebhooks struct {
+	idSource
+	db *bolt.DB
+}
+
+// BoltAPIKeys is an APIKeyStore backed by a bucket in an embedded BoltDB file
+type BoltAPIKeys struct {
+	idSource
+	db *bolt.DB
+}
+
+// BoltAuditLog is an AuditStore backed by a bucket in an embedded BoltDB file
+type BoltAuditLog struct {
+	idSource
+	db *bolt.DB
+}
+
+// BoltCache is a utils.SharedCache backed by a bucket in an embedded BoltDB file
+type BoltCache struct {
+	db *bolt.DB
+}
+
+// BoltSnapshots is a SnapshotStore backed by a bucket in an embedded BoltDB file
+type BoltSnapshots struct {
+	db *bolt.DB
+}
+
+// Opens (or creates) the database file at path, with a bucket for each collection
+func OpenBolt(path string) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, collection := range []string{DashboardCollection, WebhookCollection, RevisionCollection,
+			DashboardTrashCollection, WebhookTrashCollection, APIKeyCollection, AuditCollection, CacheCollection,
+			SnapshotCollection} {
+			if _, err := tx.CreateBucketIfNotExists([]byte(collection)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Creates a DashboardStore using the dashboard bucket of the given database
+func NewBoltDashboards(db *bolt.DB) *BoltDashboards {
+	return &BoltDashboards{db: db}
+}
+
+// Creates a WebhookStore using the webhook bucket of the given database
+func NewBoltWebhooks(db *bolt.DB) *BoltWebhooks {
+	return &BoltWebhooks{db: db}
+}
+
+// Creates an APIKeyStore using the key bucket of the given database
+func NewBoltAPIKeys(db *bolt.DB) *BoltAPIKeys {
+	return &BoltAPIKeys{db: db}
+}
+
+// Creates an AuditStore using the audit bucket of the given database
+func NewBoltAuditLog(db *bolt.DB) *BoltAuditLog {
+	return &BoltAuditLog{db: db}
+}
+
+// Creates a SharedCache using the cache bucket of the given database
+func NewBoltCache(db *bolt.DB) *BoltCache {
+	return &BoltCache{db: db}
+}
+
+// Creates a SnapshotStore using the snapshot bucket of the given database
+func NewBoltSnapshots(db *bolt.DB) *BoltSnapshots {
+	return &BoltSnapshots{db: db}
+}
+
+// Reads and decodes the value stored under id in the collection
+func boltGet(db *bolt.DB, collection string, id string, value interface{}) error {
+	return db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(collection)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(data, value)
+	})
+}
+
+// Decodes every value in the collection, in order of id, and passes it to add
+func boltList(db *bolt.DB, collection string, add func(data []byte) error) error {
+	return db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(collection)).ForEach(func(_, data []byte) error {
+			return add(data)
+		})
+	})
+}
+
+// Decodes the page of the collection selected by opts, in order of id.
+// Keys are sorted, so the cursor is found with a seek
+func boltPage[T any](db *bolt.DB, collection string, opts ListOptions, at func(T) position) (Page[T], error) {
+	after, ok, err := opts.after()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	limit := opts.limit()
+	items := make([]T, 0)
+	total := 0
+
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(collection))
+		total = bucket.Stats().KeyN
+
+		cursor := bucket.Cursor()
+		key, data := cursor.First()
+		if ok {
+			key, data = cursor.Seek([]byte(after.ID))
+			if key != nil && string(key) == after.ID {
+				key, data = cursor.Next()
+			}
+		}
+
+		// One item more than the limit tells whether there is a next page
+		for ; key != nil && len(items) <= limit; key, data = cursor.Next() {
+			var item T
+			if err := json.Unmarshal(data, &item); err != nil {
+				return err
+			}
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return pageFrom(items, limit, total, at), nil
+}
+
+// Decodes the page of the items of the owner in the collection, in order of id.
+// Unless every owner is selected, all items are read to select the page
+func boltOwnedPage[T any](db *bolt.DB, collection string, owner string, ownerOf func(T) string, opts ListOptions,
+	at func(T) position) (Page[T], error) {
+	if owner == "" {
+		return boltPage(db, collection, opts, at)
+	}
+
+	items := make([]T, 0)
+	err := boltList(db, collection, func(data []byte) error {
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+		if ownedBy(ownerOf(item), owner) {
+			items = append(items, item)
+		}
+		return nil
+	})
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return pageOfSorted(items, opts, at, false)
+}
+
+// Stores the value returned by encode under a unique id in the collection, which is not used in its trash either
+// if the collection has one.
+// Checking the id and storing happens in one transaction, so ids can not collide
+func boltCreate(db *bolt.DB, ids *idSource, collection string, trashCollection string, encode func(id string) ([]byte, error)) (string, error) {
+	var id string
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(collection))
+
+		var err error
+		id, err = ids.createWithUniqueID(collection, func(id string) error {
+			if bucket.Get([]byte(id)) != nil {
+				return errIDTaken
+			}
+			if trashCollection != "" && tx.Bucket([]byte(trashCollection)).Get([]byte(id)) != nil {
+				return errIDTaken
+			}
+			data, err := encode(id)
+			if err != nil {
+				return err
+			}
+			return bucket.Put([]byte(id), data)
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Moves the item stored under id in the collection to the trash collection
+func boltMoveToTrash[T any](tx *bolt.Tx, collection string, trashCollection string, id string, item T) error {
+	data, err := json.Marshal(trash(item))
+	if err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(trashCollection)).Put([]byte(id), data); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(collection)).Delete([]byte(id))
+}
+
+// Moves the item stored under id in the trash collection back to the collection, if it belongs to the owner
+func boltRestore[T any](db *bolt.DB, collection string, trashCollection string, id string, owner string, ownerOf func(T) string) (T, error) {
+	var trashed Trashed[T]
+	err := db.Update(func(tx *bolt.Tx) error {
+		bin := tx.Bucket([]byte(trashCollection))
+		data := bin.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, &trashed); err != nil {
+			return err
+		}
+		if !ownedBy(ownerOf(trashed.Item), owner) {
+			return ErrNotFound
+		}
+
+		data, err := json.Marshal(trashed.Item)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(collection)).Put([]byte(id), data); err != nil {
+			return err
+		}
+		return bin.Delete([]byte(id))
+	})
+	return trashed.Item, err
+}
+
+// Removes the items deleted before the given time from the trash collection, and returns them.
+// purged is called in the same transaction with the id of every removed item
+func boltPurge[T any](db *bolt.DB, trashCollection string, deletedBefore time.Time, purged func(tx *bolt.Tx, id string) error) ([]T, error) {
+	items := make([]T, 0)
+	err := db.Update(func(tx *bolt.Tx) error {
+		bin := tx.Bucket([]byte(trashCollection))
+
+		// Keys are collected first, as deleting while iterating skips keys
+		var keys [][]byte
+		err := bin.ForEach(func(key, data []byte) error {
+			var trashed Trashed[T]
+			if err := json.Unmarshal(data, &trashed); err != nil {
+				return err
+			}
+			if trashed.DeletedAt.Before(deletedBefore) {
+				keys = append(keys, append([]byte{}, key...))
+				items = append(items, trashed.Item)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			if err := bin.Delete(key); err != nil {
+				return err
+			}
+			if purged != nil {
+				if err := purged(tx, string(key)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Key of a revision in the revision bucket. Keys of a configuration start with its id, and sort by number
+func revisionKey(id string, n int) []byte {
+	return []byte(fmt.Sprintf("%s/%010d", id, n))
+}
+
+// Stores the configuration as a revision
+func putRevision(tx *bolt.Tx, dashboard utils.Dashboard_Get) error {
+	data, err := json.Marshal(dashboard)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(RevisionCollection)).Put(revisionKey(dashboard.ID, dashboard.Revision), data)
+}
+
+// Get returns the dashboard configuration with the given id
+func (s *BoltDashboards) Get(_ context.Context, id string) (utils.Dashboard_Get, error) {
+	var dashboard utils.Dashboard_Get
+	err := boltGet(s.db, DashboardCollection, id, &dashboard)
+	return dashboard, err
+}
+
+// List returns a page of the dashboard configurations selected by the query.
+// Without filters or sort order, only the page is read from the database
+func (s *BoltDashboards) List(_ context.Context, query DashboardQuery, opts ListOptions) (Page[utils.Dashboard_Get], error) {
+	if query.isZero() {
+		return boltPage(s.db, DashboardCollection, opts, query.position)
+	}
+
+	dashboards := make([]utils.Dashboard_Get, 0)
+	err := boltList(s.db, DashboardCollection, func(data []byte) error {
+		var dashboard utils.Dashboard_Get
+		if err := json.Unmarshal(data, &dashboard); err != nil {
+			return err
+		}
+		dashboards = append(dashboards, dashboard)
+		return nil
+	})
+	if err != nil {
+		return Page[utils.Dashboard_Get]{}, err
+	}
+	return dashboardPage(dashboards, query, opts)
+}
+
+// Create stores the configuration under a unique id, and as its first revision
+func (s *BoltDashboards) Create(_ context.Context, dashboard utils.Dashboard_Get) (string, error) {
+	dashboard = storedDashboard(dashboard)
+	dashboard.Revision = 1
+
+	var id string
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(DashboardCollection))
+
+		var err error
+		id, err = s.createWithUniqueID(DashboardCollection, func(id string) error {
+			if bucket.Get([]byte(id)) != nil || tx.Bucket([]byte(DashboardTrashCollection)).Get([]byte(id)) != nil {
+				return errIDTaken
+			}
+			dashboard.ID = id
+			data, err := json.Marshal(dashboard)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(id), data); err != nil {
+				return err
+			}
+			return putRevision(tx, dashboard)
+		})
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Update stores the configuration as the next revision
+func (s *BoltDashboards) Update(_ context.Context, dashboard utils.Dashboard_Get, ifRevision int) (utils.Dashboard_Get, error) {
+	var next utils.Dashboard_Get
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(DashboardCollection))
+
+		data := bucket.Get([]byte(dashboard.ID))
+		if data == nil {
+			return ErrNotFound
+		}
+		var current utils.Dashboard_Get
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		if err := checkRevision(current, ifRevision); err != nil {
+			return err
+		}
+
+		var legacy *utils.Dashboard_Get
+		next, legacy = nextRevision(current, dashboard)
+		if legacy != nil {
+			if err := putRevision(tx, *legacy); err != nil {
+				return err
+			}
+		}
+
+		data, err := json.Marshal(next)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(next.ID), data); err != nil {
+			return err
+		}
+		return putRevision(tx, next)
+	})
+	return next, err
+}
+
+// Revisions returns every revision of the configuration, oldest first
+func (s *BoltDashboards) Revisions(_ context.Context, id string) ([]utils.Dashboard_Get, error) {
+	revisions := make([]utils.Dashboard_Get, 0)
+	var current utils.Dashboard_Get
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(DashboardCollection)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+
+		prefix := []byte(id + "/")
+		cursor := tx.Bucket([]byte(RevisionCollection)).Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			var revision utils.Dashboard_Get
+			if err := json.Unmarshal(data, &revision); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return historyOf(current, revisions), nil
+}
+
+// Revision returns revision n of the configuration
+func (s *BoltDashboards) Revision(ctx context.Context, id string, n int) (utils.Dashboard_Get, error) {
+	revisions, err := s.Revisions(ctx, id)
+	if err != nil {
+		return utils.Dashboard_Get{}, err
+	}
+	return revisionOf(revisions, n)
+}
+
+// Delete moves the configuration to the trash, its revisions are kept until it is purged
+func (s *BoltDashboards) Delete(_ context.Context, id string, ifRevision int) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(DashboardCollection)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var current utils.Dashboard_Get
+		if err := json.Unmarshal(data, &current); err != nil {
+			return err
+		}
+		if err := checkRevision(current, ifRevision); err != nil {
+			return err
+		}
+		return boltMoveToTrash(tx, DashboardCollection, DashboardTrashCollection, id, current)
+	})
+}
+
+// Trash returns a page of the deleted configurations of the owner, sorted by id
+func (s *BoltDashboards) Trash(_ context.Context, owner string, opts ListOptions) (Page[Trashed[utils.Dashboard_Get]], error) {
+	return boltOwnedPage(s.db, DashboardTrashCollection, owner, func(trashed Trashed[utils.Dashboard_Get]) string {
+		return trashed.Item.Owner
+	}, opts, trashedDashboardPosition)
+}
+
+// Restore moves the configuration of the owner back from the trash
+func (s *BoltDashboards) Restore(_ context.Context, id string, owner string) (utils.Dashboard_Get, error) {
+	return boltRestore(s.db, DashboardCollection, DashboardTrashCollection, id, owner, func(dashboard utils.Dashboard_Get) string {
+		return dashboard.Owner
+	})
+}
+
+// Purge removes the configurations deleted before the given time, with their revisions
+func (s *BoltDashboards) Purge(_ context.Context, deletedBefore time.Time) ([]utils.Dashboard_Get, error) {
+	return boltPurge[utils.Dashboard_Get](s.db, DashboardTrashCollection, deletedBefore, deleteRevisions)
+}
+
+// Removes every revision of the configuration
+func deleteRevisions(tx *bolt.Tx, id string) error {
+	// Keys are collected first, as deleting while iterating skips keys
+	prefix := []byte(id + "/")
+	revisions := tx.Bucket([]byte(RevisionCollection))
+	var keys [][]byte
+	cursor := revisions.Cursor()
+	for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, _ = cursor.Next() {
+		keys = append(keys, append([]byte{}, key...))
+	}
+	for _, key := range keys {
+		if err := revisions.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns the webhook with the given id
+func (s *BoltWebhooks) Get(_ context.Context, id string) (utils.WebhookGetResponse, error) {
+	var hook utils.WebhookGetResponse
+	err := boltGet(s.db, WebhookCollection, id, &hook)
+	return hook, err
+}
+
+// List returns a page of the webhooks, sorted by id
+func (s *BoltWebhooks) List(_ context.Context, owner string, opts ListOptions) (Page[utils.WebhookGetResponse], error) {
+	return boltOwnedPage(s.db, WebhookCollection, owner, webhookOwner, opts, webhookPosition)
+}
+
+// Create stores the webhook under a unique id
+func (s *BoltWebhooks) Create(_ context.Context, hook utils.WebhookGetResponse) (string, error) {
+	return boltCreate(s.db, &s.idSource, WebhookCollection, WebhookTrashCollection, func(id string) ([]byte, error) {
+		hook.Id = id
+		return json.Marshal(hook)
+	})
+}
+
+// Delete moves the webhook to the trash
+func (s *BoltWebhooks) Delete(_ context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(WebhookCollection)).Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var hook utils.WebhookGetResponse
+		if err := json.Unmarshal(data, &hook); err != nil {
+			return err
+		}
+		return boltMoveToTrash(tx, WebhookCollection, WebhookTrashCollection, id, hook)
+	})
+}
+
+// Trash returns a page of the deleted webhooks of the owner, sorted by id
+func (s *BoltWebhooks) Trash(_ context.Context, owner string, opts ListOptions) (Page[Trashed[utils.WebhookGetResponse]], error) {
+	return boltOwnedPage(s.db, WebhookTrashCollection, owner, func(trashed Trashed[utils.WebhookGetResponse]) string {
+		return trashed.Item.Owner
+	}, opts, trashedWebhookPosition)
+}
+
+// Restore moves the webhook of the owner back from the trash
+func (s *BoltWebhooks) Restore(_ context.Context, id string, owner string) (utils.WebhookGetResponse, error) {
+	return boltRestore(s.db, WebhookCollection, WebhookTrashCollection, id, owner, webhookOwner)
+}
+
+// Purge removes the webhooks deleted before the given time
+func (s *BoltWebhooks) Purge(_ context.Context, deletedBefore time.Time) ([]utils.WebhookGetResponse, error) {
+	return boltPurge[utils.WebhookGetResponse](s.db, WebhookTrashCollection, deletedBefore, nil)
+}
+
+// Matching returns the webhooks of the owner registered for the event on the country, or on all countries
+func (s *BoltWebhooks) Matching(_ context.Context, event string, isoCode string, owner string) ([]utils.WebhookGetResponse, error) {
+	return s.filter(func(hook utils.WebhookGetResponse) bool {
+		return matchesEvent(hook, event, isoCode, owner)
+	})
+}
+
+// Count returns the number of stored webhooks
+func (s *BoltWebhooks) Count(_ context.Context) (int, error) {
+	count := 0
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = tx.Bucket([]byte(WebhookCollection)).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return -1, err
+	}
+	return count, nil
+}
+
+// Returns the stored webhooks accepted by keep, sorted by id
+func (s *BoltWebhooks) filter(keep func(utils.WebhookGetResponse) bool) ([]utils.WebhookGetResponse, error) {
+	hooks := make([]utils.WebhookGetResponse, 0)
+	err := boltList(s.db, WebhookCollection, func(data []byte) error {
+		var hook utils.WebhookGetResponse
+		if err := json.Unmarshal(data, &hook); err != nil {
+			return err
+		}
+		if keep(hook) {
+			hooks = append(hooks, hook)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+// Get returns the key with the given id
+func (s *BoltAPIKeys) Get(_ context.Context, id string) (utils.APIKey, error) {
+	var key utils.APIKey
+	err := boltGet(s.db, APIKeyCollection, id, &key)
+	return key, err
+}
+
+// List returns a page of the keys, sorted by id
+func (s *BoltAPIKeys) List(_ context.Context, opts ListOptions) (Page[utils.APIKey], error) {
+	return boltPage(s.db, APIKeyCollection, opts, apiKeyPosition)
+}
+
+// Create stores the key under a unique id
+func (s *BoltAPIKeys) Create(_ context.Context, key utils.APIKey) (string, error) {
+	return boltCreate(s.db, &s.idSource, APIKeyCollection, "", func(id string) ([]byte, error) {
+		key.ID = id
+		return json.Marshal(key)
+	})
+}
+
+// Revoke marks the key as revoked
+func (s *BoltAPIKeys) Revoke(_ context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(APIKeyCollection))
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var key utils.APIKey
+		if err := json.Unmarshal(data, &key); err != nil {
+			return err
+		}
+
+		key.Revoked = true
+		data, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+// Record stores the record under a unique id
+func (s *BoltAuditLog) Record(_ context.Context, record utils.AuditRecord) (string, error) {
+	record = storedAuditRecord(record)
+	return boltCreate(s.db, &s.idSource, AuditCollection, "", func(id string) ([]byte, error) {
+		record.ID = id
+		return json.Marshal(record)
+	})
+}
+
+// List returns a page of the records selected by the query, newest first. Records are filtered and sorted in memory
+func (s *BoltAuditLog) List(_ context.Context, query AuditQuery, opts ListOptions) (Page[utils.AuditRecord], error) {
+	records := make([]utils.AuditRecord, 0)
+	err := boltList(s.db, AuditCollection, func(data []byte) error {
+		var record utils.AuditRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		return Page[utils.AuditRecord]{}, err
+	}
+	return auditPage(records, query, opts)
+}
+
+// Get returns the response cached under the URL
+func (c *BoltCache) Get(_ context.Context, url string) (utils.CachedResponse, bool, error) {
+	var response utils.CachedResponse
+	err := boltGet(c.db, CacheCollection, url, &response)
+	if errors.Is(err, ErrNotFound) {
+		return response, false, nil
+	}
+	return response, err == nil, err
+}
+
+// Set caches the response under the URL
+func (c *BoltCache) Set(_ context.Context, url string, response utils.CachedResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(CacheCollection)).Put([]byte(url), data)
+	})
+}
+
+// Get returns the snapshot of the dashboard
+func (s *BoltSnapshots) Get(_ context.Context, dashboardID string) (utils.DashboardSnapshot, error) {
+	var snapshot utils.DashboardSnapshot
+	err := boltGet(s.db, SnapshotCollection, dashboardID, &snapshot)
+	return snapshot, err
+}
+
+// Put replaces the snapshot of the dashboard
+func (s *BoltSnapshots) Put(_ context.Context, dashboardID string, snapshot utils.DashboardSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(SnapshotCollection)).Put([]byte(dashboardID), data)
+	})
+}
+
+// Delete removes the snapshot of the dashboard
+func (s *BoltSnapshots) Delete(_ context.Context, dashboardID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(SnapshotCollection)).Delete([]byte(dashboardID))
+	})
+}
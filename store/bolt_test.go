@@ -0,0 +1,88 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"path/filepath"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Opens the database at path, it is closed when the test finishes
+func openTestBolt(t *testing.T, path string) *bolt.DB {
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Test for the BoltDB dashboard store
+func TestBoltDashboards(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testDashboardStore(t, NewBoltDashboards(db))
+}
+
+// Test of queries on the BoltDB dashboard store
+func TestBoltDashboardQueries(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testDashboardQueries(t, NewBoltDashboards(db))
+}
+
+// Test for the BoltDB webhook store
+func TestBoltWebhooks(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testWebhookStore(t, NewBoltWebhooks(db))
+}
+
+// Test for the BoltDB API key store
+func TestBoltAPIKeys(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testAPIKeyStore(t, NewBoltAPIKeys(db))
+}
+
+// Test that configurations are still stored after the database is reopened
+func TestBoltPersistence(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// Store a configuration and close the database
+	db, err := OpenBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := NewBoltDashboards(db).Create(ctx, utils.Dashboard_Get{Country: "Norway", IsoCode: "NO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Reopen the database and read the configuration
+	got, err := NewBoltDashboards(openTestBolt(t, path)).Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.Country != "Norway" {
+		t.Errorf("Expected the stored configuration, got %v", got)
+	}
+}
+
+// Test for the BoltDB audit store
+func TestBoltAuditLog(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testAuditStore(t, NewBoltAuditLog(db))
+}
+
+// Test for the BoltDB shared cache
+func TestBoltCache(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testSharedCache(t, NewBoltCache(db))
+}
+
+// Test for the BoltDB snapshot store
+func TestBoltSnapshots(t *testing.T) {
+	db := openTestBolt(t, filepath.Join(t.TempDir(), "test.db"))
+	testSnapshotStore(t, NewBoltSnapshots(db))
+}
@@ -0,0 +1,973 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/firestore/apiv1/firestorepb"
+	firebase "firebase.google.com/go"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Project ID used with the Firestore emulator when none is configured
+const EmulatorProjectID = "demo-dashboard"
+
+// Connects to Firestore in the given project, or the project of the credentials if projectID is empty.
+// If $FIRESTORE_EMULATOR_HOST is set, the emulator at that address is used and no credentials are needed
+func NewFirestoreClient(ctx context.Context, projectID string, credentialsFile string) (*firestore.Client, error) {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") != "" {
+		if projectID == "" {
+			projectID = EmulatorProjectID
+		}
+		return firestore.NewClient(ctx, projectID)
+	}
+
+	var config *firebase.Config
+	if projectID != "" {
+		config = &firebase.Config{ProjectID: projectID}
+	}
+
+	// Loads credential file from firebase
+	app, err := firebase.NewApp(ctx, config, option.WithCredentialsFile(credentialsFile))
+	if err != nil {
+		return nil, err
+	}
+	return app.Firestore(ctx)
+}
+
+// FirestoreDashboards is a DashboardStore backed by a Firestore collection
+type FirestoreDashboards struct {
+	idSource
+	client *firestore.Client
+}
+
+// FirestoreWebhooks is a WebhookStore backed by a Firestore collection
+type FirestoreWebhooks struct {
+	idSource
+	client *firestore.Client
+}
+
+// FirestoreAPIKeys is an APIKeyStore backed by the API key collection in Firestore
+type FirestoreAPIKeys struct {
+	idSource
+	client *firestore.Client
+}
+
+// FirestoreAuditLog is an AuditStore backed by the audit collection in Firestore
+type FirestoreAuditLog struct {
+	idSource
+	client *firestore.Client
+}
+
+// FirestoreCache is a utils.SharedCache backed by the cache collection in Firestore, shared by every instance
+type FirestoreCache struct {
+	client *firestore.Client
+}
+
+// FirestoreSnapshots is a SnapshotStore backed by the snapshot collection in Firestore
+type FirestoreSnapshots struct {
+	client *firestore.Client
+}
+
+// Creates a DashboardStore using the dashboard collection of the given client
+func NewFirestoreDashboards(client *firestore.Client) *FirestoreDashboards {
+	return &FirestoreDashboards{client: client}
+}
+
+// Creates a WebhookStore using the webhook collection of the given client
+func NewFirestoreWebhooks(client *firestore.Client) *FirestoreWebhooks {
+	return &FirestoreWebhooks{client: client}
+}
+
+// Creates an APIKeyStore using the API key collection of the given client
+func NewFirestoreAPIKeys(client *firestore.Client) *FirestoreAPIKeys {
+	return &FirestoreAPIKeys{client: client}
+}
+
+// Creates an AuditStore using the audit collection of the given client
+func NewFirestoreAuditLog(client *firestore.Client) *FirestoreAuditLog {
+	return &FirestoreAuditLog{client: client}
+}
+
+// Creates a SharedCache using the cache collection of the given client
+func NewFirestoreCache(client *firestore.Client) *FirestoreCache {
+	return &FirestoreCache{client: client}
+}
+
+// Creates a SnapshotStore using the snapshot collection of the given client
+func NewFirestoreSnapshots(client *firestore.Client) *FirestoreSnapshots {
+	return &FirestoreSnapshots{client: client}
+}
+
+// Gets the document stored under the id in the collection
+func getDocument(ctx context.Context, client *firestore.Client, collection string, id string) (*firestore.DocumentSnapshot, error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+
+	doc, err := client.Collection(collection).Doc(id).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// Creates the document returned by data under a unique id in the collection, which is not used in its trash either
+// if the collection has one.
+// Create fails if the document already exists, so ids can not collide
+func createDocument(ctx context.Context, client *firestore.Client, ids *idSource, collection string, trashCollection string,
+	data func(id string) interface{}) (string, error) {
+	return ids.createWithUniqueID(collection, func(id string) error {
+		err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if trashCollection != "" {
+				if err := checkNotTrashed(tx, client.Collection(trashCollection).Doc(id)); err != nil {
+					return err
+				}
+			}
+			return tx.Create(client.Collection(collection).Doc(id), data(id))
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			return errIDTaken
+		}
+		return err
+	})
+}
+
+// Returns errIDTaken if the id of a new document is used by a document in the trash
+func checkNotTrashed(tx *firestore.Transaction, trashed *firestore.DocumentRef) error {
+	_, err := tx.Get(trashed)
+	if err == nil {
+		return errIDTaken
+	}
+	if status.Code(err) == codes.NotFound {
+		return nil
+	}
+	return err
+}
+
+// Fields of the trash document of the deleted document data
+func trashData(data map[string]interface{}) map[string]interface{} {
+	deleted := trash(data)
+	return map[string]interface{}{
+		"item":      deleted.Item,
+		"deletedAt": deleted.DeletedAt,
+	}
+}
+
+// Moves the read document to the trash collection, if it has not been updated since it was read
+func moveToTrash(tx *firestore.Transaction, doc *firestore.DocumentSnapshot, trashed *firestore.DocumentRef, data map[string]interface{}) error {
+	if err := tx.Delete(doc.Ref, firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+		return err
+	}
+	return tx.Create(trashed, trashData(data))
+}
+
+// Reads a page of the documents of the owner in the trash collection, sorted by id
+func trashPage[T any](ctx context.Context, client *firestore.Client, trashCollection string, owner string, opts ListOptions,
+	at func(Trashed[T]) position) (Page[Trashed[T]], error) {
+	filtered := client.Collection(trashCollection).Query
+	if owner != "" {
+		filtered = filtered.Where("item.owner", "==", owner)
+	}
+	listing := firestoreListing[Trashed[T]]{
+		filtered: filtered,
+		ordered:  filtered.OrderBy(firestore.DocumentID, firestore.Asc),
+		startAfter: func(after position) ([]interface{}, error) {
+			return []interface{}{after.ID}, nil
+		},
+		at: at,
+	}
+	return listing.page(ctx, opts)
+}
+
+// Moves the document stored under id in the trash collection back to the collection, in one transaction,
+// if it belongs to the owner
+func restoreDocument[T any](ctx context.Context, client *firestore.Client, collection string, trashCollection string, id string,
+	owner string, ownerOf func(T) string, data func(T) map[string]interface{}) (T, error) {
+	var trashed Trashed[T]
+	if id == "" {
+		return trashed.Item, ErrNotFound
+	}
+
+	trashRef := client.Collection(trashCollection).Doc(id)
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(trashRef)
+		if err != nil {
+			return err
+		}
+		if err := doc.DataTo(&trashed); err != nil {
+			return err
+		}
+		if !ownedBy(ownerOf(trashed.Item), owner) {
+			return ErrNotFound
+		}
+		if err := tx.Create(client.Collection(collection).Doc(id), data(trashed.Item)); err != nil {
+			return err
+		}
+		return tx.Delete(trashRef, firestore.LastUpdateTime(doc.UpdateTime))
+	})
+	switch {
+	case errors.Is(err, ErrNotFound) || status.Code(err) == codes.NotFound:
+		return trashed.Item, ErrNotFound
+	case status.Code(err) == codes.AlreadyExists:
+		return trashed.Item, ErrConflict
+	}
+	return trashed.Item, err
+}
+
+// Removes the documents deleted before the given time from the trash collection, and returns them.
+// purged is called with the id of every removed document
+func purgeDocuments[T any](ctx context.Context, client *firestore.Client, trashCollection string, deletedBefore time.Time,
+	purged func(ctx context.Context, id string) error) ([]T, error) {
+	docs, err := client.Collection(trashCollection).Where("deletedAt", "<", deletedBefore).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var trashed Trashed[T]
+		if err := doc.DataTo(&trashed); err != nil {
+			return nil, err
+		}
+
+		// A document restored since it was read is not purged
+		_, err := doc.Ref.Delete(ctx, firestore.LastUpdateTime(doc.UpdateTime))
+		if status.Code(err) == codes.NotFound || status.Code(err) == codes.FailedPrecondition {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if purged != nil {
+			if err := purged(ctx, doc.Ref.ID); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, trashed.Item)
+	}
+	return items, nil
+}
+
+// Counts the documents matching the query, with an aggregation query so the documents are not read
+func countDocuments(ctx context.Context, query firestore.Query) (int, error) {
+	result, err := query.NewAggregationQuery().WithCount("total").Get(ctx)
+	if err != nil {
+		return -1, err
+	}
+
+	count, ok := result["total"].(*firestorepb.Value)
+	if !ok {
+		return -1, errors.New("count missing from aggregation result")
+	}
+	return int(count.GetIntegerValue()), nil
+}
+
+// A listing of documents, read a page at a time
+type firestoreListing[T any] struct {
+	// Query selecting the documents of the listing
+	filtered firestore.Query
+	// The filtered query ordered by position. Documents are named after their id, so the order ends with the document ID
+	ordered firestore.Query
+	// Values of the ordered fields at the position, to start a page after
+	startAfter func(position) ([]interface{}, error)
+	// Position of an item in the listing
+	at func(T) position
+	// Whether the order is descending
+	descending bool
+}
+
+// Decodes the documents
+func decodeDocuments[T any](docs []*firestore.DocumentSnapshot) ([]T, error) {
+	items := make([]T, 0, len(docs))
+	for _, doc := range docs {
+		var item T
+		if err := doc.DataTo(&item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Reads the page of the listing selected by opts
+func (l firestoreListing[T]) page(ctx context.Context, opts ListOptions) (Page[T], error) {
+	total, err := countDocuments(ctx, l.filtered)
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	query := l.ordered
+	after, ok, err := opts.after()
+	if err != nil {
+		return Page[T]{}, err
+	}
+	if ok {
+		values, err := l.startAfter(after)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		query = query.StartAfter(values...)
+	}
+
+	// One document more than the limit tells whether there is a next page
+	limit := opts.limit()
+	docs, err := query.Limit(limit + 1).Documents(ctx).GetAll()
+	if err != nil {
+		return Page[T]{}, err
+	}
+	items, err := decodeDocuments[T](docs)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	return pageFrom(items, limit, total, l.at), nil
+}
+
+// Maps a dashboard configuration to the fields of its Firestore document
+func dashboardData(dashboard utils.Dashboard_Get) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      dashboard.ID,
+		"country": dashboard.Country,
+		"isoCode": dashboard.IsoCode,
+		"features": map[string]interface{}{
+			"temperature":         dashboard.Features.Temperature,
+			"precipitation":       dashboard.Features.Precipitation,
+			"capital":             dashboard.Features.Capital,
+			"coordinates":         dashboard.Features.Coordinates,
+			"population":          dashboard.Features.Population,
+			"area":                dashboard.Features.Area,
+			"targetCurrencies":    dashboard.Features.TargetCurrencies,
+			"windSpeed":           dashboard.Features.WindSpeed,
+			"windDirection":       dashboard.Features.WindDirection,
+			"humidity":            dashboard.Features.Humidity,
+			"cloudCover":          dashboard.Features.CloudCover,
+			"uvIndex":             dashboard.Features.UVIndex,
+			"apparentTemperature": dashboard.Features.ApparentTemperature,
+			"airQuality":          dashboard.Features.AirQuality,
+			"aggregation":         dashboard.Features.Aggregation,
+			"forecast":            dashboard.Features.Forecast,
+		},
+		"lastChange": dashboard.LastChange,
+		"revision":   dashboard.Revision,
+		"owner":      dashboard.Owner,
+	}
+}
+
+// Maps a dashboard configuration to updates replacing every field of its Firestore document
+func dashboardUpdates(dashboard utils.Dashboard_Get) []firestore.Update {
+	data := dashboardData(dashboard)
+	updates := make([]firestore.Update, 0, len(data))
+	for field, value := range data {
+		updates = append(updates, firestore.Update{Path: field, Value: value})
+	}
+	return updates
+}
+
+// Maps the errors of a write with preconditions on the document of a configuration
+func writeError(err error) error {
+	switch status.Code(err) {
+	case codes.NotFound:
+		return ErrNotFound
+	case codes.FailedPrecondition:
+		return ErrConflict
+	}
+	return err
+}
+
+// Reference to the document of revision n, in the revision collection of the configuration
+func revisionRef(dashboard *firestore.DocumentRef, n int) *firestore.DocumentRef {
+	return dashboard.Collection(RevisionCollection).Doc(strconv.Itoa(n))
+}
+
+// Get returns the dashboard configuration with the given id
+func (s *FirestoreDashboards) Get(ctx context.Context, id string) (utils.Dashboard_Get, error) {
+	var dashboard utils.Dashboard_Get
+
+	doc, err := getDocument(ctx, s.client, DashboardCollection, id)
+	if err != nil {
+		return dashboard, err
+	}
+
+	err = doc.DataTo(&dashboard)
+	return dashboard, err
+}
+
+// List returns a page of the dashboard configurations selected by the query. Every filter is done by Firestore.
+// Combining filters with a sort order may require a composite index, which Firestore describes in the error
+func (s *FirestoreDashboards) List(ctx context.Context, query DashboardQuery, opts ListOptions) (Page[utils.Dashboard_Get], error) {
+	// A range filter on lastChange requires the listing to be ordered by lastChange first, which Validate checks
+	if err := query.Validate(); err != nil {
+		return Page[utils.Dashboard_Get]{}, err
+	}
+
+	filtered := s.client.Collection(DashboardCollection).Query
+	if query.Country != "" {
+		filtered = filtered.Where("country", "==", query.Country)
+	}
+	if len(query.IsoCodes) > 0 {
+		filtered = filtered.Where("isoCode", "in", query.IsoCodes)
+	}
+	for _, feature := range query.Features {
+		filtered = filtered.Where("features."+feature, "==", true)
+	}
+	if query.Currency != "" {
+		filtered = filtered.Where("features.targetCurrencies", "array-contains", query.Currency)
+	}
+	if query.Owner != "" {
+		filtered = filtered.Where("owner", "==", query.Owner)
+	}
+
+	listing := firestoreListing[utils.Dashboard_Get]{
+		at:         query.position,
+		descending: query.Descending,
+	}
+
+	if !query.ChangedSince.IsZero() {
+		filtered = filtered.Where("lastChange", ">=", query.ChangedSince)
+	}
+
+	direction := firestore.Asc
+	if query.Descending {
+		direction = firestore.Desc
+	}
+
+	switch query.Sort {
+	case SortByLastChange:
+		listing.ordered = filtered.OrderBy("lastChange", direction).OrderBy(firestore.DocumentID, direction)
+		listing.startAfter = func(after position) ([]interface{}, error) {
+			changed, err := time.Parse(cursorTimeLayout, after.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			return []interface{}{changed, after.ID}, nil
+		}
+	case SortByCountry:
+		listing.ordered = filtered.OrderBy("country", direction).OrderBy(firestore.DocumentID, direction)
+		listing.startAfter = func(after position) ([]interface{}, error) {
+			return []interface{}{after.Value, after.ID}, nil
+		}
+	default:
+		listing.ordered = filtered.OrderBy(firestore.DocumentID, direction)
+		listing.startAfter = func(after position) ([]interface{}, error) {
+			return []interface{}{after.ID}, nil
+		}
+	}
+	listing.filtered = filtered
+
+	return listing.page(ctx, opts)
+}
+
+// Create adds the configuration as a new document, with a unique id as document ID, and as its first revision
+func (s *FirestoreDashboards) Create(ctx context.Context, dashboard utils.Dashboard_Get) (string, error) {
+	dashboard.Revision = 1
+
+	return s.createWithUniqueID(DashboardCollection, func(id string) error {
+		dashboard.ID = id
+		ref := s.client.Collection(DashboardCollection).Doc(id)
+
+		err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if err := checkNotTrashed(tx, s.client.Collection(DashboardTrashCollection).Doc(id)); err != nil {
+				return err
+			}
+			if err := tx.Create(ref, dashboardData(dashboard)); err != nil {
+				return err
+			}
+			return tx.Create(revisionRef(ref, 1), dashboardData(dashboard))
+		})
+		if status.Code(err) == codes.AlreadyExists {
+			return errIDTaken
+		}
+		return err
+	})
+}
+
+// Update stores the configuration as the next revision, in a transaction with reading the current revision.
+// The document is only written if it has not been updated since it was read
+func (s *FirestoreDashboards) Update(ctx context.Context, dashboard utils.Dashboard_Get, ifRevision int) (utils.Dashboard_Get, error) {
+	if dashboard.ID == "" {
+		return utils.Dashboard_Get{}, ErrNotFound
+	}
+
+	var next utils.Dashboard_Get
+	ref := s.client.Collection(DashboardCollection).Doc(dashboard.ID)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var current utils.Dashboard_Get
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if err := checkRevision(current, ifRevision); err != nil {
+			return err
+		}
+
+		var legacy *utils.Dashboard_Get
+		next, legacy = nextRevision(current, dashboard)
+		if legacy != nil {
+			if err := tx.Set(revisionRef(ref, legacy.Revision), dashboardData(*legacy)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Update(ref, dashboardUpdates(next), firestore.LastUpdateTime(doc.UpdateTime)); err != nil {
+			return err
+		}
+		return tx.Create(revisionRef(ref, next.Revision), dashboardData(next))
+	})
+	if err != nil {
+		return utils.Dashboard_Get{}, writeError(err)
+	}
+	return next, nil
+}
+
+// Revisions returns every revision of the configuration, oldest first
+func (s *FirestoreDashboards) Revisions(ctx context.Context, id string) ([]utils.Dashboard_Get, error) {
+	current, err := s.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	ref := s.client.Collection(DashboardCollection).Doc(id)
+	docs, err := ref.Collection(RevisionCollection).OrderBy("revision", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := decodeDocuments[utils.Dashboard_Get](docs)
+	if err != nil {
+		return nil, err
+	}
+	return historyOf(current, revisions), nil
+}
+
+// Revision returns revision n of the configuration
+func (s *FirestoreDashboards) Revision(ctx context.Context, id string, n int) (utils.Dashboard_Get, error) {
+	var revision utils.Dashboard_Get
+
+	// Revisions of a configuration in the trash are kept, but are not found until it is restored
+	current, err := s.Get(ctx, id)
+	if err != nil {
+		return revision, err
+	}
+
+	doc, err := revisionRef(s.client.Collection(DashboardCollection).Doc(id), n).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		// A configuration stored before revisions were kept is its own first revision
+		return revisionOf(historyOf(current, nil), n)
+	}
+	if err != nil {
+		return revision, err
+	}
+
+	err = doc.DataTo(&revision)
+	return revision, err
+}
+
+// Delete moves the document of the configuration to the trash, if it has not been updated since its revision
+// was checked. Its revisions are kept until it is purged
+func (s *FirestoreDashboards) Delete(ctx context.Context, id string, ifRevision int) error {
+	if id == "" {
+		return ErrNotFound
+	}
+
+	ref := s.client.Collection(DashboardCollection).Doc(id)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var current utils.Dashboard_Get
+		if err := doc.DataTo(&current); err != nil {
+			return err
+		}
+		if err := checkRevision(current, ifRevision); err != nil {
+			return err
+		}
+		return moveToTrash(tx, doc, s.client.Collection(DashboardTrashCollection).Doc(id), dashboardData(current))
+	})
+	return writeError(err)
+}
+
+// Trash returns a page of the deleted configurations of the owner, sorted by id
+func (s *FirestoreDashboards) Trash(ctx context.Context, owner string, opts ListOptions) (Page[Trashed[utils.Dashboard_Get]], error) {
+	return trashPage(ctx, s.client, DashboardTrashCollection, owner, opts, trashedDashboardPosition)
+}
+
+// Restore moves the document of the configuration of the owner back from the trash
+func (s *FirestoreDashboards) Restore(ctx context.Context, id string, owner string) (utils.Dashboard_Get, error) {
+	return restoreDocument(ctx, s.client, DashboardCollection, DashboardTrashCollection, id, owner,
+		func(dashboard utils.Dashboard_Get) string { return dashboard.Owner }, dashboardData)
+}
+
+// Purge removes the documents of the configurations deleted before the given time, and then their revisions
+func (s *FirestoreDashboards) Purge(ctx context.Context, deletedBefore time.Time) ([]utils.Dashboard_Get, error) {
+	return purgeDocuments[utils.Dashboard_Get](ctx, s.client, DashboardTrashCollection, deletedBefore, s.deleteRevisions)
+}
+
+// Deletes every revision of the configuration
+func (s *FirestoreDashboards) deleteRevisions(ctx context.Context, id string) error {
+	ref := s.client.Collection(DashboardCollection).Doc(id)
+	revisions, err := ref.Collection(RevisionCollection).Documents(ctx).GetAll()
+	if err != nil {
+		return err
+	}
+	for _, revision := range revisions {
+		if _, err := revision.Ref.Delete(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reads all webhooks returned by the iterator
+func webhooksFrom(iter *firestore.DocumentIterator) ([]utils.WebhookGetResponse, error) {
+	docs, err := iter.GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := make([]utils.WebhookGetResponse, 0, len(docs))
+	for _, doc := range docs {
+		var hook utils.WebhookGetResponse
+		if err := doc.DataTo(&hook); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// Get returns the webhook with the given id
+func (s *FirestoreWebhooks) Get(ctx context.Context, id string) (utils.WebhookGetResponse, error) {
+	var hook utils.WebhookGetResponse
+
+	doc, err := getDocument(ctx, s.client, WebhookCollection, id)
+	if err != nil {
+		return hook, err
+	}
+
+	err = doc.DataTo(&hook)
+	return hook, err
+}
+
+// List returns a page of the webhooks of the owner, sorted by id
+func (s *FirestoreWebhooks) List(ctx context.Context, owner string, opts ListOptions) (Page[utils.WebhookGetResponse], error) {
+	filtered := s.client.Collection(WebhookCollection).Query
+	if owner != "" {
+		filtered = filtered.Where("owner", "==", owner)
+	}
+	listing := firestoreListing[utils.WebhookGetResponse]{
+		filtered: filtered,
+		ordered:  filtered.OrderBy(firestore.DocumentID, firestore.Asc),
+		startAfter: func(after position) ([]interface{}, error) {
+			return []interface{}{after.ID}, nil
+		},
+		at: webhookPosition,
+	}
+	return listing.page(ctx, opts)
+}
+
+// Maps a webhook to the fields of its Firestore document
+func webhookData(hook utils.WebhookGetResponse) map[string]interface{} {
+	return map[string]interface{}{
+		"id":      hook.Id,
+		"url":     hook.Url,
+		"country": hook.Country,
+		"event":   hook.Event,
+		"owner":   hook.Owner,
+	}
+}
+
+// Create adds the webhook as a new document, with a unique id as document ID
+func (s *FirestoreWebhooks) Create(ctx context.Context, hook utils.WebhookGetResponse) (string, error) {
+	return createDocument(ctx, s.client, &s.idSource, WebhookCollection, WebhookTrashCollection, func(id string) interface{} {
+		hook.Id = id
+		return webhookData(hook)
+	})
+}
+
+// Delete moves the document of the webhook to the trash
+func (s *FirestoreWebhooks) Delete(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrNotFound
+	}
+
+	ref := s.client.Collection(WebhookCollection).Doc(id)
+	err := s.client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		var hook utils.WebhookGetResponse
+		if err := doc.DataTo(&hook); err != nil {
+			return err
+		}
+		return moveToTrash(tx, doc, s.client.Collection(WebhookTrashCollection).Doc(id), webhookData(hook))
+	})
+	return writeError(err)
+}
+
+// Trash returns a page of the deleted webhooks of the owner, sorted by id
+func (s *FirestoreWebhooks) Trash(ctx context.Context, owner string, opts ListOptions) (Page[Trashed[utils.WebhookGetResponse]], error) {
+	return trashPage(ctx, s.client, WebhookTrashCollection, owner, opts, trashedWebhookPosition)
+}
+
+// Restore moves the document of the webhook of the owner back from the trash
+func (s *FirestoreWebhooks) Restore(ctx context.Context, id string, owner string) (utils.WebhookGetResponse, error) {
+	return restoreDocument(ctx, s.client, WebhookCollection, WebhookTrashCollection, id, owner, webhookOwner, webhookData)
+}
+
+// Purge removes the documents of the webhooks deleted before the given time
+func (s *FirestoreWebhooks) Purge(ctx context.Context, deletedBefore time.Time) ([]utils.WebhookGetResponse, error) {
+	return purgeDocuments[utils.WebhookGetResponse](ctx, s.client, WebhookTrashCollection, deletedBefore, nil)
+}
+
+// Matching queries the webhooks of the owner registered for the event on the country, or on all countries
+func (s *FirestoreWebhooks) Matching(ctx context.Context, event string, isoCode string, owner string) ([]utils.WebhookGetResponse, error) {
+	query := s.client.Collection(WebhookCollection).
+		Where("event", "==", event).
+		Where("country", "in", []string{isoCode, ""})
+	if owner != "" {
+		query = query.Where("owner", "==", owner)
+	}
+
+	return webhooksFrom(query.Documents(ctx))
+}
+
+// Count returns the number of documents in the webhook collection
+func (s *FirestoreWebhooks) Count(ctx context.Context) (int, error) {
+	return countDocuments(ctx, s.client.Collection(WebhookCollection).Query)
+}
+
+// Get returns the key with the given id
+func (s *FirestoreAPIKeys) Get(ctx context.Context, id string) (utils.APIKey, error) {
+	var key utils.APIKey
+
+	doc, err := getDocument(ctx, s.client, APIKeyCollection, id)
+	if err != nil {
+		return key, err
+	}
+
+	err = doc.DataTo(&key)
+	return key, err
+}
+
+// List returns a page of the keys, sorted by id
+func (s *FirestoreAPIKeys) List(ctx context.Context, opts ListOptions) (Page[utils.APIKey], error) {
+	collection := s.client.Collection(APIKeyCollection)
+	listing := firestoreListing[utils.APIKey]{
+		filtered: collection.Query,
+		ordered:  collection.OrderBy(firestore.DocumentID, firestore.Asc),
+		startAfter: func(after position) ([]interface{}, error) {
+			return []interface{}{after.ID}, nil
+		},
+		at: apiKeyPosition,
+	}
+	return listing.page(ctx, opts)
+}
+
+// Create adds the key as a new document, with a unique id as document ID
+func (s *FirestoreAPIKeys) Create(ctx context.Context, key utils.APIKey) (string, error) {
+	return createDocument(ctx, s.client, &s.idSource, APIKeyCollection, "", func(id string) interface{} {
+		return map[string]interface{}{
+			"id":        id,
+			"owner":     key.Owner,
+			"roles":     key.Roles,
+			"hash":      key.Hash,
+			"createdAt": key.CreatedAt,
+			"revoked":   key.Revoked,
+		}
+	})
+}
+
+// Revoke marks the document of the key as revoked
+func (s *FirestoreAPIKeys) Revoke(ctx context.Context, id string) error {
+	if id == "" {
+		return ErrNotFound
+	}
+
+	_, err := s.client.Collection(APIKeyCollection).Doc(id).Update(ctx, []firestore.Update{{Path: "revoked", Value: true}})
+	return writeError(err)
+}
+
+// Fields of the document of an audit record. The snapshots are kept as JSON strings
+type auditDocument struct {
+	ID           string    `firestore:"id"`
+	Time         time.Time `firestore:"time"`
+	Actor        string    `firestore:"actor"`
+	Action       string    `firestore:"action"`
+	ResourceType string    `firestore:"resourceType"`
+	ResourceID   string    `firestore:"resourceId"`
+	Before       string    `firestore:"before"`
+	After        string    `firestore:"after"`
+}
+
+// Record adds the record as a new document, with a unique id as document ID
+func (s *FirestoreAuditLog) Record(ctx context.Context, record utils.AuditRecord) (string, error) {
+	return createDocument(ctx, s.client, &s.idSource, AuditCollection, "", func(id string) interface{} {
+		return auditDocument{
+			ID:           id,
+			Time:         record.Time,
+			Actor:        record.Actor,
+			Action:       record.Action,
+			ResourceType: record.ResourceType,
+			ResourceID:   record.ResourceID,
+			Before:       string(record.Before),
+			After:        string(record.After),
+		}
+	})
+}
+
+// List returns a page of the records selected by the query, newest first.
+// Filtering on the resource or actor combined with the order by time may require a composite index, which Firestore
+// describes in the error
+func (s *FirestoreAuditLog) List(ctx context.Context, query AuditQuery, opts ListOptions) (Page[utils.AuditRecord], error) {
+	filtered := s.client.Collection(AuditCollection).Query
+	if query.ResourceType != "" {
+		filtered = filtered.Where("resourceType", "==", query.ResourceType)
+	}
+	if query.ResourceID != "" {
+		filtered = filtered.Where("resourceId", "==", query.ResourceID)
+	}
+	if query.Actor != "" {
+		filtered = filtered.Where("actor", "==", query.Actor)
+	}
+	if !query.Since.IsZero() {
+		filtered = filtered.Where("time", ">=", query.Since)
+	}
+	if !query.Until.IsZero() {
+		filtered = filtered.Where("time", "<", query.Until)
+	}
+
+	listing := firestoreListing[auditDocument]{
+		filtered: filtered,
+		ordered:  filtered.OrderBy("time", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc),
+		startAfter: func(after position) ([]interface{}, error) {
+			recorded, err := time.Parse(cursorTimeLayout, after.Value)
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+			return []interface{}{recorded, after.ID}, nil
+		},
+		at: func(doc auditDocument) position {
+			return auditPosition(doc.record())
+		},
+		descending: true,
+	}
+	page, err := listing.page(ctx, opts)
+	if err != nil {
+		return Page[utils.AuditRecord]{}, err
+	}
+
+	records := Page[utils.AuditRecord]{Items: make([]utils.AuditRecord, 0, len(page.Items)), NextCursor: page.NextCursor, Total: page.Total}
+	for _, doc := range page.Items {
+		records.Items = append(records.Items, doc.record())
+	}
+	return records, nil
+}
+
+// Returns the record stored in the document
+func (d auditDocument) record() utils.AuditRecord {
+	record := utils.AuditRecord{
+		ID:           d.ID,
+		Time:         d.Time,
+		Actor:        d.Actor,
+		Action:       d.Action,
+		ResourceType: d.ResourceType,
+		ResourceID:   d.ResourceID,
+	}
+	if d.Before != "" {
+		record.Before = json.RawMessage(d.Before)
+	}
+	if d.After != "" {
+		record.After = json.RawMessage(d.After)
+	}
+	return record
+}
+
+// Document of a cached response. URLs can not be document IDs, so documents are named after their hash
+type cacheDocument struct {
+	URL     string    `firestore:"url"`
+	Status  int       `firestore:"status"`
+	Body    []byte    `firestore:"body"`
+	Expires time.Time `firestore:"expires"`
+}
+
+// Returns the document of the response cached under the URL
+func (c *FirestoreCache) doc(url string) *firestore.DocumentRef {
+	sum := sha256.Sum256([]byte(url))
+	return c.client.Collection(CacheCollection).Doc(hex.EncodeToString(sum[:]))
+}
+
+// Get returns the response cached under the URL
+func (c *FirestoreCache) Get(ctx context.Context, url string) (utils.CachedResponse, bool, error) {
+	snapshot, err := c.doc(url).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return utils.CachedResponse{}, false, nil
+	}
+	if err != nil {
+		return utils.CachedResponse{}, false, err
+	}
+
+	var doc cacheDocument
+	if err := snapshot.DataTo(&doc); err != nil {
+		return utils.CachedResponse{}, false, err
+	}
+	return utils.CachedResponse{Status: doc.Status, Body: doc.Body, Expires: doc.Expires}, true, nil
+}
+
+// Set caches the response under the URL, replacing an earlier response
+func (c *FirestoreCache) Set(ctx context.Context, url string, response utils.CachedResponse) error {
+	_, err := c.doc(url).Set(ctx, cacheDocument{URL: url, Status: response.Status, Body: response.Body, Expires: response.Expires})
+	return err
+}
+
+// Snapshot as stored in Firestore. The values of the features are JSON of any shape, so the snapshot is kept as JSON
+type snapshotDocument struct {
+	Snapshot string `firestore:"snapshot"`
+}
+
+// Get returns the snapshot of the dashboard
+func (s *FirestoreSnapshots) Get(ctx context.Context, dashboardID string) (utils.DashboardSnapshot, error) {
+	doc, err := getDocument(ctx, s.client, SnapshotCollection, dashboardID)
+	if err != nil {
+		return utils.DashboardSnapshot{}, err
+	}
+
+	var stored snapshotDocument
+	var snapshot utils.DashboardSnapshot
+	if err := doc.DataTo(&stored); err != nil {
+		return snapshot, err
+	}
+	err = json.Unmarshal([]byte(stored.Snapshot), &snapshot)
+	return snapshot, err
+}
+
+// Put replaces the snapshot of the dashboard
+func (s *FirestoreSnapshots) Put(ctx context.Context, dashboardID string, snapshot utils.DashboardSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.Collection(SnapshotCollection).Doc(dashboardID).Set(ctx, snapshotDocument{Snapshot: string(data)})
+	return err
+}
+
+// Delete removes the snapshot of the dashboard
+func (s *FirestoreSnapshots) Delete(ctx context.Context, dashboardID string) error {
+	_, err := s.client.Collection(SnapshotCollection).Doc(dashboardID).Delete(ctx)
+	return err
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Connects to the Firestore emulator and empties the collections, or skips the test if no emulator is configured.
+// Run the emulator with "gcloud emulators firestore start" and set $FIRESTORE_EMULATOR_HOST to enable it
+func emulatorClient(t *testing.T) *firestore.Client {
+	if os.Getenv("FIRESTORE_EMULATOR_HOST") == "" {
+		t.Skip("$FIRESTORE_EMULATOR_HOST is not set")
+	}
+
+	ctx := context.Background()
+	client, err := NewFirestoreClient(ctx, os.Getenv("FIRESTORE_PROJECT_ID"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	for _, collection := range []string{DashboardCollection, WebhookCollection, DashboardTrashCollection, WebhookTrashCollection,
+		APIKeyCollection, AuditCollection, CacheCollection, SnapshotCollection} {
+		docs, err := client.Collection(collection).Documents(ctx).GetAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range docs {
+			if _, err := doc.Ref.Delete(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return client
+}
+
+// Test for the Firestore dashboard store, against the emulator
+func TestFirestoreDashboards(t *testing.T) {
+	testDashboardStore(t, NewFirestoreDashboards(emulatorClient(t)))
+}
+
+// Test of queries on the Firestore dashboard store, against the emulator
+func TestFirestoreDashboardQueries(t *testing.T) {
+	testDashboardQueries(t, NewFirestoreDashboards(emulatorClient(t)))
+}
+
+// Test for the Firestore webhook store, against the emulator
+func TestFirestoreWebhooks(t *testing.T) {
+	testWebhookStore(t, NewFirestoreWebhooks(emulatorClient(t)))
+}
+
+// Test for the Firestore API key store
+func TestFirestoreAPIKeys(t *testing.T) {
+	testAPIKeyStore(t, NewFirestoreAPIKeys(emulatorClient(t)))
+}
+
+// Test for the Firestore audit store
+func TestFirestoreAuditLog(t *testing.T) {
+	testAuditStore(t, NewFirestoreAuditLog(emulatorClient(t)))
+}
+
+// Test for the Firestore shared cache, against the emulator
+func TestFirestoreCache(t *testing.T) {
+	testSharedCache(t, NewFirestoreCache(emulatorClient(t)))
+}
+
+// Test of moving documents with generated document IDs to documents named after their id, against the emulator
+func TestMigrateDocumentIDs(t *testing.T) {
+	client := emulatorClient(t)
+	ctx := context.Background()
+
+	// Documents as created by earlier versions, and one already migrated
+	old, _, err := client.Collection(DashboardCollection).Add(ctx, map[string]interface{}{"id": "abcde", "country": "Norway", "isoCode": "NO"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := client.Collection(WebhookCollection).Add(ctx, map[string]interface{}{"id": "", "url": "http://localhost/", "event": "DELETE"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Collection(WebhookCollection).Doc("fghij").Set(ctx, map[string]interface{}{"id": "fghij", "event": "REGISTER"}); err != nil {
+		t.Fatal(err)
+	}
+
+	moved, err := MigrateDocumentIDs(ctx, client)
+	if err != nil || moved != 2 {
+		t.Fatalf("Expected 2 migrated documents, got %d (%v)", moved, err)
+	}
+
+	dashboard, err := NewFirestoreDashboards(client).Get(ctx, "abcde")
+	if err != nil || dashboard.Country != "Norway" {
+		t.Errorf("Migrated dashboard not found by id: %v %v", dashboard, err)
+	}
+	if _, err := old.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Errorf("Old dashboard document was not deleted: %v", err)
+	}
+
+	hooks, err := NewFirestoreWebhooks(client).List(ctx, "", ListOptions{})
+	if err != nil || len(hooks.Items) != 2 {
+		t.Fatalf("Expected 2 webhooks, got %v (%v)", hooks, err)
+	}
+	for _, hook := range hooks.Items {
+		if _, err := NewFirestoreWebhooks(client).Get(ctx, hook.Id); err != nil || hook.Id == "" {
+			t.Errorf("Webhook %v not found by id: %v", hook, err)
+		}
+	}
+
+	// Running it again changes nothing
+	if moved, err := MigrateDocumentIDs(ctx, client); err != nil || moved != 0 {
+		t.Errorf("Expected no documents to migrate, got %d (%v)", moved, err)
+	}
+}
+
+// Test for the Firestore snapshot store, against the emulator
+func TestFirestoreSnapshots(t *testing.T) {
+	testSnapshotStore(t, NewFirestoreSnapshots(emulatorClient(t)))
+}
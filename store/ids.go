@@ -0,0 +1,78 @@
+package store
+
+import (
+	"assignment2/utils"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Prefixes of the IDs given by the "prefixed" strategy
+var IDPrefixes = map[string]string{
+	DashboardCollection: "dsh_",
+	WebhookCollection:   "whk_",
+	APIKeyCollection:    "key_",
+	AuditCollection:     "aud_",
+}
+
+// Generator used by stores until SetIDGenerator is called
+var defaultIDs utils.IDGenerator = utils.RandomIDs(DefaultIDLength)
+
+// errIDTaken is returned by the create function given to createWithUniqueID when the ID is already in use
+var errIDTaken = errors.New("id already in use")
+
+// Creates the ID generator for a strategy: "random" (default), "ulid" or "prefixed". The random characters of the
+// random and prefixed IDs are of the length, from MinIDLength to MaxIDLength. ULIDs always have 26 characters
+func NewIDGenerator(strategy string, length int) (utils.IDGenerator, error) {
+	if length < MinIDLength || length > MaxIDLength {
+		return nil, fmt.Errorf("invalid ID length %d. Expected %d to %d characters", length, MinIDLength, MaxIDLength)
+	}
+
+	switch strategy {
+	case "", "random":
+		return utils.RandomIDs(length), nil
+	case "ulid":
+		return utils.ULIDs{}, nil
+	case "prefixed":
+		return utils.PrefixedIDs{Prefixes: IDPrefixes, Next: utils.RandomIDs(length)}, nil
+	default:
+		return nil, errors.New("unknown ID strategy " + strategy + ". Supported: random, ulid, prefixed")
+	}
+}
+
+// idSource is embedded in the stores, and holds the generator of their new IDs
+type idSource struct {
+	ids utils.IDGenerator
+}
+
+// SetIDGenerator replaces the generator of new IDs
+func (s *idSource) SetIDGenerator(ids utils.IDGenerator) {
+	s.ids = ids
+}
+
+// Generates IDs for the collection and passes them to create, until create succeeds or fails with another
+// error than errIDTaken. create must check that the ID is free and store the document in one atomic operation
+func (s *idSource) createWithUniqueID(collection string, create func(id string) error) (string, error) {
+	ids := s.ids
+	if ids == nil {
+		ids = defaultIDs
+	}
+
+	for {
+		id, err := ids.NewID(collection)
+		if err != nil {
+			return "", err
+		}
+
+		err = create(id)
+		if err == nil {
+			return id, nil
+		}
+		if !errors.Is(err, errIDTaken) {
+			return "", err
+		}
+
+		// ID already exists, generating a new one
+		log.Println("ID already exists in " + collection + "...generating new one")
+	}
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"strings"
+	"testing"
+)
+
+// Generator returning the given IDs in order
+type fixedIDs []string
+
+// NewID implements utils.IDGenerator
+func (f *fixedIDs) NewID(string) (string, error) {
+	id := (*f)[0]
+	*f = (*f)[1:]
+	return id, nil
+}
+
+// Test that a taken ID is not reused, and a new one generated instead
+func TestCreateRetriesTakenID(t *testing.T) {
+	ctx := context.Background()
+	webhooks := NewMemoryWebhooks()
+	webhooks.SetIDGenerator(&fixedIDs{"first", "first", "second"})
+
+	for _, want := range []string{"first", "second"} {
+		id, err := webhooks.Create(ctx, utils.WebhookGetResponse{Event: "REGISTER"})
+		if err != nil || id != want {
+			t.Errorf("Expected id %v, got %v (%v)", want, id, err)
+		}
+	}
+}
+
+// Test for the ID strategies
+func TestNewIDGenerator(t *testing.T) {
+	ctx := context.Background()
+
+	ids, err := NewIDGenerator("prefixed", DefaultIDLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dashboards, webhooks := NewMemoryDashboards(), NewMemoryWebhooks()
+	dashboards.SetIDGenerator(ids)
+	webhooks.SetIDGenerator(ids)
+
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{Country: "Norway"})
+	if err != nil || !strings.HasPrefix(id, "dsh_") || len(id) != len("dsh_")+DefaultIDLength {
+		t.Errorf("Expected dashboard id starting with dsh_, got %v (%v)", id, err)
+	}
+	id, err = webhooks.Create(ctx, utils.WebhookGetResponse{Event: "REGISTER"})
+	if err != nil || !strings.HasPrefix(id, "whk_") {
+		t.Errorf("Expected webhook id starting with whk_, got %v (%v)", id, err)
+	}
+
+	for _, strategy := range []string{"", "random", "ulid"} {
+		if _, err := NewIDGenerator(strategy, DefaultIDLength); err != nil {
+			t.Errorf("Strategy %q: %v", strategy, err)
+		}
+	}
+	if _, err := NewIDGenerator("sequential", DefaultIDLength); err == nil {
+		t.Error("Expected error for unknown strategy")
+	}
+
+	// Random IDs are of the chosen length, within the limits
+	ids, err = NewIDGenerator("random", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, err := ids.NewID(DashboardCollection); err != nil || len(id) != 12 {
+		t.Errorf("Expected an id of 12 characters, got %v (%v)", id, err)
+	}
+	for _, length := range []int{0, MinIDLength - 1, MaxIDLength + 1} {
+		if _, err := NewIDGenerator("random", length); err == nil {
+			t.Errorf("Expected error for the ID length %d", length)
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryDashboards is a DashboardStore that keeps configurations in memory, they are lost on restart
+type MemoryDashboards struct {
+	idSource
+	mu         sync.RWMutex
+	dashboards map[string]utils.Dashboard_Get
+	revisions  map[string][]utils.Dashboard_Get
+	trash      map[string]Trashed[utils.Dashboard_Get]
+}
+
+// MemoryWebhooks is a WebhookStore that keeps webhooks in memory, they are lost on restart
+type MemoryWebhooks struct {
+	idSource
+	mu    sync.RWMutex
+	hooks map[string]utils.WebhookGetResponse
+	trash map[string]Trashed[utils.WebhookGetResponse]
+}
+
+// MemoryAPIKeys is an APIKeyStore that keeps keys in memory, they are lost on restart
+type MemoryAPIKeys struct {
+	idSource
+	mu   sync.RWMutex
+	keys map[string]utils.APIKey
+}
+
+// MemoryAuditLog is an AuditStore that keeps records in memory, they are lost on restart
+type MemoryAuditLog struct {
+	idSource
+	mu      sync.RWMutex
+	records map[string]utils.AuditRecord
+}
+
+// MemorySnapshots is a SnapshotStore that keeps snapshots in memory, they are lost on restart
+type MemorySnapshots struct {
+	mu        sync.RWMutex
+	snapshots map[string]utils.DashboardSnapshot
+}
+
+// Creates an empty in-memory SnapshotStore
+func NewMemorySnapshots() *MemorySnapshots {
+	return &MemorySnapshots{snapshots: make(map[string]utils.DashboardSnapshot)}
+}
+
+// Creates an empty in-memory DashboardStore
+func NewMemoryDashboards() *MemoryDashboards {
+	return &MemoryDashboards{
+		dashboards: make(map[string]utils.Dashboard_Get),
+		revisions:  make(map[string][]utils.Dashboard_Get),
+		trash:      make(map[string]Trashed[utils.Dashboard_Get]),
+	}
+}
+
+// Creates an empty in-memory WebhookStore
+func NewMemoryWebhooks() *MemoryWebhooks {
+	return &MemoryWebhooks{
+		hooks: make(map[string]utils.WebhookGetResponse),
+		trash: make(map[string]Trashed[utils.WebhookGetResponse]),
+	}
+}
+
+// Creates an empty in-memory APIKeyStore
+func NewMemoryAPIKeys() *MemoryAPIKeys {
+	return &MemoryAPIKeys{keys: make(map[string]utils.APIKey)}
+}
+
+// Creates an empty in-memory AuditStore
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{records: make(map[string]utils.AuditRecord)}
+}
+
+// Copies the configuration, so callers can not change the stored currency slice
+func copyDashboard(dashboard utils.Dashboard_Get) utils.Dashboard_Get {
+	if dashboard.Features.TargetCurrencies != nil {
+		dashboard.Features.TargetCurrencies = append([]string{}, dashboard.Features.TargetCurrencies...)
+	}
+	return dashboard
+}
+
+// Copies the key, so callers can not change the stored role slice
+func copyAPIKey(key utils.APIKey) utils.APIKey {
+	if key.Roles != nil {
+		key.Roles = append([]string{}, key.Roles...)
+	}
+	return key
+}
+
+// Get returns the dashboard configuration with the given id
+func (s *MemoryDashboards) Get(_ context.Context, id string) (utils.Dashboard_Get, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dashboard, ok := s.dashboards[id]
+	if !ok {
+		return utils.Dashboard_Get{}, ErrNotFound
+	}
+	return copyDashboard(dashboard), nil
+}
+
+// List returns a page of the dashboard configurations selected by the query
+func (s *MemoryDashboards) List(_ context.Context, query DashboardQuery, opts ListOptions) (Page[utils.Dashboard_Get], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	dashboards := make([]utils.Dashboard_Get, 0, len(s.dashboards))
+	for _, dashboard := range s.dashboards {
+		dashboards = append(dashboards, copyDashboard(dashboard))
+	}
+	return dashboardPage(dashboards, query, opts)
+}
+
+// Create stores the configuration under a unique id
+func (s *MemoryDashboards) Create(_ context.Context, dashboard utils.Dashboard_Get) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createWithUniqueID(DashboardCollection, func(id string) error {
+		if _, taken := s.dashboards[id]; taken {
+			return errIDTaken
+		}
+		if _, trashed := s.trash[id]; trashed {
+			return errIDTaken
+		}
+		dashboard.ID = id
+		dashboard.Revision = 1
+		s.dashboards[id] = copyDashboard(storedDashboard(dashboard))
+		s.revisions[id] = []utils.Dashboard_Get{s.dashboards[id]}
+		return nil
+	})
+}
+
+// Update stores the configuration as the next revision
+func (s *MemoryDashboards) Update(_ context.Context, dashboard utils.Dashboard_Get, ifRevision int) (utils.Dashboard_Get, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.dashboards[dashboard.ID]
+	if !ok {
+		return utils.Dashboard_Get{}, ErrNotFound
+	}
+	if err := checkRevision(current, ifRevision); err != nil {
+		return utils.Dashboard_Get{}, err
+	}
+
+	next, legacy := nextRevision(current, dashboard)
+	if legacy != nil {
+		s.revisions[dashboard.ID] = append(s.revisions[dashboard.ID], *legacy)
+	}
+	s.dashboards[dashboard.ID] = copyDashboard(next)
+	s.revisions[dashboard.ID] = append(s.revisions[dashboard.ID], copyDashboard(next))
+	return copyDashboard(next), nil
+}
+
+// Revisions returns every revision of the configuration, oldest first
+func (s *MemoryDashboards) Revisions(_ context.Context, id string) ([]utils.Dashboard_Get, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	current, ok := s.dashboards[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	revisions := make([]utils.Dashboard_Get, 0, len(s.revisions[id]))
+	for _, revision := range historyOf(current, s.revisions[id]) {
+		revisions = append(revisions, copyDashboard(revision))
+	}
+	return revisions, nil
+}
+
+// Revision returns revision n of the configuration
+func (s *MemoryDashboards) Revision(ctx context.Context, id string, n int) (utils.Dashboard_Get, error) {
+	revisions, err := s.Revisions(ctx, id)
+	if err != nil {
+		return utils.Dashboard_Get{}, err
+	}
+	return revisionOf(revisions, n)
+}
+
+// Delete moves the configuration to the trash, its revisions are kept until it is purged
+func (s *MemoryDashboards) Delete(_ context.Context, id string, ifRevision int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.dashboards[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := checkRevision(current, ifRevision); err != nil {
+		return err
+	}
+	delete(s.dashboards, id)
+	s.trash[id] = trash(copyDashboard(current))
+	return nil
+}
+
+// Trash returns a page of the deleted configurations, sorted by id
+func (s *MemoryDashboards) Trash(_ context.Context, owner string, opts ListOptions) (Page[Trashed[utils.Dashboard_Get]], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trashed := make([]Trashed[utils.Dashboard_Get], 0, len(s.trash))
+	for _, item := range sortedTrash(s.trash) {
+		if ownedBy(item.Item.Owner, owner) {
+			item.Item = copyDashboard(item.Item)
+			trashed = append(trashed, item)
+		}
+	}
+	return pageOfSorted(trashed, opts, trashedDashboardPosition, false)
+}
+
+// Restore moves the configuration back from the trash
+func (s *MemoryDashboards) Restore(_ context.Context, id string, owner string) (utils.Dashboard_Get, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trashed, ok := s.trash[id]
+	if !ok || !ownedBy(trashed.Item.Owner, owner) {
+		return utils.Dashboard_Get{}, ErrNotFound
+	}
+	delete(s.trash, id)
+	s.dashboards[id] = trashed.Item
+	return copyDashboard(trashed.Item), nil
+}
+
+// Purge removes the configurations deleted before the given time, with their revisions
+func (s *MemoryDashboards) Purge(_ context.Context, deletedBefore time.Time) ([]utils.Dashboard_Get, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := make([]utils.Dashboard_Get, 0)
+	for _, trashed := range sortedTrash(s.trash) {
+		if trashed.DeletedAt.Before(deletedBefore) {
+			delete(s.trash, trashed.Item.ID)
+			delete(s.revisions, trashed.Item.ID)
+			purged = append(purged, trashed.Item)
+		}
+	}
+	return purged, nil
+}
+
+// Get returns the webhook with the given id
+func (s *MemoryWebhooks) Get(_ context.Context, id string) (utils.WebhookGetResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hook, ok := s.hooks[id]
+	if !ok {
+		return utils.WebhookGetResponse{}, ErrNotFound
+	}
+	return hook, nil
+}
+
+// List returns a page of the webhooks of the owner, sorted by id
+func (s *MemoryWebhooks) List(_ context.Context, owner string, opts ListOptions) (Page[utils.WebhookGetResponse], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hooks := s.filter(func(hook utils.WebhookGetResponse) bool { return ownedBy(hook.Owner, owner) })
+	return pageOfSorted(hooks, opts, webhookPosition, false)
+}
+
+// Create stores the webhook under a unique id
+func (s *MemoryWebhooks) Create(_ context.Context, hook utils.WebhookGetResponse) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createWithUniqueID(WebhookCollection, func(id string) error {
+		if _, taken := s.hooks[id]; taken {
+			return errIDTaken
+		}
+		if _, trashed := s.trash[id]; trashed {
+			return errIDTaken
+		}
+		hook.Id = id
+		s.hooks[id] = hook
+		return nil
+	})
+}
+
+// Delete moves the webhook to the trash
+func (s *MemoryWebhooks) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hook, ok := s.hooks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(s.hooks, id)
+	s.trash[id] = trash(hook)
+	return nil
+}
+
+// Trash returns a page of the deleted webhooks, sorted by id
+func (s *MemoryWebhooks) Trash(_ context.Context, owner string, opts ListOptions) (Page[Trashed[utils.WebhookGetResponse]], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	trashed := make([]Trashed[utils.WebhookGetResponse], 0, len(s.trash))
+	for _, item := range sortedTrash(s.trash) {
+		if ownedBy(item.Item.Owner, owner) {
+			trashed = append(trashed, item)
+		}
+	}
+	return pageOfSorted(trashed, opts, trashedWebhookPosition, false)
+}
+
+// Restore moves the webhook back from the trash
+func (s *MemoryWebhooks) Restore(_ context.Context, id string, owner string) (utils.WebhookGetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trashed, ok := s.trash[id]
+	if !ok || !ownedBy(trashed.Item.Owner, owner) {
+		return utils.WebhookGetResponse{}, ErrNotFound
+	}
+	delete(s.trash, id)
+	s.hooks[id] = trashed.Item
+	return trashed.Item, nil
+}
+
+// Purge removes the webhooks deleted before the given time
+func (s *MemoryWebhooks) Purge(_ context.Context, deletedBefore time.Time) ([]utils.WebhookGetResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := make([]utils.WebhookGetResponse, 0)
+	for _, trashed := range sortedTrash(s.trash) {
+		if trashed.DeletedAt.Before(deletedBefore) {
+			delete(s.trash, trashed.Item.Id)
+			purged = append(purged, trashed.Item)
+		}
+	}
+	return purged, nil
+}
+
+// Matching returns the webhooks of the owner registered for the event on the country, or on all countries
+func (s *MemoryWebhooks) Matching(_ context.Context, event string, isoCode string, owner string) ([]utils.WebhookGetResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.filter(func(hook utils.WebhookGetResponse) bool {
+		return matchesEvent(hook, event, isoCode, owner)
+	}), nil
+}
+
+// Count returns the number of stored webhooks
+func (s *MemoryWebhooks) Count(_ context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.hooks), nil
+}
+
+// Returns the webhooks accepted by keep, sorted by id. The caller must hold the lock
+func (s *MemoryWebhooks) filter(keep func(utils.WebhookGetResponse) bool) []utils.WebhookGetResponse {
+	hooks := make([]utils.WebhookGetResponse, 0)
+	for _, hook := range s.hooks {
+		if keep(hook) {
+			hooks = append(hooks, hook)
+		}
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].Id < hooks[j].Id
+	})
+	return hooks
+}
+
+// Get returns the key with the given id
+func (s *MemoryAPIKeys) Get(_ context.Context, id string) (utils.APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return utils.APIKey{}, ErrNotFound
+	}
+	return copyAPIKey(key), nil
+}
+
+// List returns a page of the keys, sorted by id
+func (s *MemoryAPIKeys) List(_ context.Context, opts ListOptions) (Page[utils.APIKey], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]utils.APIKey, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, copyAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return pageOfSorted(keys, opts, apiKeyPosition, false)
+}
+
+// Create stores the key under a unique id
+func (s *MemoryAPIKeys) Create(_ context.Context, key utils.APIKey) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createWithUniqueID(APIKeyCollection, func(id string) error {
+		if _, taken := s.keys[id]; taken {
+			return errIDTaken
+		}
+		key.ID = id
+		s.keys[id] = copyAPIKey(key)
+		return nil
+	})
+}
+
+// Revoke marks the key as revoked
+func (s *MemoryAPIKeys) Revoke(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	key.Revoked = true
+	s.keys[id] = key
+	return nil
+}
+
+// Record stores the record under a unique id
+func (s *MemoryAuditLog) Record(_ context.Context, record utils.AuditRecord) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record = storedAuditRecord(record)
+	return s.createWithUniqueID(AuditCollection, func(id string) error {
+		if _, taken := s.records[id]; taken {
+			return errIDTaken
+		}
+		record.ID = id
+		s.records[id] = record
+		return nil
+	})
+}
+
+// List returns a page of the records selected by the query, newest first
+func (s *MemoryAuditLog) List(_ context.Context, query AuditQuery, opts ListOptions) (Page[utils.AuditRecord], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	records := make([]utils.AuditRecord, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return auditPage(records, query, opts)
+}
+
+// Get returns the snapshot of the dashboard
+func (s *MemorySnapshots) Get(_ context.Context, dashboardID string) (utils.DashboardSnapshot, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	snapshot, ok := s.snapshots[dashboardID]
+	if !ok {
+		return utils.DashboardSnapshot{}, ErrNotFound
+	}
+	return snapshot, nil
+}
+
+// Put replaces the snapshot of the dashboard
+func (s *MemorySnapshots) Put(_ context.Context, dashboardID string, snapshot utils.DashboardSnapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Kept as a copy, so later changes of the caller's map are not seen
+	features := make(map[string]utils.SnapshotFeature, len(snapshot.Features))
+	for feature, value := range snapshot.Features {
+		features[feature] = value
+	}
+	s.snapshots[dashboardID] = utils.DashboardSnapshot{Revision: snapshot.Revision, Features: features}
+	return nil
+}
+
+// Delete removes the snapshot of the dashboard
+func (s *MemorySnapshots) Delete(_ context.Context, dashboardID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.snapshots, dashboardID)
+	return nil
+}
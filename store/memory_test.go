@@ -0,0 +1,57 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"testing"
+)
+
+// Test for the in-memory dashboard store
+func TestMemoryDashboards(t *testing.T) {
+	testDashboardStore(t, NewMemoryDashboards())
+}
+
+// Test of queries on the in-memory dashboard store
+func TestMemoryDashboardQueries(t *testing.T) {
+	testDashboardQueries(t, NewMemoryDashboards())
+}
+
+// Test for the in-memory webhook store
+func TestMemoryWebhooks(t *testing.T) {
+	testWebhookStore(t, NewMemoryWebhooks())
+}
+
+// Test for the in-memory API key store
+func TestMemoryAPIKeys(t *testing.T) {
+	testAPIKeyStore(t, NewMemoryAPIKeys())
+}
+
+// Test that configurations stored before revisions were kept become revision 1 of their history
+func TestMemoryLegacyRevisions(t *testing.T) {
+	ctx := context.Background()
+	dashboards := NewMemoryDashboards()
+	dashboards.dashboards["old"] = utils.Dashboard_Get{ID: "old", Country: "Norway"}
+
+	revisions, err := dashboards.Revisions(ctx, "old")
+	if err != nil || len(revisions) != 1 || revisions[0].Revision != 1 || revisions[0].Country != "Norway" {
+		t.Errorf("Expected the stored configuration as revision 1, got %v, %v", revisions, err)
+	}
+
+	if _, err := dashboards.Update(ctx, utils.Dashboard_Get{ID: "old", Country: "Sweden"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	revisions, err = dashboards.Revisions(ctx, "old")
+	if err != nil || len(revisions) != 2 || revisions[0].Country != "Norway" || revisions[1].Revision != 2 {
+		t.Errorf("Expected revisions 1 and 2, got %v, %v", revisions, err)
+	}
+}
+
+// Test for the in-memory audit store
+func TestMemoryAuditLog(t *testing.T) {
+	testAuditStore(t, NewMemoryAuditLog())
+}
+
+// Test for the in-memory snapshot store
+func TestMemorySnapshots(t *testing.T) {
+	testSnapshotStore(t, NewMemorySnapshots())
+}
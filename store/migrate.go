@@ -0,0 +1,71 @@
+package store
+
+import (
+	"context"
+	"log"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// MigrateDocumentIDs moves documents created with generated Firestore document IDs, to documents
+// named after their "id" field. Documents without an id get a new unique one.
+// Documents whose id is already used as a document ID are logged and left in place.
+// Returns the number of moved documents
+func MigrateDocumentIDs(ctx context.Context, client *firestore.Client) (int, error) {
+	moved := 0
+	for _, collection := range []string{DashboardCollection, WebhookCollection} {
+		docs, err := client.Collection(collection).Documents(ctx).GetAll()
+		if err != nil {
+			return moved, err
+		}
+
+		for _, doc := range docs {
+			id, _ := doc.Data()["id"].(string)
+			if id == doc.Ref.ID {
+				continue
+			}
+
+			err := migrateDocument(ctx, client, collection, doc, id)
+			if status.Code(err) == codes.AlreadyExists {
+				log.Println("Not migrating " + collection + "/" + doc.Ref.ID + ", id " + id + " is already in use")
+				continue
+			}
+			if err != nil {
+				return moved, err
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// Copies the document to the document named id (or a new unique id if empty) and deletes the original,
+// in one transaction
+func migrateDocument(ctx context.Context, client *firestore.Client, collection string, doc *firestore.DocumentSnapshot, id string) error {
+	move := func(id string) error {
+		data := doc.Data()
+		data["id"] = id
+
+		return client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+			if err := tx.Create(client.Collection(collection).Doc(id), data); err != nil {
+				return err
+			}
+			return tx.Delete(doc.Ref, firestore.Exists)
+		})
+	}
+
+	if id != "" {
+		return move(id)
+	}
+
+	_, err := (&idSource{}).createWithUniqueID(collection, func(id string) error {
+		err := move(id)
+		if status.Code(err) == codes.AlreadyExists {
+			return errIDTaken
+		}
+		return err
+	})
+	return err
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+)
+
+// Largest page a listing returns, and the size used when no limit is given
+const MaxPageSize = 1000
+const DefaultPageSize = 100
+
+// ErrInvalidCursor is returned when a listing is given a cursor it did not return
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ListOptions selects the page of a listing
+type ListOptions struct {
+	// Maximum number of items on the page, DefaultPageSize if 0
+	Limit int
+	// Cursor returned with the previous page, or "" for the first page
+	Cursor string
+}
+
+// Page is one page of a listing
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// Cursor of the next page, empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
+	// Number of items in the whole listing
+	Total int `json:"total"`
+}
+
+// Position of an item in a listing, ordered by the value it is sorted on and then by id.
+// Cursors are encoded positions of the last item on a page
+type position struct {
+	Value string `json:"v,omitempty"`
+	ID    string `json:"id"`
+}
+
+// Returns the page size to use for the options
+func (o ListOptions) limit() int {
+	if o.Limit <= 0 {
+		return DefaultPageSize
+	}
+	if o.Limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return o.Limit
+}
+
+// Returns the position encoded in the cursor of the options, and whether there is one
+func (o ListOptions) after() (position, bool, error) {
+	var p position
+	if o.Cursor == "" {
+		return p, false, nil
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return p, false, ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &p); err != nil || p.ID == "" {
+		return p, false, ErrInvalidCursor
+	}
+	return p, true, nil
+}
+
+// Encodes the position as cursor
+func (p position) cursor() string {
+	data, _ := json.Marshal(p)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Reports whether p comes before q in a listing, which is in descending order if descending is set
+func (p position) before(q position, descending bool) bool {
+	if p == q {
+		return false
+	}
+	less := p.Value < q.Value || (p.Value == q.Value && p.ID < q.ID)
+	return less != descending
+}
+
+// Makes a page from items fetched after the cursor, where one item more than the limit was fetched
+// if there are more pages
+func pageFrom[T any](items []T, limit int, total int, at func(T) position) Page[T] {
+	page := Page[T]{Items: items, Total: total}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.NextCursor = at(items[limit-1]).cursor()
+	}
+	return page
+}
+
+// Returns the page of items, which must be sorted by position
+func pageOfSorted[T any](items []T, opts ListOptions, at func(T) position, descending bool) (Page[T], error) {
+	after, ok, err := opts.after()
+	if err != nil {
+		return Page[T]{}, err
+	}
+
+	start := 0
+	if ok {
+		start = sort.Search(len(items), func(i int) bool { return after.before(at(items[i]), descending) })
+	}
+
+	limit := opts.limit()
+	end := start + limit + 1
+	if end > len(items) {
+		end = len(items)
+	}
+	return pageFrom(items[start:end], limit, len(items), at), nil
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"assignment2/utils"
+	"errors"
+	"sort"
+	"time"
+)
+
+// Orders of dashboard listings
+const (
+	SortByID         = "id"
+	SortByLastChange = "lastChange"
+	SortByCountry    = "country"
+)
+
+// Layout of lastChange in cursors: fixed width, so cursors of later changes sort after earlier ones
+const cursorTimeLayout = "2006-01-02T15:04:05.000000Z"
+
+// DashboardQuery selects and orders the configurations of a listing. Empty fields select all configurations
+type DashboardQuery struct {
+	// Country name, as registered
+	Country string
+	// Configurations for any of these isocodes
+	IsoCodes []string
+	// Configurations with all these features switched on
+	Features []string
+	// Configurations with this target currency
+	Currency string
+	// Configurations changed at or after this time
+	ChangedSince time.Time
+	// SortByID (default), SortByLastChange or SortByCountry
+	Sort string
+	// Descending order instead of ascending
+	Descending bool
+	// Configurations of this owner, or of any owner if empty
+	Owner string
+}
+
+// Checks that the sort order and features of the query are known. Configurations changed since a time are only
+// listed in order of lastChange, so the filter is done by the index of the order
+func (q DashboardQuery) Validate() error {
+	switch q.Sort {
+	case "", SortByID, SortByLastChange, SortByCountry:
+	default:
+		return errors.New("unknown sort order " + q.Sort + ". Supported: id, lastChange, country")
+	}
+	if !q.ChangedSince.IsZero() && q.Sort != SortByLastChange {
+		return errors.New("changedSince can only be combined with the sort order lastChange")
+	}
+
+	for _, feature := range q.Features {
+		if _, known := (utils.Features_Get{}).Enabled(feature); !known {
+			return errors.New("unknown feature " + feature)
+		}
+	}
+	return nil
+}
+
+// Reports whether the query selects all configurations in order of id
+func (q DashboardQuery) isZero() bool {
+	return q.Country == "" && len(q.IsoCodes) == 0 && len(q.Features) == 0 && q.Currency == "" && q.Owner == "" &&
+		q.ChangedSince.IsZero() && (q.Sort == "" || q.Sort == SortByID) && !q.Descending
+}
+
+// Reports whether the configuration is selected by the query
+func (q DashboardQuery) matches(dashboard utils.Dashboard_Get) bool {
+	if q.Country != "" && dashboard.Country != q.Country {
+		return false
+	}
+
+	if !ownedBy(dashboard.Owner, q.Owner) {
+		return false
+	}
+
+	if len(q.IsoCodes) > 0 {
+		found := false
+		for _, isoCode := range q.IsoCodes {
+			found = found || dashboard.IsoCode == isoCode
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, feature := range q.Features {
+		if enabled, _ := dashboard.Features.Enabled(feature); !enabled {
+			return false
+		}
+	}
+
+	if q.Currency != "" && !dashboard.Features.HasCurrency(q.Currency) {
+		return false
+	}
+	return q.ChangedSince.IsZero() || !dashboard.LastChange.Before(q.ChangedSince)
+}
+
+// Returns the position of the configuration in listings with the sort order of the query
+func (q DashboardQuery) position(dashboard utils.Dashboard_Get) position {
+	switch q.Sort {
+	case SortByLastChange:
+		return position{Value: dashboard.LastChange.UTC().Format(cursorTimeLayout), ID: dashboard.ID}
+	case SortByCountry:
+		return position{Value: dashboard.Country, ID: dashboard.ID}
+	default:
+		return position{ID: dashboard.ID}
+	}
+}
+
+// Selects, sorts and pages the configurations in memory, for stores that can not query
+func dashboardPage(all []utils.Dashboard_Get, query DashboardQuery, opts ListOptions) (Page[utils.Dashboard_Get], error) {
+	dashboards := make([]utils.Dashboard_Get, 0, len(all))
+	for _, dashboard := range all {
+		if query.matches(dashboard) {
+			dashboards = append(dashboards, dashboard)
+		}
+	}
+
+	sort.Slice(dashboards, func(i, j int) bool {
+		return query.position(dashboards[i]).before(query.position(dashboards[j]), query.Descending)
+	})
+	return pageOfSorted(dashboards, opts, query.position, query.Descending)
+}
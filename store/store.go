@@ -0,0 +1,199 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"errors"
+	"time"
+)
+
+// name of collection used for dashboards
+const DashboardCollection = "Dashboard"
+
+// name of collection used for webhooks
+const WebhookCollection = "webhooks"
+
+// name of collection used for the revisions of dashboards
+const RevisionCollection = "revisions"
+
+// name of collection used for API keys
+const APIKeyCollection = "apiKeys"
+
+// name of collection used for the cached responses of upstream APIs
+const CacheCollection = "upstreamCache"
+
+// name of collection used for the snapshots of the last rendered dashboards
+const SnapshotCollection = "snapshots"
+
+// Length of the random IDs given to new documents by default, and the shortest and longest that can be chosen
+const (
+	DefaultIDLength = 5
+	MinIDLength     = 4
+	MaxIDLength     = 64
+)
+
+// ErrNotFound is returned when no document has the requested ID
+var ErrNotFound = errors.New("document not found")
+
+// ErrConflict is returned when a write is made on another revision than the current one
+var ErrConflict = errors.New("document has been changed")
+
+// AnyRevision is passed as the expected revision to write on whatever revision is current
+const AnyRevision = 0
+
+// DashboardStore persists the registered dashboard configurations
+type DashboardStore interface {
+	// Get returns the configuration with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (utils.Dashboard_Get, error)
+	// List returns a page of the registered configurations selected by the query, in the order of the query
+	List(ctx context.Context, query DashboardQuery, opts ListOptions) (Page[utils.Dashboard_Get], error)
+	// Create stores a new configuration under a newly generated unique ID, which is returned, as revision 1
+	Create(ctx context.Context, dashboard utils.Dashboard_Get) (string, error)
+	// Update stores the configuration as the next revision of the one with dashboard.ID, and returns
+	// the stored configuration. Returns ErrNotFound if there is none, and ErrConflict if
+	// ifRevision is not AnyRevision or the current revision
+	Update(ctx context.Context, dashboard utils.Dashboard_Get, ifRevision int) (utils.Dashboard_Get, error)
+	// Revisions returns every revision of the configuration, oldest first, or ErrNotFound
+	Revisions(ctx context.Context, id string) ([]utils.Dashboard_Get, error)
+	// Revision returns revision n of the configuration, or ErrNotFound
+	Revision(ctx context.Context, id string, n int) (utils.Dashboard_Get, error)
+	// Delete moves the configuration with the given ID to the trash, or returns ErrNotFound.
+	// Returns ErrConflict if ifRevision is not AnyRevision or the current revision
+	Delete(ctx context.Context, id string, ifRevision int) error
+	// Trash returns a page of the deleted configurations of the owner, or of any owner if it is empty, sorted by ID
+	Trash(ctx context.Context, owner string, opts ListOptions) (Page[Trashed[utils.Dashboard_Get]], error)
+	// Restore moves the configuration with the given ID back from the trash and returns it. Returns ErrNotFound
+	// if it is not in the trash, or belongs to another owner than a non-empty owner
+	Restore(ctx context.Context, id string, owner string) (utils.Dashboard_Get, error)
+	// Purge permanently removes the configurations deleted before the given time, with their revisions, and returns them
+	Purge(ctx context.Context, deletedBefore time.Time) ([]utils.Dashboard_Get, error)
+	// SetIDGenerator replaces the generator of the IDs of new configurations
+	SetIDGenerator(ids utils.IDGenerator)
+}
+
+// WebhookStore persists the registered webhooks
+type WebhookStore interface {
+	// Get returns the webhook with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (utils.WebhookGetResponse, error)
+	// List returns a page of the registered webhooks of the owner, or of any owner if it is empty
+	List(ctx context.Context, owner string, opts ListOptions) (Page[utils.WebhookGetResponse], error)
+	// Create stores a new webhook under a newly generated unique ID, which is returned
+	Create(ctx context.Context, hook utils.WebhookGetResponse) (string, error)
+	// Delete moves the webhook with the given ID to the trash, or returns ErrNotFound
+	Delete(ctx context.Context, id string) error
+	// Trash returns a page of the deleted webhooks of the owner, or of any owner if it is empty, sorted by ID
+	Trash(ctx context.Context, owner string, opts ListOptions) (Page[Trashed[utils.WebhookGetResponse]], error)
+	// Restore moves the webhook with the given ID back from the trash and returns it. Returns ErrNotFound
+	// if it is not in the trash, or belongs to another owner than a non-empty owner
+	Restore(ctx context.Context, id string, owner string) (utils.WebhookGetResponse, error)
+	// Purge permanently removes the webhooks deleted before the given time, and returns them
+	Purge(ctx context.Context, deletedBefore time.Time) ([]utils.WebhookGetResponse, error)
+	// Matching returns the webhooks of the owner registered for the event on the given country, or on all countries.
+	// Webhooks of any owner match if owner is empty
+	Matching(ctx context.Context, event string, isoCode string, owner string) ([]utils.WebhookGetResponse, error)
+	// Count returns the number of registered webhooks
+	Count(ctx context.Context) (int, error)
+	// SetIDGenerator replaces the generator of the IDs of new webhooks
+	SetIDGenerator(ids utils.IDGenerator)
+}
+
+// APIKeyStore persists the API keys, with the hashes of their secrets
+type APIKeyStore interface {
+	// Get returns the key with the given ID, or ErrNotFound
+	Get(ctx context.Context, id string) (utils.APIKey, error)
+	// List returns a page of the keys, revoked ones included, sorted by ID
+	List(ctx context.Context, opts ListOptions) (Page[utils.APIKey], error)
+	// Create stores a new key under a newly generated unique ID, which is returned
+	Create(ctx context.Context, key utils.APIKey) (string, error)
+	// Revoke marks the key with the given ID as revoked, or returns ErrNotFound
+	Revoke(ctx context.Context, id string) error
+	// SetIDGenerator replaces the generator of the IDs of new keys
+	SetIDGenerator(ids utils.IDGenerator)
+}
+
+// SnapshotStore persists the last dashboard rendered from every configuration
+type SnapshotStore interface {
+	// Get returns the snapshot of the dashboard with the given ID, or ErrNotFound if it has none
+	Get(ctx context.Context, dashboardID string) (utils.DashboardSnapshot, error)
+	// Put replaces the snapshot of the dashboard with the given ID
+	Put(ctx context.Context, dashboardID string, snapshot utils.DashboardSnapshot) error
+	// Delete removes the snapshot of the dashboard with the given ID, if it has one
+	Delete(ctx context.Context, dashboardID string) error
+}
+
+// Stores keep lastChange the way Firestore does: in UTC, with microsecond precision
+func storedDashboard(dashboard utils.Dashboard_Get) utils.Dashboard_Get {
+	dashboard.LastChange = dashboard.LastChange.UTC().Truncate(time.Microsecond)
+	return dashboard
+}
+
+// Numbers the configuration as the revision after current, the stored configuration, and keeps its owner.
+// Configurations stored before revisions were kept have revision 0. They are kept as revision 1,
+// which is returned as legacy to be added to the history before the new revision
+func nextRevision(current utils.Dashboard_Get, dashboard utils.Dashboard_Get) (next utils.Dashboard_Get, legacy *utils.Dashboard_Get) {
+	if current.Revision == 0 {
+		current.Revision = 1
+		legacy = &current
+	}
+	dashboard.Revision = current.Revision + 1
+	dashboard.Owner = current.Owner
+	return storedDashboard(dashboard), legacy
+}
+
+// Reports whether an item with the owner is selected by a listing for the scope owner, where an empty scope selects all
+func ownedBy(owner string, scope string) bool {
+	return scope == "" || owner == scope
+}
+
+// Returns the revision number of the stored configuration, where one stored before revisions were kept is revision 1
+func RevisionNumber(dashboard utils.Dashboard_Get) int {
+	return max(dashboard.Revision, 1)
+}
+
+// Returns ErrConflict if the stored configuration is not the expected revision
+func checkRevision(current utils.Dashboard_Get, ifRevision int) error {
+	if ifRevision != AnyRevision && RevisionNumber(current) != ifRevision {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Returns the stored revisions, or the current configuration as revision 1 if it was stored before revisions were kept
+func historyOf(current utils.Dashboard_Get, revisions []utils.Dashboard_Get) []utils.Dashboard_Get {
+	if len(revisions) == 0 && current.Revision == 0 {
+		current.Revision = 1
+		return []utils.Dashboard_Get{current}
+	}
+	return revisions
+}
+
+// Returns revision n of the history
+func revisionOf(history []utils.Dashboard_Get, n int) (utils.Dashboard_Get, error) {
+	for _, revision := range history {
+		if revision.Revision == n {
+			return revision, nil
+		}
+	}
+	return utils.Dashboard_Get{}, ErrNotFound
+}
+
+// Position of a webhook in listings, which are ordered by id
+func webhookPosition(hook utils.WebhookGetResponse) position {
+	return position{ID: hook.Id}
+}
+
+// Position of an API key in listings, which are ordered by id
+func apiKeyPosition(key utils.APIKey) position {
+	return position{ID: key.ID}
+}
+
+// Owner of the webhook
+func webhookOwner(hook utils.WebhookGetResponse) string {
+	return hook.Owner
+}
+
+// Reports whether the webhook is triggered by the event on the country.
+// Webhooks without a country are triggered for all countries
+func matchesEvent(hook utils.WebhookGetResponse, event string, isoCode string, owner string) bool {
+	return hook.Event == event && (hook.Country == isoCode || hook.Country == "") && ownedBy(hook.Owner, owner)
+}
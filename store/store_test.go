@@ -0,0 +1,541 @@
+package store
+
+import (
+	"assignment2/utils"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Checks the behaviour every DashboardStore must have, using an empty store
+func testDashboardStore(t *testing.T, dashboards DashboardStore) {
+	ctx := context.Background()
+	changed := time.Date(2024, 2, 29, 14, 7, 0, 123456789, time.FixedZone("CET", 3600))
+
+	// Create a configuration
+	id, err := dashboards.Create(ctx, utils.Dashboard_Get{
+		Country:    "Norway",
+		IsoCode:    "NO",
+		Features:   utils.Features_Get{Capital: true, TargetCurrencies: []string{"EUR"}},
+		LastChange: changed,
+		Owner:      "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(id) != DefaultIDLength {
+		t.Errorf("Expected id of length %v, got %v", DefaultIDLength, id)
+	}
+
+	// Get the configuration, and check that the stored slice can not be changed through it
+	got, err := dashboards.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != id || got.Country != "Norway" || !got.Features.Capital {
+		t.Errorf("Get() returned wrong configuration %v", got)
+	}
+
+	// lastChange is kept like Firestore keeps it, in UTC with microsecond precision
+	if want := changed.UTC().Truncate(time.Microsecond); !got.LastChange.Equal(want) || got.LastChange.Location() != time.UTC {
+		t.Errorf("Expected lastChange %v, got %v", want, got.LastChange)
+	}
+	got.Features.TargetCurrencies[0] = "USD"
+	if again, _ := dashboards.Get(ctx, id); again.Features.TargetCurrencies[0] != "EUR" {
+		t.Errorf("Stored configuration was changed through a returned copy")
+	}
+
+	// Update the configuration, which becomes revision 2 and keeps its owner
+	got.Country = "Sweden"
+	got.Owner = ""
+	stored, err := dashboards.Update(ctx, got, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Revision != 2 || stored.Country != "Sweden" || stored.Owner != "alice" {
+		t.Errorf("Update() returned %v, want revision 2 for Sweden owned by alice", stored)
+	}
+	if updated, _ := dashboards.Get(ctx, id); updated.Country != "Sweden" || updated.Revision != 2 {
+		t.Errorf("Update() did not change the configuration, got %v", updated)
+	}
+
+	// Writes expecting an earlier revision fail
+	if _, err := dashboards.Update(ctx, got, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict updating revision 1, got %v", err)
+	}
+	if err := dashboards.Delete(ctx, id, 1); !errors.Is(err, ErrConflict) {
+		t.Errorf("Expected ErrConflict deleting revision 1, got %v", err)
+	}
+
+	// Both revisions are kept
+	revisions, err := dashboards.Revisions(ctx, id)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("Expected 2 revisions, got %v, %v", revisions, err)
+	}
+	if revisions[0].Revision != 1 || revisions[0].Country != "Norway" || revisions[1].Revision != 2 || revisions[1].Country != "Sweden" {
+		t.Errorf("Revisions() returned wrong revisions %v", revisions)
+	}
+	first, err := dashboards.Revision(ctx, id, 1)
+	if err != nil || first.Country != "Norway" || first.ID != id || !first.Features.Capital {
+		t.Errorf("Revision(1) = %v, %v", first, err)
+	}
+	if _, err := dashboards.Revision(ctx, id, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for revision 3, got %v", err)
+	}
+	if _, err := dashboards.Revisions(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for revisions of unknown configuration, got %v", err)
+	}
+
+	// Updating or deleting an unknown configuration fails
+	if _, err := dashboards.Update(ctx, utils.Dashboard_Get{ID: "unknown"}, AnyRevision); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+	if err := dashboards.Delete(ctx, "unknown", AnyRevision); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// List and delete the configuration
+	all, err := dashboards.List(ctx, DashboardQuery{}, ListOptions{})
+	if err != nil || len(all.Items) != 1 || all.Total != 1 || all.NextCursor != "" {
+		t.Errorf("Expected one configuration, got %v, %v", all, err)
+	}
+	if err := dashboards.Delete(ctx, id, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dashboards.Get(ctx, id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+	if _, err := dashboards.Revision(ctx, id, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for revision after delete, got %v", err)
+	}
+
+	// The deleted configuration is in the trash, and can be restored with its revisions
+	if trashed, err := dashboards.Trash(ctx, "bob", ListOptions{}); err != nil || trashed.Total != 0 {
+		t.Errorf("Expected no configurations of another owner in the trash, got %v, %v", trashed, err)
+	}
+	if _, err := dashboards.Restore(ctx, id, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring the configuration of another owner, got %v", err)
+	}
+	trashed, err := dashboards.Trash(ctx, "alice", ListOptions{})
+	if err != nil || len(trashed.Items) != 1 || trashed.Total != 1 || trashed.Items[0].Item.ID != id || trashed.Items[0].DeletedAt.IsZero() {
+		t.Fatalf("Expected the configuration in the trash, got %v, %v", trashed, err)
+	}
+	restored, err := dashboards.Restore(ctx, id, "alice")
+	if err != nil || restored.ID != id || restored.Revision != 2 || restored.Country != "Sweden" {
+		t.Errorf("Restore() = %v, %v", restored, err)
+	}
+	if revisions, err := dashboards.Revisions(ctx, id); err != nil || len(revisions) != 2 {
+		t.Errorf("Expected 2 revisions after restore, got %v, %v", revisions, err)
+	}
+	if _, err := dashboards.Restore(ctx, id, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring a configuration not in the trash, got %v", err)
+	}
+
+	// Only configurations deleted before the given time are purged, for good
+	if err := dashboards.Delete(ctx, id, AnyRevision); err != nil {
+		t.Fatal(err)
+	}
+	if purged, err := dashboards.Purge(ctx, time.Now().Add(-time.Hour)); err != nil || len(purged) != 0 {
+		t.Errorf("Expected nothing purged, got %v, %v", purged, err)
+	}
+	purged, err := dashboards.Purge(ctx, time.Now().Add(time.Minute))
+	if err != nil || len(purged) != 1 || purged[0].ID != id {
+		t.Errorf("Expected the configuration purged, got %v, %v", purged, err)
+	}
+	if _, err := dashboards.Restore(ctx, id, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring a purged configuration, got %v", err)
+	}
+	if trashed, err := dashboards.Trash(ctx, "", ListOptions{}); err != nil || trashed.Total != 0 {
+		t.Errorf("Expected an empty trash, got %v, %v", trashed, err)
+	}
+}
+
+// Checks the filters and sort orders every DashboardStore must support, using an empty store
+func testDashboardQueries(t *testing.T, dashboards DashboardStore) {
+	ctx := context.Background()
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Configurations changed on different days
+	registered := []utils.Dashboard_Get{
+		{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Temperature: true, TargetCurrencies: []string{"EUR"}}, LastChange: day},
+		{Country: "Sweden", IsoCode: "SE", Features: utils.Features_Get{Temperature: true, Area: true}, LastChange: day.AddDate(0, 0, 2), Owner: "alice"},
+		{Country: "Denmark", IsoCode: "DK", Features: utils.Features_Get{TargetCurrencies: []string{"EUR", "USD"}}, LastChange: day.AddDate(0, 0, 1)},
+		{Country: "Norway", IsoCode: "NO", Features: utils.Features_Get{Area: true}, LastChange: day.AddDate(0, 0, 3), Owner: "alice"},
+	}
+	ids := make(map[string]int)
+	for i, dashboard := range registered {
+		id, err := dashboards.Create(ctx, dashboard)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = i
+	}
+
+	// Lists all pages of the query, and returns the indexes of the listed configurations in registered
+	list := func(query DashboardQuery) []int {
+		listed := make([]int, 0)
+		opts := ListOptions{Limit: 1}
+		for {
+			page, err := dashboards.List(ctx, query, opts)
+			if err != nil {
+				t.Fatalf("List(%+v) failed: %v", query, err)
+			}
+			for _, dashboard := range page.Items {
+				listed = append(listed, ids[dashboard.ID])
+			}
+			if page.NextCursor == "" {
+				if page.Total != len(listed) {
+					t.Errorf("List(%+v) has total %v, listed %v", query, page.Total, listed)
+				}
+				return listed
+			}
+			opts.Cursor = page.NextCursor
+		}
+	}
+
+	tests := []struct {
+		query DashboardQuery
+		want  []int
+	}{
+		{DashboardQuery{Country: "Norway", Sort: SortByLastChange}, []int{0, 3}},
+		{DashboardQuery{IsoCodes: []string{"NO", "DK"}, Sort: SortByLastChange, Descending: true}, []int{3, 2, 0}},
+		{DashboardQuery{Features: []string{"temperature"}, Sort: SortByLastChange}, []int{0, 1}},
+		{DashboardQuery{Features: []string{"temperature", "area"}}, []int{1}},
+		{DashboardQuery{Currency: "EUR", Sort: SortByCountry}, []int{2, 0}},
+		{DashboardQuery{ChangedSince: day.AddDate(0, 0, 2), Sort: SortByLastChange}, []int{1, 3}},
+		{DashboardQuery{ChangedSince: day.AddDate(0, 0, 1), Sort: SortByLastChange, Descending: true}, []int{3, 1, 2}},
+		{DashboardQuery{Sort: SortByLastChange}, []int{0, 2, 1, 3}},
+		{DashboardQuery{Owner: "alice", Sort: SortByLastChange, Descending: true}, []int{3, 1}},
+	}
+	for _, test := range tests {
+		got := list(test.query)
+		if len(got) != len(test.want) {
+			t.Errorf("List(%+v) = %v, want %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("List(%+v) = %v, want %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+
+	// Countries sort before the id, so only the two Norway configurations may be in either order
+	if got := list(DashboardQuery{Sort: SortByCountry}); len(got) != 4 || got[0] != 2 || got[3] != 1 {
+		t.Errorf("List sorted by country = %v", got)
+	}
+	if got := list(DashboardQuery{}); len(got) != 4 {
+		t.Errorf("List without query = %v", got)
+	}
+
+	// Cursors that were not returned by a listing are rejected
+	if _, err := dashboards.List(ctx, DashboardQuery{}, ListOptions{Cursor: "not a cursor"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor, got %v", err)
+	}
+}
+
+// Checks the behaviour every WebhookStore must have, using an empty store
+func testWebhookStore(t *testing.T, webhooks WebhookStore) {
+	ctx := context.Background()
+
+	// Register webhooks for Norway, for all countries, for another event, and of another owner
+	hooks := []utils.WebhookGetResponse{
+		{Url: "http://a.com", Country: "NO", Event: "INVOKE", Owner: "alice"},
+		{Url: "http://b.com", Country: "", Event: "INVOKE", Owner: "alice"},
+		{Url: "http://c.com", Country: "SE", Event: "INVOKE", Owner: "alice"},
+		{Url: "http://d.com", Country: "NO", Event: "DELETE", Owner: "alice"},
+		{Url: "http://e.com", Country: "NO", Event: "INVOKE", Owner: "bob"},
+	}
+	for _, hook := range hooks {
+		if _, err := webhooks.Create(ctx, hook); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Count the webhooks
+	if count, err := webhooks.Count(ctx); err != nil || count != len(hooks) {
+		t.Errorf("Count() = %v, %v, want %v", count, err, len(hooks))
+	}
+
+	// Only the webhooks of the owner for the event on Norway or all countries match
+	if all, err := webhooks.Matching(ctx, "INVOKE", "NO", ""); err != nil || len(all) != 3 {
+		t.Errorf("Expected 3 matching webhooks of any owner, got %v, %v", all, err)
+	}
+	if owned, err := webhooks.List(ctx, "bob", ListOptions{}); err != nil || len(owned.Items) != 1 || owned.Total != 1 ||
+		owned.Items[0].Url != "http://e.com" {
+		t.Errorf("Expected the webhook of bob, got %v, %v", owned, err)
+	}
+	matching, err := webhooks.Matching(ctx, "INVOKE", "NO", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matching) != 2 {
+		t.Fatalf("Expected 2 matching webhooks, got %v", matching)
+	}
+	for _, hook := range matching {
+		if hook.Url != "http://a.com" && hook.Url != "http://b.com" {
+			t.Errorf("Unexpected matching webhook %v", hook)
+		}
+	}
+
+	// Get and delete a webhook
+	got, err := webhooks.Get(ctx, matching[0].Id)
+	if err != nil || got != matching[0] {
+		t.Errorf("Get() = %v, %v, want %v", got, err, matching[0])
+	}
+	if err := webhooks.Delete(ctx, got.Id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := webhooks.Get(ctx, got.Id); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after delete, got %v", err)
+	}
+
+	// Page through the three remaining webhooks of the owner, two at a time
+	first, err := webhooks.List(ctx, "alice", ListOptions{Limit: 2})
+	if err != nil || len(first.Items) != 2 || first.Total != 3 || first.NextCursor == "" {
+		t.Fatalf("Expected first page of 2 out of 3 webhooks, got %v, %v", first, err)
+	}
+	second, err := webhooks.List(ctx, "alice", ListOptions{Limit: 2, Cursor: first.NextCursor})
+	if err != nil || len(second.Items) != 1 || second.Total != 3 || second.NextCursor != "" {
+		t.Fatalf("Expected last page with 1 webhook, got %v, %v", second, err)
+	}
+	seen := map[string]bool{}
+	for _, hook := range append(first.Items, second.Items...) {
+		if seen[hook.Id] {
+			t.Errorf("Webhook %v listed twice", hook)
+		}
+		seen[hook.Id] = true
+	}
+
+	// The deleted webhook is in the trash, and its ID is not given to a new webhook
+	trashed, err := webhooks.Trash(ctx, "alice", ListOptions{})
+	if err != nil || len(trashed.Items) != 1 || trashed.Items[0].Item != got || trashed.Items[0].DeletedAt.IsZero() {
+		t.Fatalf("Expected the deleted webhook in the trash, got %v, %v", trashed, err)
+	}
+	webhooks.SetIDGenerator(&fixedIDs{got.Id, "fresh"})
+	if id, err := webhooks.Create(ctx, hooks[0]); err != nil || id != "fresh" {
+		t.Errorf("Expected new webhook with id fresh, got %v, %v", id, err)
+	}
+
+	// Restore the webhook, delete it again and purge it
+	if _, err := webhooks.Restore(ctx, got.Id, "bob"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring the webhook of another owner, got %v", err)
+	}
+	if restored, err := webhooks.Restore(ctx, got.Id, "alice"); err != nil || restored != got {
+		t.Errorf("Restore() = %v, %v, want %v", restored, err, got)
+	}
+	if _, err := webhooks.Get(ctx, got.Id); err != nil {
+		t.Errorf("Expected restored webhook, got %v", err)
+	}
+	if err := webhooks.Delete(ctx, got.Id); err != nil {
+		t.Fatal(err)
+	}
+	purged, err := webhooks.Purge(ctx, time.Now().Add(time.Minute))
+	if err != nil || len(purged) != 1 || purged[0] != got {
+		t.Errorf("Expected the webhook purged, got %v, %v", purged, err)
+	}
+	if _, err := webhooks.Restore(ctx, got.Id, ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound restoring a purged webhook, got %v", err)
+	}
+}
+
+// Checks the behaviour every APIKeyStore must have, using an empty store
+func testAPIKeyStore(t *testing.T, keys APIKeyStore) {
+	ctx := context.Background()
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	id, err := keys.Create(ctx, utils.APIKey{Owner: "alice", Roles: []string{"editor"}, Hash: "abc", CreatedAt: created})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.Create(ctx, utils.APIKey{Owner: "root", Roles: []string{"admin"}, Hash: "def", CreatedAt: created}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := keys.Get(ctx, id)
+	if err != nil || got.ID != id || got.Owner != "alice" || got.Hash != "abc" || len(got.Roles) != 1 || got.Roles[0] != "editor" || got.Revoked || !got.CreatedAt.Equal(created) {
+		t.Errorf("Get() = %v, %v", got, err)
+	}
+
+	// Revoked keys are kept, and listed
+	if err := keys.Revoke(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := keys.Get(ctx, id); err != nil || !got.Revoked {
+		t.Errorf("Expected revoked key, got %v, %v", got, err)
+	}
+	if err := keys.Revoke(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound revoking unknown key, got %v", err)
+	}
+	if _, err := keys.Get(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown key, got %v", err)
+	}
+
+	first, err := keys.List(ctx, ListOptions{Limit: 1})
+	if err != nil || len(first.Items) != 1 || first.Total != 2 || first.NextCursor == "" {
+		t.Fatalf("Expected first page of 1 out of 2 keys, got %v, %v", first, err)
+	}
+	second, err := keys.List(ctx, ListOptions{Limit: 1, Cursor: first.NextCursor})
+	if err != nil || len(second.Items) != 1 || second.NextCursor != "" || second.Items[0].ID == first.Items[0].ID {
+		t.Errorf("Expected last page with the other key, got %v, %v", second, err)
+	}
+}
+
+// Checks the behaviour every AuditStore must have, using an empty store
+func testAuditStore(t *testing.T, audit AuditStore) {
+	ctx := context.Background()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.FixedZone("CET", 3600))
+
+	records := []utils.AuditRecord{
+		{Actor: "alice", Action: AuditCreate, ResourceType: AuditRegistration, ResourceID: "abcde", After: []byte(`{"country":"Norway"}`)},
+		{Actor: "alice", Action: AuditUpdate, ResourceType: AuditRegistration, ResourceID: "abcde",
+			Before: []byte(`{"country":"Norway"}`), After: []byte(`{"country":"Sweden"}`)},
+		{Actor: "bob", Action: AuditCreate, ResourceType: AuditWebhook, ResourceID: "fghij", After: []byte(`{"event":"INVOKE"}`)},
+		{Actor: "alice", Action: AuditDelete, ResourceType: AuditRegistration, ResourceID: "abcde", Before: []byte(`{"country":"Sweden"}`)},
+	}
+	ids := make([]string, len(records))
+	for i, record := range records {
+		record.Time = start.Add(time.Duration(i) * time.Minute)
+		id, err := audit.Record(ctx, record)
+		if err != nil || id == "" {
+			t.Fatalf("Record() = %q, %v", id, err)
+		}
+		ids[i] = id
+	}
+
+	// Records are listed newest first, with their snapshots and the time in UTC
+	all, err := audit.List(ctx, AuditQuery{}, ListOptions{})
+	if err != nil || len(all.Items) != 4 || all.Total != 4 {
+		t.Fatalf("Expected 4 records, got %v, %v", all, err)
+	}
+	latest := all.Items[0]
+	if latest.ID != ids[3] || latest.Actor != "alice" || latest.Action != AuditDelete || string(latest.Before) != `{"country":"Sweden"}` ||
+		latest.After != nil || !latest.Time.Equal(start.Add(3*time.Minute).Truncate(time.Microsecond)) || latest.Time.Location() != time.UTC {
+		t.Errorf("Unexpected latest record %+v", latest)
+	}
+
+	tests := []struct {
+		query AuditQuery
+		want  []int
+	}{
+		{AuditQuery{ResourceType: AuditWebhook}, []int{2}},
+		{AuditQuery{ResourceID: "abcde"}, []int{3, 1, 0}},
+		{AuditQuery{Actor: "alice", ResourceType: AuditRegistration}, []int{3, 1, 0}},
+		{AuditQuery{Since: start.Truncate(time.Second).Add(time.Minute), Until: start.Truncate(time.Second).Add(3 * time.Minute)}, []int{2, 1}},
+		{AuditQuery{Actor: "carol"}, []int{}},
+	}
+	for _, test := range tests {
+		page, err := audit.List(ctx, test.query, ListOptions{})
+		if err != nil {
+			t.Errorf("List(%+v) returned %v", test.query, err)
+			continue
+		}
+		got := make([]string, 0, len(page.Items))
+		for _, record := range page.Items {
+			got = append(got, record.ID)
+		}
+		want := make([]string, 0, len(test.want))
+		for _, i := range test.want {
+			want = append(want, ids[i])
+		}
+		if strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("List(%+v) = %v, want %v", test.query, got, want)
+		}
+	}
+
+	// Pages follow each other without gaps
+	first, err := audit.List(ctx, AuditQuery{ResourceID: "abcde"}, ListOptions{Limit: 2})
+	if err != nil || len(first.Items) != 2 || first.Total != 3 || first.NextCursor == "" {
+		t.Fatalf("Expected first page of 2 out of 3 records, got %v, %v", first, err)
+	}
+	second, err := audit.List(ctx, AuditQuery{ResourceID: "abcde"}, ListOptions{Limit: 2, Cursor: first.NextCursor})
+	if err != nil || len(second.Items) != 1 || second.NextCursor != "" || second.Items[0].ID != ids[0] {
+		t.Errorf("Expected last page with the first record, got %v, %v", second, err)
+	}
+}
+
+// Checks the behaviour every shared cache must have, using an empty cache
+func testSharedCache(t *testing.T, cache utils.SharedCache) {
+	ctx := context.Background()
+	url := "https://api.example.com/v1/search?name=Oslo&count=1"
+	expires := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, ok, err := cache.Get(ctx, url); ok || err != nil {
+		t.Errorf("Expected no response in an empty cache, got %v, %v", ok, err)
+	}
+
+	for _, body := range []string{`{"results":[]}`, `{"results":[{"latitude":59.91}]}`} {
+		if err := cache.Set(ctx, url, utils.CachedResponse{Status: http.StatusOK, Body: []byte(body), Expires: expires}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The latest response replaces the earlier one
+	got, ok, err := cache.Get(ctx, url)
+	if err != nil || !ok || got.Status != http.StatusOK || string(got.Body) != `{"results":[{"latitude":59.91}]}` ||
+		!got.Expires.Equal(expires) {
+		t.Errorf("Get() = %d, %s, %v, %v, %v", got.Status, got.Body, got.Expires, ok, err)
+	}
+	if _, ok, err := cache.Get(ctx, url+"0"); ok || err != nil {
+		t.Errorf("Expected no response for another URL, got %v, %v", ok, err)
+	}
+}
+
+// Checks the behaviour every SnapshotStore must have, using an empty store
+func testSnapshotStore(t *testing.T, snapshots SnapshotStore) {
+	ctx := context.Background()
+	fetchedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, err := snapshots.Get(ctx, "abcde"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a dashboard without a snapshot, got %v", err)
+	}
+
+	first := utils.DashboardSnapshot{Revision: 2, Features: map[string]utils.SnapshotFeature{
+		"capital":     {Value: []byte(`"Oslo"`), FetchedAt: fetchedAt},
+		"coordinates": {Value: []byte(`{"latitude":59.91,"longitude":10.75}`), FetchedAt: fetchedAt},
+	}}
+	second := utils.DashboardSnapshot{Features: map[string]utils.SnapshotFeature{
+		"temperature": {Value: []byte(`11.5`), FetchedAt: fetchedAt.Add(time.Hour)},
+	}}
+	if err := snapshots.Put(ctx, "abcde", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshots.Put(ctx, "fghij", second); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := snapshots.Get(ctx, "abcde")
+	if err != nil || got.Revision != 2 || len(got.Features) != 2 {
+		t.Fatalf("Get() = %+v, %v", got, err)
+	}
+	coordinates := got.Features["coordinates"]
+	if string(coordinates.Value) != `{"latitude":59.91,"longitude":10.75}` || !coordinates.FetchedAt.Equal(fetchedAt) {
+		t.Errorf("Unexpected feature %s at %v", coordinates.Value, coordinates.FetchedAt)
+	}
+
+	// The latest snapshot replaces the earlier one
+	if err := snapshots.Put(ctx, "abcde", second); err != nil {
+		t.Fatal(err)
+	}
+	got, err = snapshots.Get(ctx, "abcde")
+	if err != nil || len(got.Features) != 1 || string(got.Features["temperature"].Value) != `11.5` {
+		t.Errorf("Get() after Put() = %+v, %v", got, err)
+	}
+
+	// Deleted snapshots are not found, and deleting a missing snapshot is not an error
+	for i := 0; i < 2; i++ {
+		if err := snapshots.Delete(ctx, "abcde"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := snapshots.Get(ctx, "abcde"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound after Delete(), got %v", err)
+	}
+	if _, err := snapshots.Get(ctx, "fghij"); err != nil {
+		t.Errorf("Delete() removed the snapshot of another dashboard: %v", err)
+	}
+}
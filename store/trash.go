@@ -0,0 +1,52 @@
+package store
+
+import (
+	"assignment2/utils"
+	"sort"
+	"time"
+)
+
+// name of collection deleted dashboards are kept in until they are purged
+const DashboardTrashCollection = "DashboardTrash"
+
+// name of collection deleted webhooks are kept in until they are purged
+const WebhookTrashCollection = "webhooksTrash"
+
+// DefaultRetention is how long deleted items are kept in the trash when no retention is configured
+const DefaultRetention = 30 * 24 * time.Hour
+
+// Trashed is a deleted item, which can be restored until it is purged
+type Trashed[T any] struct {
+	Item      T         `json:"item"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// Puts the item in the trash, deleted now. The time is kept the way Firestore does
+func trash[T any](item T) Trashed[T] {
+	return Trashed[T]{Item: item, DeletedAt: time.Now().UTC().Truncate(time.Microsecond)}
+}
+
+// Position of a deleted configuration in the trash, which is sorted by id
+func trashedDashboardPosition(trashed Trashed[utils.Dashboard_Get]) position {
+	return position{ID: trashed.Item.ID}
+}
+
+// Position of a deleted webhook in the trash, which is sorted by id
+func trashedWebhookPosition(trashed Trashed[utils.WebhookGetResponse]) position {
+	return webhookPosition(trashed.Item)
+}
+
+// Returns the items in the trash, sorted by id
+func sortedTrash[T any](items map[string]Trashed[T]) []Trashed[T] {
+	ids := make([]string, 0, len(items))
+	for id := range items {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	sorted := make([]Trashed[T], 0, len(ids))
+	for _, id := range ids {
+		sorted = append(sorted, items[id])
+	}
+	return sorted
+}
@@ -0,0 +1,328 @@
+/*
+Package stub answers requests for the third-party APIs the service depends on with canned data,
+so tests do not depend on external services being available.
+*/
+package stub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A country as returned by the REST Countries API
+type country struct {
+	Name struct {
+		Common string `json:"common"`
+	} `json:"name"`
+	Isocode    string                       `json:"cca2"`
+	Capital    []string                     `json:"capital"`
+	Population int                          `json:"population"`
+	Area       float64                      `json:"area"`
+	Currencies map[string]map[string]string `json:"currencies"`
+	names      []string
+}
+
+// Creates a country, names are the alternative spellings the name endpoint accepts
+func newCountry(name string, isocode string, capital string, population int, area float64, currency string, names ...string) country {
+	c := country{
+		Isocode:    isocode,
+		Capital:    []string{capital},
+		Population: population,
+		Area:       area,
+		Currencies: map[string]map[string]string{currency: {}},
+		names:      append(names, name),
+	}
+	c.Name.Common = name
+	return c
+}
+
+// Countries known by the stub
+var countries = []country{
+	newCountry("Norway", "NO", "Oslo", 5379475, 323802, "NOK", "Norge", "Noreg"),
+	newCountry("Sweden", "SE", "Stockholm", 10353442, 450295, "SEK", "Sverige"),
+	newCountry("United States", "US", "Washington, D.C.", 329484123, 9372610, "USD", "USA", "United States of America"),
+}
+
+// Coordinates of the capitals known by the geocoding stub
+var capitals = map[string][2]float64{
+	"Oslo":             {59.91, 10.75},
+	"Stockholm":        {59.33, 18.07},
+	"Washington, D.C.": {38.89, -77.03},
+}
+
+// Value of one US dollar in the currencies known by the stub
+var dollarRates = map[string]float64{
+	"USD": 1,
+	"EUR": 0.92,
+	"GBP": 0.79,
+	"JPY": 150,
+	"NOK": 10.5,
+	"SEK": 10.4,
+}
+
+// Hourly values returned by the forecast stub, the same for every day.
+// Temperature is 0 to 23 degrees (mean 11.5), precipitation is 0.5 mm in the first six hours (mean 0.125),
+// the wind blows at 5 m/s from 350 and 10 degrees in turn (mean 0 on the circle), humidity is 80%, cloud cover
+// is 50%, the UV index is 4 from 10 to 14 o'clock, and it feels 2 degrees colder than the temperature
+func hourlyValue(variable string, hour int) float64 {
+	switch variable {
+	case "temperature_2m":
+		return float64(hour % 24)
+	case "precipitation":
+		if hour%24 < 6 {
+			return 0.5
+		}
+		return 0
+	case "wind_speed_10m":
+		return 5
+	case "wind_direction_10m":
+		if hour%2 == 0 {
+			return 350
+		}
+		return 10
+	case "relative_humidity_2m":
+		return 80
+	case "cloud_cover":
+		return 50
+	case "uv_index":
+		if hour%24 >= 10 && hour%24 < 14 {
+			return 4
+		}
+		return 0
+	case "apparent_temperature":
+		return float64(hour%24) - 2
+	default:
+		return 0
+	}
+}
+
+// Daily values returned by the forecast stub: the minimum and maximum of the hourly temperature, the sum of the
+// hourly precipitation, and the weather codes clear sky, overcast, slight rain and slight snowfall in turn
+func dailyValue(variable string, day int) float64 {
+	switch variable {
+	case "temperature_2m_min":
+		return 0
+	case "temperature_2m_max":
+		return 23
+	case "precipitation_sum":
+		return 3
+	case "weather_code":
+		return []float64{0, 3, 61, 71}[day%4]
+	default:
+		return 0
+	}
+}
+
+// Current air quality returned by the air quality stub, the same everywhere
+var airQuality = map[string]float64{
+	"european_aqi": 21,
+	"us_aqi":       30,
+	"pm2_5":        4.6,
+	"pm10":         7.2,
+}
+
+// Handler serves the REST Countries, currency, geocoding, forecast and air quality APIs
+func Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v3.1/name/", countriesByName)
+	mux.HandleFunc("/v3.1/alpha/", countriesByCode)
+	mux.HandleFunc("/currency/", currencyRates)
+	mux.HandleFunc("/v1/search", geocoding)
+	mux.HandleFunc("/v1/forecast", forecast)
+	mux.HandleFunc("/v1/air-quality", currentAirQuality)
+	return mux
+}
+
+// Writes the value as JSON with the given status code
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// Writes the countries, or a not found message if there are none
+func writeCountries(w http.ResponseWriter, found []country) {
+	if len(found) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]interface{}{"status": 404, "message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, found)
+}
+
+// Finds countries where the name in the path is part of the common name, or one of the spellings
+func countriesByName(w http.ResponseWriter, r *http.Request) {
+	name := strings.ToLower(strings.TrimPrefix(r.URL.Path, "/v3.1/name/"))
+
+	found := make([]country, 0)
+	for _, c := range countries {
+		match := name != "" && strings.Contains(strings.ToLower(c.Name.Common), name)
+		for _, spelling := range c.names {
+			match = match || strings.EqualFold(spelling, name)
+		}
+		if match {
+			found = append(found, c)
+		}
+	}
+	writeCountries(w, found)
+}
+
+// Finds the country with the two-letter code in the path
+func countriesByCode(w http.ResponseWriter, r *http.Request) {
+	code := strings.TrimPrefix(r.URL.Path, "/v3.1/alpha/")
+
+	found := make([]country, 0)
+	for _, c := range countries {
+		if strings.EqualFold(c.Isocode, code) {
+			found = append(found, c)
+		}
+	}
+	writeCountries(w, found)
+}
+
+// Returns the exchange rates of the currency in the path
+func currencyRates(w http.ResponseWriter, r *http.Request) {
+	base := strings.TrimPrefix(r.URL.Path, "/currency/")
+
+	baseRate, ok := dollarRates[base]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"result": "error", "error-type": "unsupported-code"})
+		return
+	}
+
+	rates := make(map[string]float64)
+	for currency, rate := range dollarRates {
+		rates[currency] = rate / baseRate
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"result": "success", "base_code": base, "rates": rates})
+}
+
+// Returns the coordinates of a known capital, or no results
+func geocoding(w http.ResponseWriter, r *http.Request) {
+	response := map[string]interface{}{}
+
+	if coordinates, ok := capitals[r.URL.Query().Get("name")]; ok {
+		response["results"] = []map[string]float64{{"latitude": coordinates[0], "longitude": coordinates[1]}}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Returns the requested hourly and daily variables for the requested number of days, from 1 March 2024
+func forecast(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	days, err := strconv.Atoi(query.Get("forecast_days"))
+	if err != nil || days < 1 {
+		days = 7
+	}
+
+	// Every location is an hour ahead of GMT, so the hours start at 1 unless they are in the local timezone
+	offset := 1
+	if query.Get("timezone") == "auto" {
+		offset = 0
+	}
+
+	hourly := make(map[string][]float64)
+	for _, variable := range strings.Split(query.Get("hourly"), ",") {
+		if variable == "" {
+			continue
+		}
+		values := make([]float64, 24*days)
+		for hour := range values {
+			values[hour] = hourlyValue(variable, hour+offset)
+		}
+		hourly[variable] = values
+	}
+
+	response := map[string]interface{}{
+		"latitude":  query.Get("latitude"),
+		"longitude": query.Get("longitude"),
+		"hourly":    hourly,
+	}
+
+	if query.Get("daily") != "" {
+		dates := make([]string, days)
+		for day := range dates {
+			dates[day] = time.Date(2024, 3, 1+day, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+		}
+		daily := map[string]interface{}{"time": dates}
+		for _, variable := range strings.Split(query.Get("daily"), ",") {
+			values := make([]float64, days)
+			for day := range values {
+				values[day] = dailyValue(variable, day)
+			}
+			daily[variable] = values
+		}
+		response["daily"] = daily
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Returns the requested variables of the current air quality
+func currentAirQuality(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	current := map[string]interface{}{"time": "2024-03-01T12:00", "interval": 3600}
+	for _, variable := range strings.Split(query.Get("current"), ",") {
+		if value, ok := airQuality[variable]; ok {
+			current[variable] = value
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"latitude":  query.Get("latitude"),
+		"longitude": query.Get("longitude"),
+		"current":   current,
+	})
+}
+
+// Serves requests for the upstream hosts from Handler, passes requests for loopback
+// addresses (such as httptest servers) on, and fails all other requests
+type transport struct {
+	upstreams map[string]bool
+	next      http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.upstreams[req.URL.Host] {
+		recorder := httptest.NewRecorder()
+		Handler().ServeHTTP(recorder, req)
+
+		res := recorder.Result()
+		res.Request = req
+		return res, nil
+	}
+
+	host := req.URL.Hostname()
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return t.next.RoundTrip(req)
+	}
+	return nil, fmt.Errorf("stub: no network access to %s", req.URL.Host)
+}
+
+// Install routes requests for the hosts of the given upstream base URLs to Handler, for every client
+// using http.DefaultTransport. Requests for other hosts, except loopback addresses, fail.
+// The returned function restores the previous transport
+func Install(upstreams ...string) (restore func()) {
+	hosts := make(map[string]bool)
+	for _, upstream := range upstreams {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			panic("stub: invalid upstream url " + upstream)
+		}
+		hosts[u.Host] = true
+	}
+
+	previous := http.DefaultTransport
+	http.DefaultTransport = &transport{upstreams: hosts, next: previous}
+	return func() {
+		http.DefaultTransport = previous
+	}
+}
@@ -0,0 +1,103 @@
+package upstream
+
+import "time"
+
+// States of a circuit breaker
+const (
+	// Requests are sent
+	BreakerClosed = "closed"
+	// Requests fail without being sent, until the breaker has been open long enough
+	BreakerOpen = "open"
+	// One trial request is sent, which closes the breaker if it succeeds and opens it again if it fails
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStatus is the state of the circuit breaker of a host
+type BreakerStatus struct {
+	State string `json:"state"`
+	// Failures in a row since the last success
+	Failures int `json:"failures"`
+	// When an open breaker lets a trial request through
+	RetryAt *time.Time `json:"retryAt,omitempty"`
+}
+
+// Circuit breaker of a host, guarded by the mutex of the client
+type breaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	// Whether the trial request of a half-open breaker is in flight
+	trial bool
+}
+
+// Returns the breaker of the host, which is created closed. Must be called with c.mu held
+func (c *Client) breakerOf(host string) *breaker {
+	b, ok := c.breakers[host]
+	if !ok {
+		b = &breaker{state: BreakerClosed}
+		c.breakers[host] = b
+	}
+	return b
+}
+
+// Returns ErrCircuitOpen if a request to the host must not be sent. An open breaker that has been open for OpenFor
+// turns half-open, and lets one trial request through
+func (c *Client) allow(host string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.breakerOf(host)
+	if b.state == BreakerOpen && !c.now().Before(b.openedAt.Add(c.OpenFor)) {
+		b.state = BreakerHalfOpen
+	}
+	switch {
+	case b.state == BreakerOpen, b.state == BreakerHalfOpen && b.trial:
+		return ErrCircuitOpen
+	case b.state == BreakerHalfOpen:
+		b.trial = true
+	}
+	return nil
+}
+
+// Records whether the request to the host succeeded. FailureThreshold failures in a row, or a failed trial
+// request, open the breaker
+func (c *Client) record(host string, succeeded bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b := c.breakerOf(host)
+	b.trial = false
+	if succeeded {
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= c.FailureThreshold {
+		b.state, b.openedAt = BreakerOpen, c.now()
+	}
+}
+
+// Forgets a request to the host that was given up by the caller, which says nothing about the host
+func (c *Client) abandon(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.breakerOf(host).trial = false
+}
+
+// Breakers returns the state of the circuit breakers of the hosts requested so far, keyed by host
+func (c *Client) Breakers() map[string]BreakerStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	statuses := make(map[string]BreakerStatus, len(c.breakers))
+	for host, b := range c.breakers {
+		status := BreakerStatus{State: b.state, Failures: b.failures}
+		if b.state == BreakerOpen {
+			retryAt := b.openedAt.Add(c.OpenFor)
+			status.RetryAt = &retryAt
+		}
+		statuses[host] = status
+	}
+	return statuses
+}
@@ -0,0 +1,234 @@
+/*
+Package upstream fetches data from the third-party APIs the service depends on. Requests are given a timeout,
+retried with jittered backoff when the API fails or limits them, and not sent at all to a host whose circuit breaker
+is open. Identical requests in flight share one fetch, and responses can be kept in a Cache
+*/
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Defaults of the clients made by NewClient
+const (
+	DefaultTimeout          = 10 * time.Second
+	DefaultRetries          = 2
+	DefaultBackoff          = 100 * time.Millisecond
+	DefaultFailureThreshold = 5
+	DefaultOpenFor          = 30 * time.Second
+)
+
+// Longest time a fetch shared by identical requests is given. It is not cancelled with the request that started it,
+// since the others still wait for it
+const coalescedFetchTimeout = 30 * time.Second
+
+// Response is the status code and body of a response of an upstream API
+type Response struct {
+	StatusCode int
+	Body       []byte
+}
+
+// Cache keeps the responses of upstream APIs
+type Cache interface {
+	// Fetch returns the response to the URL from the cache, or calls fetch and caches what it returns
+	Fetch(ctx context.Context, url string, fetch func(ctx context.Context, url string) (Response, error)) (Response, error)
+}
+
+// Client sends requests to upstream APIs. The fields must be set before the first request
+type Client struct {
+	// Client the requests are sent with, which gives up on a request after its timeout
+	HTTP *http.Client
+	// Cache answers requests from responses fetched before, nil to always fetch
+	Cache Cache
+	// Times a request is retried after a network error, or a 5xx or 429 response
+	Retries int
+	// Longest wait before the first retry, which is doubled for every retry after it. The wait is jittered
+	Backoff time.Duration
+	// Failures in a row that open the circuit breaker of a host
+	FailureThreshold int
+	// Time the circuit breaker of a host stays open before a trial request is sent
+	OpenFor time.Duration
+
+	now   func() time.Time
+	calls singleflight.Group
+	// Requests answered by the fetch of an identical request in flight
+	coalesced atomic.Int64
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewClient returns a client with the default timeout, retries and circuit breakers, which caches nothing
+func NewClient() *Client {
+	return &Client{
+		HTTP:             &http.Client{Timeout: DefaultTimeout},
+		Retries:          DefaultRetries,
+		Backoff:          DefaultBackoff,
+		FailureThreshold: DefaultFailureThreshold,
+		OpenFor:          DefaultOpenFor,
+		now:              time.Now,
+		breakers:         make(map[string]*breaker),
+	}
+}
+
+// DefaultClient is the client used by Get and GetJSON
+var DefaultClient = NewClient()
+
+// Get returns the body of the successful response to the URL, using DefaultClient
+func Get(ctx context.Context, url string) ([]byte, error) {
+	return DefaultClient.Get(ctx, url)
+}
+
+// GetJSON decodes the body of the successful response to the URL into data, using DefaultClient
+func GetJSON(ctx context.Context, url string, data interface{}) error {
+	return DefaultClient.GetJSON(ctx, url, data)
+}
+
+// Get returns the body of the successful response to the URL. A response that is not successful is an *Error
+// of the kind ErrNotFound or ErrUnavailable
+func (c *Client) Get(ctx context.Context, url string) ([]byte, error) {
+	response, err := c.coalesce(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone:
+		return nil, &Error{URL: url, StatusCode: response.StatusCode, Kind: ErrNotFound}
+	case response.StatusCode < 200 || response.StatusCode > 299:
+		return nil, &Error{URL: url, StatusCode: response.StatusCode, Kind: ErrUnavailable}
+	}
+	return response.Body, nil
+}
+
+// GetJSON decodes the body of the successful response to the URL into data. A body that can not be decoded is an
+// *Error of the kind ErrBadPayload
+func (c *Client) GetJSON(ctx context.Context, url string, data interface{}) error {
+	body, err := c.Get(ctx, url)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, data); err != nil {
+		return &Error{URL: url, StatusCode: http.StatusOK, Kind: ErrBadPayload, Err: err}
+	}
+	return nil
+}
+
+// Coalesced returns how many requests were answered by the fetch of an identical request in flight
+func (c *Client) Coalesced() int64 {
+	return c.coalesced.Load()
+}
+
+/*
+Returns the response to the URL, from the cache if it has it. Identical requests in flight share one fetch, which
+carries on when the request that started it is given up, so the others still get the response
+*/
+func (c *Client) coalesce(ctx context.Context, url string) (Response, error) {
+	// Only set by the request whose fetch is shared, which is read once the result is received
+	started := false
+	results := c.calls.DoChan(url, func() (interface{}, error) {
+		started = true
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), coalescedFetchTimeout)
+		defer cancel()
+
+		if c.Cache != nil {
+			return c.Cache.Fetch(ctx, url, c.fetch)
+		}
+		return c.fetch(ctx, url)
+	})
+
+	select {
+	case result := <-results:
+		if !started {
+			c.coalesced.Add(1)
+		}
+		if result.Err != nil {
+			return Response{}, result.Err
+		}
+		return result.Val.(Response), nil
+	case <-ctx.Done():
+		return Response{}, &Error{URL: url, Kind: ErrUnavailable, Err: ctx.Err()}
+	}
+}
+
+/*
+Fetches the response to the URL, retrying network errors and responses that are 5xx or 429 after a jittered backoff.
+The responses are recorded by the circuit breaker of the host, and no request is sent while it is open. Other
+responses are returned as they are, whatever their status code
+*/
+func (c *Client) fetch(ctx context.Context, rawURL string) (Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return Response{}, &Error{URL: rawURL, Kind: ErrUnavailable, Err: err}
+	}
+	host := request.URL.Host
+
+	for attempt := 0; ; attempt++ {
+		if err := c.allow(host); err != nil {
+			return Response{}, &Error{URL: rawURL, Kind: ErrUnavailable, Err: err}
+		}
+
+		response, err := c.send(request)
+		if errors.Is(err, context.Canceled) {
+			c.abandon(host)
+			return Response{}, &Error{URL: rawURL, Kind: ErrUnavailable, Err: err}
+		}
+		failed := err != nil || response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests
+		c.record(host, !failed)
+		if !failed {
+			return response, nil
+		}
+
+		if attempt >= c.Retries || ctx.Err() != nil {
+			return Response{}, &Error{URL: rawURL, StatusCode: response.StatusCode, Kind: ErrUnavailable, Err: err}
+		}
+		if err := c.wait(ctx, attempt); err != nil {
+			return Response{}, &Error{URL: rawURL, StatusCode: response.StatusCode, Kind: ErrUnavailable, Err: err}
+		}
+	}
+}
+
+// Sends the request, and reads the response
+func (c *Client) send(request *http.Request) (Response, error) {
+	response, err := c.HTTP.Do(request)
+	if err != nil {
+		// The URL is already part of the errors of the client
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return Response{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	return Response{StatusCode: response.StatusCode, Body: body}, err
+}
+
+// Waits a random time up to the backoff of the attempt, or until ctx is done
+func (c *Client) wait(ctx context.Context, attempt int) error {
+	backoff := c.Backoff << attempt
+	if backoff <= 0 {
+		return ctx.Err()
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff)) + 1))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
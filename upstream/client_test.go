@@ -0,0 +1,202 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Returns a client that retries without waiting long
+func testClient() *Client {
+	client := NewClient()
+	client.Backoff = time.Millisecond
+	return client
+}
+
+// Test of the kinds of errors of requests that fail
+func TestGetJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			w.Write([]byte(`{"key":"value"}`))
+		case "/invalid":
+			w.Write([]byte(`{invalid json}`))
+		case "/error":
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		default:
+			http.Error(w, `{"result":"error"}`, http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := testClient()
+
+	var data map[string]string
+	if err := client.GetJSON(context.Background(), server.URL+"/ok", &data); err != nil || data["key"] != "value" {
+		t.Fatalf("GetJSON() = %v, %v", data, err)
+	}
+
+	tests := []struct {
+		url  string
+		kind error
+	}{
+		{server.URL + "/invalid", ErrBadPayload},
+		{server.URL + "/error", ErrUnavailable},
+		{server.URL + "/missing", ErrNotFound},
+		{"http://invalid url", ErrUnavailable},
+	}
+	for _, test := range tests {
+		var data interface{}
+		err := client.GetJSON(context.Background(), test.url, &data)
+		var upstreamErr *Error
+		if !errors.Is(err, test.kind) || !errors.As(err, &upstreamErr) {
+			t.Errorf("GetJSON(%s) = %v, want an *Error of the kind %v", test.url, err, test.kind)
+		}
+	}
+}
+
+// Test that 5xx and 429 responses are retried, and other responses are not
+func TestRetries(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch {
+		case r.URL.Path == "/bad":
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+		case n == 1:
+			http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		case n == 2:
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		default:
+			w.Write([]byte(`ok`))
+		}
+	}))
+	defer server.Close()
+	client := testClient()
+
+	body, err := client.Get(context.Background(), server.URL+"/flaky")
+	if err != nil || string(body) != "ok" || requests.Load() != 3 {
+		t.Fatalf("Get() = %s, %v after %d requests, want ok after 3", body, err, requests.Load())
+	}
+
+	requests.Store(10)
+	_, err = client.Get(context.Background(), server.URL+"/bad")
+	var upstreamErr *Error
+	if !errors.As(err, &upstreamErr) || upstreamErr.StatusCode != http.StatusBadRequest || requests.Load() != 11 {
+		t.Errorf("Get() = %v after %d requests, want status %d after 1", err, requests.Load()-10, http.StatusBadRequest)
+	}
+}
+
+// Test that the breaker of a host opens after failures in a row, and closes when a trial request succeeds
+func TestCircuitBreaker(t *testing.T) {
+	var failing atomic.Bool
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if failing.Load() {
+			http.Error(w, "Bad Gateway", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`ok`))
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	client := testClient()
+	client.Retries = 0
+	client.FailureThreshold = 2
+	client.OpenFor = time.Minute
+	client.now = func() time.Time { return now }
+
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get(context.Background(), server.URL); !errors.Is(err, ErrUnavailable) {
+			t.Fatalf("Expected the upstream to be unavailable, got %v", err)
+		}
+	}
+	_, err := client.Get(context.Background(), server.URL)
+	if !errors.Is(err, ErrCircuitOpen) || requests.Load() != 2 {
+		t.Fatalf("Expected the open breaker to fail the request without sending it, got %v after %d requests", err, requests.Load())
+	}
+	status := client.Breakers()[host]
+	if status.State != BreakerOpen || status.Failures != 2 || status.RetryAt == nil || !status.RetryAt.Equal(now.Add(time.Minute)) {
+		t.Errorf("Unexpected breaker %+v", status)
+	}
+
+	// A failed trial opens the breaker again
+	now = now.Add(time.Minute)
+	if _, err := client.Get(context.Background(), server.URL); errors.Is(err, ErrCircuitOpen) || requests.Load() != 3 {
+		t.Fatalf("Expected a trial request, got %v after %d requests", err, requests.Load())
+	}
+	if _, err := client.Get(context.Background(), server.URL); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("Expected the breaker to open again, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	failing.Store(false)
+	if _, err := client.Get(context.Background(), server.URL); err != nil {
+		t.Fatal(err)
+	}
+	if status := client.Breakers()[host]; status.State != BreakerClosed || status.Failures != 0 || status.RetryAt != nil {
+		t.Errorf("Expected the breaker to close, got %+v", status)
+	}
+}
+
+// Test that identical requests in flight share one fetch, which is not given up with the request that started it
+func TestCoalesce(t *testing.T) {
+	var requests atomic.Int64
+	arrived := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		arrived <- struct{}{}
+		<-release
+		w.Write([]byte(`{"name":"Norway"}`))
+	}))
+	defer server.Close()
+	client := testClient()
+
+	// The request that starts the fetch gives up before the response arrives
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := client.Get(ctx, server.URL+"/norway")
+		done <- err
+	}()
+	<-arrived
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the cancelled request to fail with %v, got %v", context.Canceled, err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var data struct {
+				Name string `json:"name"`
+			}
+			if err := client.GetJSON(context.Background(), server.URL+"/norway", &data); err != nil || data.Name != "Norway" {
+				t.Errorf("GetJSON() = %+v, %v", data, err)
+			}
+		}()
+	}
+	// Gives the requests time to join the fetch in flight
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request upstream, got %d", requests.Load())
+	}
+	if client.Coalesced() != 10 {
+		t.Errorf("Expected 10 coalesced requests, got %d", client.Coalesced())
+	}
+}
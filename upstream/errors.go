@@ -0,0 +1,49 @@
+package upstream
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Kinds of the errors of requests to upstream APIs
+var (
+	// The upstream API has nothing at the URL
+	ErrNotFound = errors.New("not found")
+	// The upstream API could not be reached, failed or did not answer in time
+	ErrUnavailable = errors.New("upstream unavailable")
+	// The upstream API answered with a body that could not be decoded
+	ErrBadPayload = errors.New("bad payload")
+)
+
+// ErrCircuitOpen is the cause of requests that are not sent, since the host failed too often
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Error is a failed request to an upstream API. errors.Is matches both its kind and its cause
+type Error struct {
+	// URL that was requested
+	URL string
+	// Status code of the response, 0 if there was none
+	StatusCode int
+	// ErrNotFound, ErrUnavailable or ErrBadPayload
+	Kind error
+	// What caused the error, nil if the status code tells
+	Err error
+}
+
+func (e *Error) Error() string {
+	message := "GET " + e.URL + ": " + e.Kind.Error()
+	if e.StatusCode != 0 {
+		message += " (status " + strconv.Itoa(e.StatusCode) + ")"
+	}
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
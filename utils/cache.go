@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"assignment2/upstream"
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the upstream APIs whose responses are cached
+const (
+	CountriesSource = "countries"
+	GeocodingSource = "geocoding"
+	CurrencySource  = "currency"
+	ForecastSource  = "forecast"
+	// The air quality API of Open-Meteo
+	AirQualitySource = "airQuality"
+)
+
+// Default time responses of the upstream APIs are cached for. Countries and the coordinates of their capitals
+// rarely change, rates change daily and forecasts and air quality hourly
+const (
+	DefaultCountriesTTL  = 72 * time.Hour
+	DefaultGeocodingTTL  = 72 * time.Hour
+	DefaultCurrencyTTL   = 6 * time.Hour
+	DefaultForecastTTL   = 15 * time.Minute
+	DefaultAirQualityTTL = 15 * time.Minute
+)
+
+// Responses kept in memory before expired ones are pruned
+const maxCacheEntries = 10000
+
+// Time the shared store is given to answer, before the response is fetched from the upstream API instead
+const sharedCacheTimeout = 2 * time.Second
+
+// CachedResponse is the status code and body of a response of an upstream API, and when it expires
+type CachedResponse struct {
+	// Status code of the response, 0 for responses cached as successful before it was kept
+	Status  int       `json:"status,omitempty"`
+	Body    []byte    `json:"body"`
+	Expires time.Time `json:"expires"`
+}
+
+// Returns the response that was cached
+func (c CachedResponse) response() upstream.Response {
+	if c.Status == 0 {
+		return upstream.Response{StatusCode: http.StatusOK, Body: c.Body}
+	}
+	return upstream.Response{StatusCode: c.Status, Body: c.Body}
+}
+
+// SharedCache stores cached responses where every instance of the service finds them
+type SharedCache interface {
+	// Get returns the response cached under the key, and false if there is none
+	Get(ctx context.Context, key string) (CachedResponse, bool, error)
+	// Set caches the response under the key
+	Set(ctx context.Context, key string, response CachedResponse) error
+}
+
+// CacheSource is an upstream API whose responses are cached for TTL. URLs starting with Prefix belong to it
+type CacheSource struct {
+	Name   string
+	Prefix string
+	TTL    time.Duration
+}
+
+// CacheStats counts the requests for the responses of an upstream API that were found in the cache, and those that
+// had to be fetched
+type CacheStats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+}
+
+// Returns the sources of the upstream APIs the service uses, with the given TTLs
+func UpstreamSources(countries, geocoding, currency, forecast, airQuality time.Duration) []CacheSource {
+	return []CacheSource{
+		{Name: CountriesSource, Prefix: COUNTRIES_API, TTL: countries},
+		{Name: GeocodingSource, Prefix: GEOCODING_API, TTL: geocoding},
+		{Name: CurrencySource, Prefix: CURRENCY_API, TTL: currency},
+		{Name: ForecastSource, Prefix: FORECAST_API, TTL: forecast},
+		{Name: AirQualitySource, Prefix: AIR_QUALITY_API, TTL: airQuality},
+	}
+}
+
+/*
+ResponseCache keeps the responses of upstream APIs in memory, and in a shared store if there is one, until their source's
+TTL has passed. Only successful and not found responses are cached, so failures are retried on the next request
+*/
+type ResponseCache struct {
+	sources []CacheSource
+	shared  SharedCache
+	now     func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]CachedResponse
+	stats   map[string]*CacheStats
+}
+
+// NewResponseCache caches the responses of the sources. shared may be nil to only cache in memory
+func NewResponseCache(sources []CacheSource, shared SharedCache) *ResponseCache {
+	stats := make(map[string]*CacheStats, len(sources))
+	for _, source := range sources {
+		stats[source.Name] = &CacheStats{}
+	}
+	return &ResponseCache{
+		sources: sources,
+		shared:  shared,
+		now:     time.Now,
+		entries: make(map[string]CachedResponse),
+		stats:   stats,
+	}
+}
+
+// UpstreamCache is the cache of upstream.DefaultClient, nil if responses are not cached
+var UpstreamCache *ResponseCache
+
+// Returns the source the URL belongs to, and false if its responses are not cached
+func (c *ResponseCache) sourceOf(url string) (CacheSource, bool) {
+	for _, source := range c.sources {
+		if strings.HasPrefix(url, source.Prefix) && source.TTL > 0 {
+			return source, true
+		}
+	}
+	return CacheSource{}, false
+}
+
+// Returns the response to the URL from memory or from the shared store, and false if it is not cached
+func (c *ResponseCache) lookup(ctx context.Context, url string) (CachedResponse, bool) {
+	now := c.now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[url]
+	c.mu.Unlock()
+	if ok && now.Before(entry.Expires) {
+		return entry, true
+	}
+
+	if c.shared == nil {
+		return CachedResponse{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+	defer cancel()
+	entry, ok, err := c.shared.Get(ctx, url)
+	if err != nil {
+		log.Println("Error reading shared cache:", err)
+		return CachedResponse{}, false
+	}
+	if !ok || !now.Before(entry.Expires) {
+		return CachedResponse{}, false
+	}
+
+	// Kept in memory as well, until it expires in the shared store
+	c.store(url, entry)
+	return entry, true
+}
+
+// Keeps the response in memory. When the cache is full, expired responses are pruned, and if none have expired,
+// the cache starts over
+func (c *ResponseCache) store(url string, entry CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCacheEntries {
+		now := c.now()
+		for key, cached := range c.entries {
+			if !now.Before(cached.Expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= maxCacheEntries {
+			c.entries = make(map[string]CachedResponse)
+		}
+	}
+	c.entries[url] = entry
+}
+
+// Counts a hit or miss of the source
+func (c *ResponseCache) count(source string, hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if hit {
+		c.stats[source].Hits++
+	} else {
+		c.stats[source].Misses++
+	}
+}
+
+// Fetch returns the response to the URL from the cache if it is there, or calls fetch and caches what it returns
+func (c *ResponseCache) Fetch(ctx context.Context, url string,
+	fetch func(ctx context.Context, url string) (upstream.Response, error)) (upstream.Response, error) {
+	source, cached := c.sourceOf(url)
+	if !cached {
+		return fetch(ctx, url)
+	}
+
+	if entry, ok := c.lookup(ctx, url); ok {
+		c.count(source.Name, true)
+		return entry.response(), nil
+	}
+	c.count(source.Name, false)
+
+	response, err := fetch(ctx, url)
+	if err != nil || (response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNotFound) {
+		return response, err
+	}
+
+	entry := CachedResponse{Status: response.StatusCode, Body: response.Body, Expires: c.now().Add(source.TTL)}
+	c.store(url, entry)
+	if c.shared != nil {
+		ctx, cancel := context.WithTimeout(ctx, sharedCacheTimeout)
+		defer cancel()
+		if err := c.shared.Set(ctx, url, entry); err != nil {
+			log.Println("Error writing shared cache:", err)
+		}
+	}
+	return response, nil
+}
+
+// Stats returns the hits and misses of every source
+func (c *ResponseCache) Stats() map[string]CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]CacheStats, len(c.stats))
+	for name, counts := range c.stats {
+		stats[name] = *counts
+	}
+	return stats
+}
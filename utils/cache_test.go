@@ -0,0 +1,125 @@
+package utils
+
+import (
+	"assignment2/upstream"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Shared cache of the tests, kept in a map
+type mapCache struct {
+	mu        sync.Mutex
+	responses map[string]CachedResponse
+}
+
+// Get returns the response cached under the key
+func (c *mapCache) Get(_ context.Context, key string) (CachedResponse, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	response, ok := c.responses[key]
+	return response, ok, nil
+}
+
+// Set caches the response under the key
+func (c *mapCache) Set(_ context.Context, key string, response CachedResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses[key] = response
+	return nil
+}
+
+// Fetches the response to the URL with the default HTTP client
+func fetchURL(ctx context.Context, url string) (upstream.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return upstream.Response{}, err
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return upstream.Response{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	return upstream.Response{StatusCode: response.StatusCode, Body: body}, err
+}
+
+// Test of caching responses until their TTL has passed, and of counting hits and misses
+func TestResponseCache(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := NewResponseCache(UpstreamSources(72*time.Hour, 72*time.Hour, time.Hour, 15*time.Minute, 15*time.Minute), nil)
+	cache.now = func() time.Time { return now }
+
+	// Fetches the URL, and checks that a body was returned
+	fetch := func(url string) {
+		response, err := cache.Fetch(context.Background(), url, fetchURL)
+		if err != nil || len(response.Body) == 0 {
+			t.Fatalf("Fetch(%s) = %+v, %v", url, response, err)
+		}
+	}
+
+	fetch(COUNTRIES_API_NAME + "Norway")
+	fetch(COUNTRIES_API_NAME + "Norway")
+	fetch(CURRENCY_API + "NOK")
+
+	// Unknown currencies are not found, which is cached as well
+	fetch(CURRENCY_API + "XXX")
+	fetch(CURRENCY_API + "XXX")
+	if response, _ := cache.Fetch(context.Background(), CURRENCY_API+"XXX", fetchURL); response.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected the cached status %d, got %d", http.StatusNotFound, response.StatusCode)
+	}
+
+	// Rates expire before countries
+	now = now.Add(2 * time.Hour)
+	fetch(COUNTRIES_API_NAME + "Norway")
+	fetch(CURRENCY_API + "NOK")
+
+	stats := cache.Stats()
+	if stats[CountriesSource] != (CacheStats{Hits: 2, Misses: 1}) || stats[CurrencySource] != (CacheStats{Hits: 2, Misses: 3}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats[ForecastSource] != (CacheStats{}) {
+		t.Errorf("Expected no requests for forecasts, got %+v", stats[ForecastSource])
+	}
+}
+
+// Test that failed responses are not cached, and that responses are found in the shared cache by other instances
+func TestResponseCacheShared(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	shared := &mapCache{responses: make(map[string]CachedResponse)}
+	sources := []CacheSource{{Name: "test", Prefix: server.URL, TTL: time.Hour}}
+	first := NewResponseCache(sources, shared)
+	second := NewResponseCache(sources, shared)
+
+	for i := 0; i < 2; i++ {
+		if _, err := first.Fetch(context.Background(), server.URL+"/data", fetchURL); err != nil {
+			t.Fatal(err)
+		}
+	}
+	response, err := second.Fetch(context.Background(), server.URL+"/data", fetchURL)
+	if err != nil || string(response.Body) != `{"ok":true}` || response.StatusCode != http.StatusOK {
+		t.Fatalf("Fetch() from the shared cache = %+v, %v", response, err)
+	}
+
+	if requests != 2 {
+		t.Errorf("Expected 2 requests upstream, got %d", requests)
+	}
+	if stats := second.Stats()["test"]; stats != (CacheStats{Hits: 1}) {
+		t.Errorf("Expected a hit in the shared cache, got %+v", stats)
+	}
+}
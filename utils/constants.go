@@ -10,6 +10,8 @@ const COUNTRIES_API_ISOCODE = COUNTRIES_API + "alpha/"
 
 const FORECAST_API = "https://api.open-meteo.com/v1/forecast?"
 
+const AIR_QUALITY_API = "https://air-quality-api.open-meteo.com/v1/air-quality?"
+
 const CURRENCY_API = "http://129.241.150.113:9090/currency/"
 
 const DEFAULT_PATH = "/dashboard/v1/"
@@ -22,3 +24,9 @@ const DASHBOARD_PATH = DEFAULT_PATH + "dashboards/"
 const NOTIFICATION_PATH = DEFAULT_PATH + "notifications/"
 
 const STATUS_PATH = DEFAULT_PATH + "status/"
+
+const ADMIN_PATH = DEFAULT_PATH + "admin/"
+
+const API_KEY_PATH = ADMIN_PATH + "keys/"
+
+const AUDIT_PATH = DEFAULT_PATH + "audit"
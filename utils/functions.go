@@ -1,7 +1,8 @@
 package utils
 
 import (
-	"encoding/json"
+	"assignment2/upstream"
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -38,7 +39,7 @@ func WhatTimeNow() string {
 // will then replace with only the written in values, avoids multiple null values if they are not written in
 // returns the object with values, a bool to check if values are missing, and a string array containing all
 // names of the missing elements, to inform the user
-func UpdatedData(newObject *Firestore, myObject *Firestore, w http.ResponseWriter) (*Firestore, bool, []string) {
+func UpdatedData(ctx context.Context, newObject *Firestore, myObject *Firestore, w http.ResponseWriter) (*Firestore, bool, []string) {
 	checkIfMissingElements := false
 	missingElements := make([]string, 0)
 	if !IsEmptyField(myObject.Country) {
@@ -90,19 +91,132 @@ func UpdatedData(newObject *Firestore, myObject *Firestore, w http.ResponseWrite
 		missingElements = append(missingElements, "Population")
 	}
 	if !IsEmptyField(myObject.Features.TargetCurrencies) {
-		newObject.Features.TargetCurrencies = CheckCurrencies(myObject.Features.TargetCurrencies, w)
+		newObject.Features.TargetCurrencies = CheckCurrencies(ctx, myObject.Features.TargetCurrencies, w)
 	} else {
 		checkIfMissingElements = true
 		missingElements = append(missingElements, "Target Currencies")
 	}
+	//The other weather variables are optional, and only those written in are changed
+	if !IsEmptyField(myObject.Features.WindSpeed) {
+		newObject.Features.WindSpeed = myObject.Features.WindSpeed
+	}
+	if !IsEmptyField(myObject.Features.WindDirection) {
+		newObject.Features.WindDirection = myObject.Features.WindDirection
+	}
+	if !IsEmptyField(myObject.Features.Humidity) {
+		newObject.Features.Humidity = myObject.Features.Humidity
+	}
+	if !IsEmptyField(myObject.Features.CloudCover) {
+		newObject.Features.CloudCover = myObject.Features.CloudCover
+	}
+	if !IsEmptyField(myObject.Features.UVIndex) {
+		newObject.Features.UVIndex = myObject.Features.UVIndex
+	}
+	if !IsEmptyField(myObject.Features.ApparentTemperature) {
+		newObject.Features.ApparentTemperature = myObject.Features.ApparentTemperature
+	}
+	//The air quality is optional as well
+	if !IsEmptyField(myObject.Features.AirQuality) {
+		newObject.Features.AirQuality = myObject.Features.AirQuality
+	}
+	//The aggregations are optional, and those written in replace the ones of the same features
+	if len(myObject.Features.Aggregation) > 0 {
+		aggregation := make(map[string]string)
+		for feature, value := range newObject.Features.Aggregation {
+			aggregation[feature] = value
+		}
+		for feature, value := range myObject.Features.Aggregation {
+			aggregation[feature] = value
+		}
+		newObject.Features.Aggregation = aggregation
+	}
+	//The forecast is optional as well
+	if myObject.Features.Forecast != nil {
+		newObject.Features.Forecast = myObject.Features.Forecast
+	}
 	return newObject, checkIfMissingElements, missingElements
 
 }
 
+// Converts a configuration written by the user into the form that is stored,
+// features that have not been set are stored as false
+func ToDashboard(object *Firestore) Dashboard_Get {
+	return Dashboard_Get{
+		ID:      object.ID,
+		Country: object.Country,
+		IsoCode: object.IsoCode,
+		Features: Features_Get{
+			Temperature:         boolValue(object.Features.Temperature),
+			Precipitation:       boolValue(object.Features.Precipitation),
+			Capital:             boolValue(object.Features.Capital),
+			Coordinates:         boolValue(object.Features.Coordinates),
+			Population:          boolValue(object.Features.Population),
+			Area:                boolValue(object.Features.Area),
+			TargetCurrencies:    object.Features.TargetCurrencies,
+			WindSpeed:           boolValue(object.Features.WindSpeed),
+			WindDirection:       boolValue(object.Features.WindDirection),
+			Humidity:            boolValue(object.Features.Humidity),
+			CloudCover:          boolValue(object.Features.CloudCover),
+			UVIndex:             boolValue(object.Features.UVIndex),
+			ApparentTemperature: boolValue(object.Features.ApparentTemperature),
+			AirQuality:          boolValue(object.Features.AirQuality),
+			Aggregation:         object.Features.Aggregation,
+			Forecast:            intValue(object.Features.Forecast),
+		},
+		LastChange: object.LastChange,
+	}
+}
+
+// Converts a stored configuration into the form used for user input, so it can be merged using UpdatedData
+func FromDashboard(dashboard Dashboard_Get) Firestore {
+	return Firestore{
+		ID:      dashboard.ID,
+		Country: dashboard.Country,
+		IsoCode: dashboard.IsoCode,
+		Features: Features{
+			Temperature:         boolPointer(dashboard.Features.Temperature),
+			Precipitation:       boolPointer(dashboard.Features.Precipitation),
+			Capital:             boolPointer(dashboard.Features.Capital),
+			Coordinates:         boolPointer(dashboard.Features.Coordinates),
+			Population:          boolPointer(dashboard.Features.Population),
+			Area:                boolPointer(dashboard.Features.Area),
+			TargetCurrencies:    dashboard.Features.TargetCurrencies,
+			WindSpeed:           boolPointer(dashboard.Features.WindSpeed),
+			WindDirection:       boolPointer(dashboard.Features.WindDirection),
+			Humidity:            boolPointer(dashboard.Features.Humidity),
+			CloudCover:          boolPointer(dashboard.Features.CloudCover),
+			UVIndex:             boolPointer(dashboard.Features.UVIndex),
+			ApparentTemperature: boolPointer(dashboard.Features.ApparentTemperature),
+			AirQuality:          boolPointer(dashboard.Features.AirQuality),
+			Aggregation:         dashboard.Features.Aggregation,
+			Forecast:            &dashboard.Features.Forecast,
+		},
+		LastChange: dashboard.LastChange,
+	}
+}
+
+// Returns the value of a bool pointer, or false if it is nil
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
+
+// Returns a pointer to a copy of the bool
+func boolPointer(b bool) *bool {
+	return &b
+}
+
+// Returns the value of an int pointer, or 0 if it is nil
+func intValue(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
 // Function to check if currencies are valid. Will make them capitalized, '
 //
 //	and exclude the currencies that do not have a valid value
-func CheckCurrencies(arr []string, w http.ResponseWriter) []string {
+func CheckCurrencies(ctx context.Context, arr []string, w http.ResponseWriter) []string {
 
 	//Making a map that contains a bool, if the element has already
 	//been included or not
@@ -132,8 +246,12 @@ func CheckCurrencies(arr []string, w http.ResponseWriter) []string {
 			}
 			var a c
 
-			//Fetching data from currency api, and putting the data into the struct
-			err := FetchURLdata(url, w, &a)
+			//Fetching data from currency api, and putting the data into the struct. Unknown currencies are not found
+			err := upstream.GetJSON(ctx, url, &a)
+			if errors.Is(err, upstream.ErrNotFound) {
+				log.Println("Currency: " + myCurrency + " is not valid, is being excluded")
+				continue
+			}
 			if err != nil {
 				http.Error(w, "Failed to retrieve currency", http.StatusBadRequest)
 				return nil
@@ -153,7 +271,7 @@ func CheckCurrencies(arr []string, w http.ResponseWriter) []string {
 
 //Function that makes sure both country name and isocode matches
 
-func CheckCountry(countryName string, isoCode string, w http.ResponseWriter) (string, string, error) {
+func CheckCountry(ctx context.Context, countryName string, isoCode string) (string, string, error) {
 
 	//Creates variables for country name (if country is found), and url with country name for api
 	countryNameFound := true
@@ -168,7 +286,7 @@ func CheckCountry(countryName string, isoCode string, w http.ResponseWriter) (st
 	urlIso := fmt.Sprintf(COUNTRIES_API_ISOCODE+"%s", isoUrl)
 
 	//Fetching data from country api and putting it in a struct array
-	err := FetchURLdata(urlName, w, &CountryWithName)
+	err := upstream.GetJSON(ctx, urlName, &CountryWithName)
 
 	//If there is no such country, bool is set to false
 	if err != nil {
@@ -176,7 +294,7 @@ func CheckCountry(countryName string, isoCode string, w http.ResponseWriter) (st
 	}
 
 	//Fetching data from country api and putting it in a struct array
-	err1 := FetchURLdata(urlIso, w, &CountryWithIso)
+	err1 := upstream.GetJSON(ctx, urlIso, &CountryWithIso)
 
 	//If there is no such country, bool is set to false
 	if err1 != nil {
@@ -196,30 +314,45 @@ func CheckCountry(countryName string, isoCode string, w http.ResponseWriter) (st
 
 }
 
-/*
-Retrieves data from URL
-*/
-
-func FetchURLdata(myData string, w http.ResponseWriter, data interface{}) error {
-
-	//If the fetched data is from an API
-	response, err := http.Get(myData)
-	if err != nil {
-		return errors.New("failed to fetch url: " + myData)
+// Checks the features that have values to choose from: the aggregations and the days of the forecast
+func ValidateFeatures(features Features) error {
+	if err := ValidateAggregation(features.Aggregation); err != nil {
+		return err
 	}
-	defer response.Body.Close()
-	err = json.NewDecoder(response.Body).Decode(&data)
-	if err != nil {
-		return errors.New("failed to decode url: " + myData)
+	if features.Forecast != nil && (*features.Forecast < 0 || *features.Forecast > MaxForecastDays) {
+		return fmt.Errorf("invalid forecast of %d days. Use 1 to %d days, or 0 to not show it", *features.Forecast, MaxForecastDays)
+	}
+	return nil
+}
+
+// Checks that the aggregations are of weather features, and are ones that can be chosen for the feature
+func ValidateAggregation(aggregation map[string]string) error {
+	for feature, value := range aggregation {
+		if !contains(WeatherFeatures, feature) {
+			return fmt.Errorf("invalid aggregation: '%s' is not a weather feature. Use %s",
+				feature, strings.Join(WeatherFeatures, ", "))
+		}
+		allowed := FeatureAggregations[feature]
+		if !contains(allowed, value) {
+			return fmt.Errorf("invalid aggregation '%s' of %s. Use %s", value, feature, strings.Join(allowed, ", "))
+		}
 	}
-	//If the fetched data is from a JSON file
 	return nil
+}
 
+// Reports whether the value is one of the values
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // Function to check if event is valid
 func ValidateEvent(e string) bool {
-	return e == "REGISTER" || e == "INVOKE" || e == "CHANGE" || e == "DELETE"
+	return e == "REGISTER" || e == "INVOKE" || e == "CHANGE" || e == "DELETE" || e == "PURGE"
 }
 
 // Function to check if digit is written in, will be used for checking PORT with localhost url
@@ -1,12 +1,23 @@
 package utils
 
 import (
+	"assignment2/stub"
+	"context"
 	"errors"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 )
 
+// Answers requests for the third-party APIs with canned data while the tests run
+func TestMain(m *testing.M) {
+	restore := stub.Install(COUNTRIES_API, CURRENCY_API)
+	code := m.Run()
+	restore()
+	os.Exit(code)
+}
+
 // Test for CheckCurrencies function
 func TestCheckCurrencies(t *testing.T) {
 	// Create a ResponseRecorder to record the response.
@@ -16,7 +27,7 @@ func TestCheckCurrencies(t *testing.T) {
 	currencies := []string{"NOK", "EUR", "INVALID"}
 
 	// Call the function with the test data
-	result := CheckCurrencies(currencies, rr)
+	result := CheckCurrencies(context.Background(), currencies, rr)
 
 	// Check the result
 	expected := []string{"NOK", "EUR"}
@@ -102,7 +113,7 @@ func TestUpdatedData(t *testing.T) {
 
 	// Call the function
 	w := httptest.NewRecorder()
-	updatedObject, missing, missingElements := UpdatedData(emptyObject, filledObject, w)
+	updatedObject, missing, missingElements := UpdatedData(context.Background(), emptyObject, filledObject, w)
 
 	// Check that the fields are updated correctly
 	if updatedObject.Country != "Norway" || updatedObject.IsoCode != "NO" || *updatedObject.Features.Area != true {
@@ -113,11 +124,18 @@ func TestUpdatedData(t *testing.T) {
 		t.Errorf("Did not identify missing objects correctly")
 	}
 
+	// The weather variables are optional, and only changed when written in
+	filledObject.Features.WindSpeed = BoolPtr(true)
+	updatedObject, missing, _ = UpdatedData(context.Background(), &Firestore{Features: Features{Humidity: BoolPtr(true)}}, filledObject, w)
+	if missing || !*updatedObject.Features.WindSpeed || !*updatedObject.Features.Humidity || updatedObject.Features.UVIndex != nil {
+		t.Errorf("The weather variables were not merged correctly: %+v", updatedObject.Features)
+	}
+
 	// Make the Country field empty
 	filledObject.Country = ""
 	filledObject.IsoCode = ""
 	// Call the function
-	updatedObject, missing, missingElements = UpdatedData(emptyObject, filledObject, w)
+	updatedObject, missing, missingElements = UpdatedData(context.Background(), emptyObject, filledObject, w)
 
 	// Check that the fields are updated correctly
 	if !missing || len(missingElements) != 2 || missingElements[0] != "Country" || missingElements[1] != "IsoCode" {
@@ -128,13 +146,64 @@ func TestUpdatedData(t *testing.T) {
 	emptyFilledObject := &Firestore{}
 
 	// Call the function
-	updatedObject, missing, missingElements = UpdatedData(emptyObject, emptyFilledObject, w)
+	updatedObject, missing, missingElements = UpdatedData(context.Background(), emptyObject, emptyFilledObject, w)
 	// Check that the fields are updated correctly with more missing elements
 	if !missing || len(missingElements) != 9 {
 		t.Errorf("Did not identify missing objects correctly %v", missingElements)
 	}
 }
 
+// Test for ValidateAggregation function
+func TestValidateAggregation(t *testing.T) {
+	tests := []struct {
+		aggregation map[string]string
+		valid       bool
+	}{
+		{nil, true},
+		{map[string]string{"temperature": AggregationHourly, "precipitation": AggregationSum}, true},
+		{map[string]string{"temperature": "median"}, false},
+		{map[string]string{"temperature": AggregationSum}, false},
+		{map[string]string{"precipitation": AggregationMax}, true},
+		{map[string]string{"windDirection": AggregationMean, "uvIndex": AggregationMax}, true},
+		{map[string]string{"windDirection": AggregationMax}, false},
+		{map[string]string{"humidity": AggregationSum}, false},
+		{map[string]string{"area": AggregationMax}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateAggregation(tt.aggregation); (err == nil) != tt.valid {
+			t.Errorf("ValidateAggregation(%v) = %v, want valid %v", tt.aggregation, err, tt.valid)
+		}
+	}
+
+	// Every weather feature can be aggregated in some way
+	for _, feature := range WeatherFeatures {
+		if len(FeatureAggregations[feature]) == 0 {
+			t.Errorf("No aggregations of the weather feature %s", feature)
+		}
+	}
+}
+
+// Test for ValidateFeatures function
+func TestValidateFeatures(t *testing.T) {
+	days := func(n int) *int { return &n }
+	tests := []struct {
+		features Features
+		valid    bool
+	}{
+		{Features{}, true},
+		{Features{Forecast: days(0)}, true},
+		{Features{Forecast: days(MaxForecastDays)}, true},
+		{Features{Forecast: days(MaxForecastDays + 1)}, false},
+		{Features{Forecast: days(-1)}, false},
+		{Features{Aggregation: map[string]string{"temperature": "median"}, Forecast: days(7)}, false},
+	}
+	for _, tt := range tests {
+		if err := ValidateFeatures(tt.features); (err == nil) != tt.valid {
+			t.Errorf("ValidateFeatures(%+v) = %v, want valid %v", tt.features, err, tt.valid)
+		}
+	}
+}
+
 // Test for ValidateEvent function
 func TestValidateEvent(t *testing.T) {
 	// Create struc to contain name, argument and expected result
@@ -148,6 +217,7 @@ func TestValidateEvent(t *testing.T) {
 		{"Valid event INVOKE", "INVOKE", true},
 		{"Valid event CHANGE", "CHANGE", true},
 		{"Valid event DELETE", "DELETE", true},
+		{"Valid event PURGE", "PURGE", true},
 		{"Invalid event", "INVALID", false},
 	}
 
@@ -227,10 +297,8 @@ func TestCheckCountry(t *testing.T) {
 	// Loop through test cases
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			// Create a ResponseRecorder to record the response
-			rr := httptest.NewRecorder()
 			// Call the function
-			gotName, gotIso, gotErr := CheckCountry(tt.countryName, tt.isoCode, rr)
+			gotName, gotIso, gotErr := CheckCountry(context.Background(), tt.countryName, tt.isoCode)
 			if gotName != tt.wantName || gotIso != tt.wantIso || (gotErr != nil && gotErr.Error() != tt.wantErr.Error()) {
 				t.Errorf("CheckCountry() = %v, %v, %v, want %v, %v, %v", gotName, gotIso, gotErr, tt.wantName, tt.wantIso, tt.wantErr)
 			}
@@ -1,28 +1,70 @@
 package utils
 
 import (
-	"math/rand"
-	"time"
-)
+	"crypto/rand"
+	"errors"
+	"math/big"
 
-// initialize the package
-func init() {
-	// Seed rand with time, making it nondeterministic
-	rand.New(rand.NewSource(time.Now().UnixNano()))
-}
+	"github.com/oklog/ulid/v2"
+)
 
 // Runes list of characters to use for ID
 var Runes = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
 
-// GenerateUID returns a Unique Identifier that is 'n' long
-func GenerateUID(n int) string {
+// IDGenerator creates IDs for new documents in a collection.
+// Uniqueness is checked by the store, which asks for a new ID if the generated one is taken
+type IDGenerator interface {
+	NewID(collection string) (string, error)
+}
+
+// RandomIDs generates IDs of n characters from Runes, using a cryptographically secure random source
+type RandomIDs int
+
+// NewID implements IDGenerator
+func (n RandomIDs) NewID(string) (string, error) {
+	if n <= 0 {
+		return "", errors.New("length of random IDs must be positive")
+	}
+
 	// Make a slice that is 'n' long
 	b := make([]rune, n)
-	//loop through the slice and insert a random character at each index
+	max := big.NewInt(int64(len(Runes)))
+	// loop through the slice and insert a random character at each index
 	for i := range b {
-		b[i] = Runes[rand.Intn(len(Runes))]
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = Runes[index.Int64()]
 	}
 	// concatenate the slice to string
-	return string(b)
+	return string(b), nil
+}
+
+// ULIDs generates ULIDs: 26 characters that sort in the order the IDs were generated
+type ULIDs struct{}
+
+// NewID implements IDGenerator
+func (ULIDs) NewID(string) (string, error) {
+	id, err := ulid.New(ulid.Now(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return id.String(), nil
+}
+
+// PrefixedIDs puts the prefix of the collection, such as "dsh_", in front of the IDs generated by Next.
+// Collections without a prefix get the IDs of Next as they are
+type PrefixedIDs struct {
+	Prefixes map[string]string
+	Next     IDGenerator
+}
 
+// NewID implements IDGenerator
+func (p PrefixedIDs) NewID(collection string) (string, error) {
+	id, err := p.Next.NewID(collection)
+	if err != nil {
+		return "", err
+	}
+	return p.Prefixes[collection] + id, nil
 }
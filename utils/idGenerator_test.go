@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// Test for the random ID generator
+func TestRandomIDs(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := RandomIDs(8).NewID("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 8 || strings.Trim(id, string(Runes)) != "" {
+			t.Errorf("Expected 8 characters from Runes, got %q", id)
+		}
+		if seen[id] {
+			t.Errorf("ID %q was generated twice", id)
+		}
+		seen[id] = true
+	}
+
+	if _, err := RandomIDs(0).NewID(""); err == nil {
+		t.Error("Expected error for IDs of length 0")
+	}
+}
+
+// Test that ULIDs sort in the order they were generated
+func TestULIDs(t *testing.T) {
+	previous := ""
+	for i := 0; i < 10; i++ {
+		id, err := ULIDs{}.NewID("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 26 {
+			t.Errorf("Expected ULID of 26 characters, got %q", id)
+		}
+		// IDs generated in the same millisecond only share the time part
+		if previous != "" && id[:10] < previous[:10] {
+			t.Errorf("ULID %q sorts before the earlier %q", id, previous)
+		}
+		previous = id
+	}
+}
+
+// Test for the prefixed ID generator
+func TestPrefixedIDs(t *testing.T) {
+	ids := PrefixedIDs{Prefixes: map[string]string{"dashboards": "dsh_"}, Next: RandomIDs(5)}
+
+	id, err := ids.NewID("dashboards")
+	if err != nil || !strings.HasPrefix(id, "dsh_") || len(id) != 9 {
+		t.Errorf("Expected dsh_ and 5 characters, got %q (%v)", id, err)
+	}
+
+	id, err = ids.NewID("other")
+	if err != nil || len(id) != 5 {
+		t.Errorf("Expected 5 characters without prefix, got %q (%v)", id, err)
+	}
+}
@@ -1,6 +1,10 @@
 package utils
 
-import "time"
+import (
+	"assignment2/upstream"
+	"encoding/json"
+	"time"
+)
 
 // Struct for country API
 type CountryInfo struct {
@@ -20,6 +24,8 @@ type Dashboard_Get struct {
 	IsoCode    string       `json:"isoCode"`
 	Features   Features_Get `json:"features"`
 	LastChange time.Time    `json:"lastChange"`
+	Revision   int          `json:"revision"`
+	Owner      string       `json:"owner,omitempty"`
 }
 
 type Features_Get struct {
@@ -30,6 +36,109 @@ type Features_Get struct {
 	Population       bool     `json:"population,omitempty"`
 	Area             bool     `json:"area,omitempty"`
 	TargetCurrencies []string `json:"targetCurrencies,omitempty"`
+	// Weather variables shown besides temperature and precipitation
+	WindSpeed           bool `json:"windSpeed,omitempty"`
+	WindDirection       bool `json:"windDirection,omitempty"`
+	Humidity            bool `json:"humidity,omitempty"`
+	CloudCover          bool `json:"cloudCover,omitempty"`
+	UVIndex             bool `json:"uvIndex,omitempty"`
+	ApparentTemperature bool `json:"apparentTemperature,omitempty"`
+	// Whether the current air quality at the coordinates of the capital is shown
+	AirQuality bool `json:"airQuality,omitempty"`
+	// How the hourly values of the weather features are aggregated, keyed by feature. Default: mean
+	Aggregation map[string]string `json:"aggregation,omitempty"`
+	// Days of the daily forecast that are shown, 0 if it is not
+	Forecast int `json:"forecast,omitempty"`
+}
+
+// Names of the features that are switched on and off, as used in JSON
+var FeatureNames = []string{"temperature", "precipitation", "capital", "coordinates", "population", "area",
+	"windSpeed", "windDirection", "humidity", "cloudCover", "uvIndex", "apparentTemperature", "airQuality"}
+
+// Names of the weather features, whose hourly values are aggregated into the value shown on the dashboard
+var WeatherFeatures = []string{"temperature", "precipitation", "windSpeed", "windDirection", "humidity", "cloudCover",
+	"uvIndex", "apparentTemperature"}
+
+// Ways the hourly values of a weather feature are aggregated: their mean, minimum, maximum or daily sum,
+// or the hourly series itself
+const (
+	AggregationMean   = "mean"
+	AggregationMin    = "min"
+	AggregationMax    = "max"
+	AggregationSum    = "sum"
+	AggregationHourly = "hourly"
+)
+
+// Aggregations of the weather features that can be chosen
+var Aggregations = []string{AggregationMean, AggregationMin, AggregationMax, AggregationSum, AggregationHourly}
+
+// Aggregations that can be chosen of each weather feature, the others are meaningless for it. Only amounts, such as
+// precipitation, can be summed. Directions wrap around, so their minimum and maximum mean nothing, and their mean
+// is taken on the circle
+var FeatureAggregations = map[string][]string{
+	"temperature":         {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+	"precipitation":       Aggregations,
+	"windSpeed":           {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+	"windDirection":       {AggregationMean, AggregationHourly},
+	"humidity":            {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+	"cloudCover":          {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+	"uvIndex":             {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+	"apparentTemperature": {AggregationMean, AggregationMin, AggregationMax, AggregationHourly},
+}
+
+// Most days of the daily forecast that can be shown, as many as the forecast API has
+const MaxForecastDays = 16
+
+// AggregationOf returns how the hourly values of the weather feature are aggregated
+func (f Features_Get) AggregationOf(feature string) string {
+	if aggregation, ok := f.Aggregation[feature]; ok {
+		return aggregation
+	}
+	return AggregationMean
+}
+
+// Enabled reports whether the feature with the given JSON name is switched on, and whether the name is known
+func (f Features_Get) Enabled(name string) (enabled bool, known bool) {
+	switch name {
+	case "temperature":
+		return f.Temperature, true
+	case "precipitation":
+		return f.Precipitation, true
+	case "capital":
+		return f.Capital, true
+	case "coordinates":
+		return f.Coordinates, true
+	case "population":
+		return f.Population, true
+	case "area":
+		return f.Area, true
+	case "windSpeed":
+		return f.WindSpeed, true
+	case "windDirection":
+		return f.WindDirection, true
+	case "humidity":
+		return f.Humidity, true
+	case "cloudCover":
+		return f.CloudCover, true
+	case "uvIndex":
+		return f.UVIndex, true
+	case "apparentTemperature":
+		return f.ApparentTemperature, true
+	case "airQuality":
+		return f.AirQuality, true
+	default:
+		return false, false
+	}
+}
+
+// HasCurrency reports whether the currency is one of the target currencies
+func (f Features_Get) HasCurrency(currency string) bool {
+	for _, target := range f.TargetCurrencies {
+		if target == currency {
+			return true
+		}
+	}
+	return false
 }
 
 // Status Struct for status
@@ -37,10 +146,31 @@ type Status struct {
 	Countriesapi   int     `json:"countriesapi"`
 	Meteoapi       int     `json:"meteoapi"`
 	Currencyapi    int     `json:"currencyapi"`
+	Airqualityapi  int     `json:"airqualityapi"`
 	Notificationdb int     `json:"notificationdb"`
 	Webhooks       int     `json:"webhooks"`
 	Version        string  `json:"version"`
 	Uptime         float64 `json:"uptime"`
+	// Hits and misses of the cached responses of every upstream API, left out if they are not cached
+	Cache map[string]CacheStats `json:"cache,omitempty"`
+	// Upstream requests answered by the fetch of an identical request in flight
+	CoalescedRequests int64 `json:"coalescedRequests"`
+	// State of the circuit breakers of the upstream hosts requested so far, keyed by host
+	CircuitBreakers map[string]upstream.BreakerStatus `json:"circuitBreakers,omitempty"`
+}
+
+// DashboardSnapshot is the last dashboard rendered from a configuration, which is served when upstream APIs fail
+type DashboardSnapshot struct {
+	// Revision of the configuration the dashboard was rendered from
+	Revision int `json:"revision"`
+	// Values of the features, keyed by feature
+	Features map[string]SnapshotFeature `json:"features"`
+}
+
+// SnapshotFeature is the value of a feature as shown on a dashboard, and when it was fetched
+type SnapshotFeature struct {
+	Value     json.RawMessage `json:"value"`
+	FetchedAt time.Time       `json:"fetchedAt"`
 }
 
 type WebhookRegistration struct {
@@ -58,6 +188,35 @@ type WebhookGetResponse struct {
 	Url     string `json:"url"`
 	Country string `json:"country"`
 	Event   string `json:"event"`
+	Owner   string `json:"owner,omitempty"`
+}
+
+/*
+An API key, identifying the owner of the registrations and webhooks made with it, and the roles it is granted.
+Only the SHA-256 hash of its secret is stored
+*/
+type APIKey struct {
+	ID        string    `json:"id"`
+	Owner     string    `json:"owner"`
+	Roles     []string  `json:"roles"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+	Revoked   bool      `json:"revoked"`
+}
+
+/*
+An entry of the audit log: a change made by the actor to a registration or webhook, with the resource as it was before
+and after the change. Before is left out when the resource is created, and after when it is deleted
+*/
+type AuditRecord struct {
+	ID           string          `json:"id"`
+	Time         time.Time       `json:"time"`
+	Actor        string          `json:"actor"`
+	Action       string          `json:"action"`
+	ResourceType string          `json:"resourceType"`
+	ResourceID   string          `json:"resourceId"`
+	Before       json.RawMessage `json:"before,omitempty"`
+	After        json.RawMessage `json:"after,omitempty"`
 }
 
 type WebhookInvokeMessage struct {
@@ -69,7 +228,7 @@ type WebhookInvokeMessage struct {
 }
 
 type Firestore struct {
-	ID         string    `json:id`
+	ID         string    `json:"id"`
 	Country    string    `json:"country"`
 	Features   Features  `json:"features"`
 	IsoCode    string    `json:"isoCode"`
@@ -84,6 +243,19 @@ type Features struct {
 	Population       *bool    `json:"population"`
 	Area             *bool    `json:"area"`
 	TargetCurrencies []string `json:"targetCurrencies"`
+	// Weather variables shown besides temperature and precipitation, which are optional
+	WindSpeed           *bool `json:"windSpeed"`
+	WindDirection       *bool `json:"windDirection"`
+	Humidity            *bool `json:"humidity"`
+	CloudCover          *bool `json:"cloudCover"`
+	UVIndex             *bool `json:"uvIndex"`
+	ApparentTemperature *bool `json:"apparentTemperature"`
+	// Whether the current air quality is shown, which is optional
+	AirQuality *bool `json:"airQuality"`
+	// How the hourly values of the weather features are aggregated, keyed by feature
+	Aggregation map[string]string `json:"aggregation"`
+	// Days of the daily forecast that are shown, 0 to not show it
+	Forecast *int `json:"forecast"`
 }
 
 // Desired output for default handler
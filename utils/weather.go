@@ -0,0 +1,53 @@
+package utils
+
+// Hourly variables of the forecast API the weather features are shown from, keyed by feature
+var WeatherVariables = map[string]string{
+	"temperature":         "temperature_2m",
+	"precipitation":       "precipitation",
+	"windSpeed":           "wind_speed_10m",
+	"windDirection":       "wind_direction_10m",
+	"humidity":            "relative_humidity_2m",
+	"cloudCover":          "cloud_cover",
+	"uvIndex":             "uv_index",
+	"apparentTemperature": "apparent_temperature",
+}
+
+// Descriptions of the WMO weather codes used by the forecast API
+var weatherDescriptions = map[int]string{
+	0:  "Clear sky",
+	1:  "Mainly clear",
+	2:  "Partly cloudy",
+	3:  "Overcast",
+	45: "Fog",
+	48: "Depositing rime fog",
+	51: "Light drizzle",
+	53: "Moderate drizzle",
+	55: "Dense drizzle",
+	56: "Light freezing drizzle",
+	57: "Dense freezing drizzle",
+	61: "Slight rain",
+	63: "Moderate rain",
+	65: "Heavy rain",
+	66: "Light freezing rain",
+	67: "Heavy freezing rain",
+	71: "Slight snowfall",
+	73: "Moderate snowfall",
+	75: "Heavy snowfall",
+	77: "Snow grains",
+	80: "Slight rain showers",
+	81: "Moderate rain showers",
+	82: "Violent rain showers",
+	85: "Slight snow showers",
+	86: "Heavy snow showers",
+	95: "Thunderstorm",
+	96: "Thunderstorm with slight hail",
+	99: "Thunderstorm with heavy hail",
+}
+
+// WeatherDescription returns the description of the WMO weather code, or "Unknown" if the code is not known
+func WeatherDescription(code int) string {
+	if description, ok := weatherDescriptions[code]; ok {
+		return description
+	}
+	return "Unknown"
+}